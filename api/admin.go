@@ -0,0 +1,158 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"weatherapi.app/models"
+)
+
+// adminAuth requires the admin API key as a bearer token. The admin endpoints are
+// disabled when no key is configured.
+func (s *Server) adminAuth(c *gin.Context) {
+	key := s.config.AdminAPIKey
+	if key == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, models.ErrorResponse{Error: "admin API is disabled"})
+		return
+	}
+
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, models.ErrorResponse{Error: "invalid admin API key"})
+		return
+	}
+
+	c.Next()
+}
+
+func (s *Server) listSuppressions(c *gin.Context) {
+	suppressions, err := s.subscriptionService.ListSuppressions()
+	if err != nil {
+		fmt.Printf("[ERROR] List suppressions error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to list suppressions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, suppressions)
+}
+
+func (s *Server) addSuppression(c *gin.Context) {
+	var req models.SuppressionRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	suppression, err := s.subscriptionService.AddSuppression(req.Email, req.Reason)
+	if err != nil {
+		fmt.Printf("[ERROR] Add suppression error: %v\n", err)
+
+		if err.Error() == "email already suppressed" {
+			c.JSON(http.StatusConflict, models.ErrorResponse{Error: "email already suppressed"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to add suppression"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, suppression)
+}
+
+func (s *Server) removeSuppression(c *gin.Context) {
+	email := c.Param("email")
+
+	if err := s.subscriptionService.RemoveSuppression(email); err != nil {
+		fmt.Printf("[ERROR] Remove suppression error: %v\n", err)
+
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "suppression not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to remove suppression"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Suppression removed"})
+}
+
+// revokeTokens invalidates every link sent to a subscription, e.g. after a link was forwarded
+func (s *Server) revokeTokens(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid subscription id"})
+		return
+	}
+
+	revoked, err := s.subscriptionService.RevokeTokens(uint(id))
+	if err != nil {
+		fmt.Printf("[ERROR] Revoke tokens error: %v\n", err)
+
+		if err.Error() == "record not found" {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "subscription not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to revoke tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tokens revoked", "revoked": revoked})
+}
+
+// exportConsentEvents exports the consent audit trail, of one address with ?email=, as JSON
+// or with ?format=csv as a CSV file
+func (s *Server) exportConsentEvents(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "format must be json or csv"})
+		return
+	}
+
+	events, err := s.subscriptionService.ListConsentEvents(c.Query("email"))
+	if err != nil {
+		fmt.Printf("[ERROR] List consent events error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to list consent events"})
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, events)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="consent-events.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "email", "subscription_id", "city", "event", "ip", "user_agent", "created_at"})
+	for _, event := range events {
+		w.Write([]string{
+			strconv.FormatUint(uint64(event.ID), 10),
+			csvCell(event.Email),
+			strconv.FormatUint(uint64(event.SubscriptionID), 10),
+			csvCell(event.City),
+			event.Event,
+			csvCell(event.IP),
+			csvCell(event.UserAgent),
+			event.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Printf("[ERROR] Error writing consent events CSV: %v\n", err)
+	}
+}
+
+// csvCell keeps spreadsheet applications from evaluating a user supplied value as a formula
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
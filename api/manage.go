@@ -0,0 +1,100 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"weatherapi.app/models"
+)
+
+// requestManageLink emails a management link. It answers the same for unknown addresses and
+// for addresses that were sent a link within the cooldown, so it cannot be used to find out
+// who is subscribed.
+func (s *Server) requestManageLink(c *gin.Context) {
+	var req models.ManageLinkRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := s.subscriptionService.RequestManageLink(req.Email); err != nil {
+		fmt.Printf("[ERROR] Manage link error: %v\n", err)
+
+		if strings.Contains(err.Error(), "failed to send manage link email") {
+			c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{Error: "unable to send manage link email"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to request manage link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the address is subscribed, a link to manage its subscriptions has been sent."})
+}
+
+func (s *Server) listManagedSubscriptions(c *gin.Context) {
+	subscriptions, err := s.subscriptionService.ListManagedSubscriptions(c.Param("token"))
+	if err != nil {
+		s.handleManageError(c, err, "failed to list subscriptions")
+		return
+	}
+
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+func (s *Server) updateManagedSubscription(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid subscription id"})
+		return
+	}
+
+	var update models.SubscriptionUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	subscription, err := s.subscriptionService.UpdateManagedSubscription(c.Param("token"), uint(id), &update)
+	if err != nil {
+		s.handleManageError(c, err, "failed to update subscription")
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (s *Server) deleteManagedSubscription(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid subscription id"})
+		return
+	}
+
+	if err := s.subscriptionService.DeleteManagedSubscription(c.Param("token"), uint(id), requestMeta(c)); err != nil {
+		s.handleManageError(c, err, "failed to delete subscription")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Subscription deleted"})
+}
+
+// handleManageError writes the response for an error of the management endpoints
+func (s *Server) handleManageError(c *gin.Context, err error, fallback string) {
+	fmt.Printf("[ERROR] Manage error: %v\n", err)
+
+	switch err.Error() {
+	case "record not found":
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "not found"})
+	case "invalid token type":
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid token"})
+	case "email already subscribed":
+		c.JSON(http.StatusConflict, models.ErrorResponse{Error: "email already subscribed"})
+	case "city is required", "pause end must be in the future":
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: fallback})
+	}
+}
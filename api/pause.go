@@ -0,0 +1,86 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"weatherapi.app/models"
+)
+
+// confirmPause sends a pause link to the page that asks for confirmation, so link scanners
+// that open it pause nothing; the page pauses with a POST
+func (s *Server) confirmPause(c *gin.Context) {
+	query := url.Values{"action": {"pause"}, "token": {c.Param("token")}}
+	if until := c.Query("until"); until != "" {
+		query.Set("until", until)
+	}
+	c.Redirect(http.StatusSeeOther, "/pause?"+query.Encode())
+}
+
+// confirmResume sends a resume link to the page that asks for confirmation
+func (s *Server) confirmResume(c *gin.Context) {
+	query := url.Values{"action": {"resume"}, "token": {c.Param("token")}}
+	c.Redirect(http.StatusSeeOther, "/pause?"+query.Encode())
+}
+
+// pauseSubscription pauses the subscription of an unsubscribe token. With until=YYYY-MM-DD
+// it resumes by itself at the start of that day, UTC.
+func (s *Server) pauseSubscription(c *gin.Context) {
+	var req models.PauseRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "until must be a date in the format YYYY-MM-DD"})
+		return
+	}
+
+	var until *time.Time
+	if req.Until != "" {
+		date, err := time.Parse("2006-01-02", req.Until)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "until must be a date in the format YYYY-MM-DD"})
+			return
+		}
+		until = &date
+	}
+
+	subscription, err := s.subscriptionService.PauseSubscription(c.Param("token"), until)
+	if err != nil {
+		s.handlePauseError(c, err, "failed to pause subscription")
+		return
+	}
+
+	if subscription.PausedUntil != nil {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Updates for %s paused until %s", subscription.City, subscription.PausedUntil.Format("2006-01-02"))})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Updates for %s paused", subscription.City)})
+}
+
+// resumeSubscription resumes the subscription of an unsubscribe token
+func (s *Server) resumeSubscription(c *gin.Context) {
+	subscription, err := s.subscriptionService.ResumeSubscription(c.Param("token"))
+	if err != nil {
+		s.handlePauseError(c, err, "failed to resume subscription")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Updates for %s resumed", subscription.City)})
+}
+
+// handlePauseError writes the response for an error of the pause endpoints
+func (s *Server) handlePauseError(c *gin.Context, err error, fallback string) {
+	fmt.Printf("[ERROR] Pause error: %v\n", err)
+
+	switch err.Error() {
+	case "record not found":
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "token not found"})
+	case "invalid token type":
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid token"})
+	case "pause end must be in the future":
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: fallback})
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"weatherapi.app/models"
+)
+
+// requestPrivacyLink emails a data export or erasure link. Like requestManageLink, it answers
+// the same for unknown addresses and within the cooldown, so it cannot be used to find out who
+// is subscribed.
+func (s *Server) requestPrivacyLink(c *gin.Context) {
+	var req models.PrivacyRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := s.subscriptionService.RequestPrivacyLink(req.Email, req.Action); err != nil {
+		fmt.Printf("[ERROR] Privacy link error: %v\n", err)
+
+		if strings.Contains(err.Error(), "failed to send privacy link email") {
+			c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{Error: "unable to send privacy link email"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to request privacy link"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If we hold data about the address, a link has been sent to it."})
+}
+
+// exportPersonalData returns everything stored about the address of a data export token as
+// a JSON download
+func (s *Server) exportPersonalData(c *gin.Context) {
+	data, err := s.subscriptionService.ExportPersonalData(c.Param("token"))
+	if err != nil {
+		s.handlePrivacyError(c, err, "failed to export data")
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="weather-subscription-data.json"`)
+	c.JSON(http.StatusOK, data)
+}
+
+// erasePersonalData erases everything stored about the address of an erasure token. It only
+// accepts POST, so link scanners that open the emailed link cannot erase anything.
+func (s *Server) erasePersonalData(c *gin.Context) {
+	result, err := s.subscriptionService.ErasePersonalData(c.Param("token"))
+	if err != nil {
+		s.handlePrivacyError(c, err, "failed to erase data")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// handlePrivacyError writes the response for an error of the data export and erasure endpoints
+func (s *Server) handlePrivacyError(c *gin.Context, err error, fallback string) {
+	fmt.Printf("[ERROR] Privacy error: %v\n", err)
+
+	switch err.Error() {
+	case "record not found":
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "link expired or invalid"})
+	case "invalid token type":
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid token"})
+	default:
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: fallback})
+	}
+}
@@ -1,8 +1,14 @@
 package api
 
 import (
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -15,25 +21,45 @@ import (
 
 type Server struct {
 	router              *gin.Engine
+	httpServer          *http.Server
 	db                  *gorm.DB
 	config              *config.Config
 	weatherService      service.WeatherServiceInterface
 	subscriptionService service.SubscriptionServiceInterface
+	emailService        *service.EmailService
 }
 
 func NewServer(db *gorm.DB, config *config.Config) *Server {
 	router := gin.Default()
+	// Without trusted proxies gin believes X-Forwarded-For from anyone, which would let clients
+	// choose the IP recorded for consent and used for auto:ip
+	if err := router.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		fmt.Printf("[ERROR] Invalid trusted proxies, trusting none: %v\n", err)
+		router.SetTrustedProxies(nil)
+	}
 
 	weatherService := service.NewWeatherService(config)
 	emailService := service.NewEmailService(config)
 
 	subscriptionRepo := repository.NewSubscriptionRepository(db)
 	tokenRepo := repository.NewTokenRepository(db)
+	alertRepo := repository.NewAlertRepository(db)
+	linkRepo := repository.NewLinkRepository(db)
+	ruleRepo := repository.NewRuleRepository(db)
+	suppressionRepo := repository.NewSuppressionRepository(db)
+	consentRepo := repository.NewConsentRepository(db)
+	privacyRepo := repository.NewPrivacyRepository(db)
 
 	subscriptionService := service.NewSubscriptionService(
 		db,
 		subscriptionRepo,
 		tokenRepo,
+		alertRepo,
+		linkRepo,
+		ruleRepo,
+		suppressionRepo,
+		consentRepo,
+		privacyRepo,
 		emailService,
 		weatherService,
 		config,
@@ -41,10 +67,12 @@ func NewServer(db *gorm.DB, config *config.Config) *Server {
 
 	server := &Server{
 		router:              router,
+		httpServer:          &http.Server{Addr: fmt.Sprintf(":%d", config.Server.Port), Handler: router},
 		db:                  db,
 		config:              config,
 		weatherService:      weatherService,
 		subscriptionService: subscriptionService,
+		emailService:        emailService,
 	}
 
 	server.setupRoutes()
@@ -56,9 +84,33 @@ func (s *Server) setupRoutes() {
 	api := s.router.Group("/api")
 	{
 		api.GET("/weather", s.getWeather)
+		api.GET("/cities/search", s.searchCities)
 		api.POST("/subscribe", s.subscribe)
+		api.POST("/subscribe/resend", s.resendConfirmation)
 		api.GET("/confirm/:token", s.confirmSubscription)
 		api.GET("/unsubscribe/:token", s.unsubscribe)
+		api.POST("/unsubscribe/:token", s.unsubscribeOneClick)
+		api.GET("/pause/:token", s.confirmPause)
+		api.POST("/pause/:token", s.pauseSubscription)
+		api.GET("/resume/:token", s.confirmResume)
+		api.POST("/resume/:token", s.resumeSubscription)
+		api.POST("/webhooks/bounces/:provider", s.bounceWebhook)
+
+		api.POST("/manage/request", s.requestManageLink)
+		api.GET("/manage/:token/subscriptions", s.listManagedSubscriptions)
+		api.PATCH("/manage/:token/subscriptions/:id", s.updateManagedSubscription)
+		api.DELETE("/manage/:token/subscriptions/:id", s.deleteManagedSubscription)
+
+		api.POST("/privacy/request", s.requestPrivacyLink)
+		api.GET("/privacy/export/:token", s.exportPersonalData)
+		api.POST("/privacy/erase/:token", s.erasePersonalData)
+
+		admin := api.Group("/admin", s.adminAuth)
+		admin.GET("/suppressions", s.listSuppressions)
+		admin.POST("/suppressions", s.addSuppression)
+		admin.DELETE("/suppressions/:email", s.removeSuppression)
+		admin.DELETE("/subscriptions/:id/tokens", s.revokeTokens)
+		admin.GET("/consent-events", s.exportConsentEvents)
 
 		// Add a debug endpoint
 		api.GET("/debug", s.debugEndpoint)
@@ -67,8 +119,22 @@ func (s *Server) setupRoutes() {
 	s.ServeStaticFiles()
 }
 
+// Start serves the API until Shutdown is called
 func (s *Server) Start() error {
-	return s.router.Run(fmt.Sprintf(":%d", s.config.Server.Port))
+	fmt.Printf("[DEBUG] Listening on %s\n", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests, waits for the ones in progress and then closes the
+// connections of the email transport
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return err
+	}
+	return s.emailService.Close()
 }
 
 // GetRouter returns the router for testing purposes
@@ -76,15 +142,96 @@ func (s *Server) GetRouter() *gin.Engine {
 	return s.router
 }
 
-func (s *Server) getWeather(c *gin.Context) {
-	city := c.Query("city")
+// requestMeta describes the client of a request for the consent audit trail
+func requestMeta(c *gin.Context) models.RequestMeta {
+	return models.RequestMeta{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// parseLocationQuery builds a location from the lat/lon, postcode or city query parameters.
+// The special city value "auto:ip" resolves the location from the client's IP address, which
+// must be publicly routable.
+func parseLocationQuery(c *gin.Context) (models.LocationQuery, error) {
+	latParam, lonParam := c.Query("lat"), c.Query("lon")
+	if latParam != "" || lonParam != "" {
+		if latParam == "" || lonParam == "" {
+			return models.LocationQuery{}, fmt.Errorf("lat and lon must be provided together")
+		}
+		lat, err := strconv.ParseFloat(latParam, 64)
+		if err != nil || lat < -90 || lat > 90 {
+			return models.LocationQuery{}, fmt.Errorf("lat must be a number between -90 and 90")
+		}
+		lon, err := strconv.ParseFloat(lonParam, 64)
+		if err != nil || lon < -180 || lon > 180 {
+			return models.LocationQuery{}, fmt.Errorf("lon must be a number between -180 and 180")
+		}
+		return models.NewCoordinatesLocation(lat, lon), nil
+	}
+
+	if postcode := strings.TrimSpace(c.Query("postcode")); postcode != "" {
+		return models.NewPostalCodeLocation(postcode), nil
+	}
+
+	city := strings.TrimSpace(c.Query("city"))
 	if city == "" {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "city is required"})
+		return models.LocationQuery{}, fmt.Errorf("city is required")
+	}
+	if strings.EqualFold(city, "auto:ip") {
+		ip := publicClientIP(c)
+		if ip == "" {
+			return models.LocationQuery{}, fmt.Errorf("cannot determine location from client IP")
+		}
+		return models.NewIPLocation(ip), nil
+	}
+	return models.NewCityLocation(city), nil
+}
+
+// publicClientIP returns the client's IP address, or an empty string when it is not
+// publicly routable (e.g. local development) and cannot be geolocated by the provider
+func publicClientIP(c *gin.Context) string {
+	ip := net.ParseIP(c.ClientIP())
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return ""
+	}
+	return ip.String()
+}
+
+// parseWeatherOptions reads the units, lang and aqi query parameters
+func parseWeatherOptions(c *gin.Context) (models.WeatherOptions, error) {
+	options := models.NewWeatherOptions(c.Query("units"), c.Query("lang"))
+	switch c.DefaultQuery("aqi", "no") {
+	case "yes":
+		options.AirQuality = true
+	case "no":
+	default:
+		return models.WeatherOptions{}, fmt.Errorf("aqi must be yes or no")
+	}
+	if options.Units != models.UnitsMetric && options.Units != models.UnitsImperial {
+		return models.WeatherOptions{}, fmt.Errorf("units must be metric or imperial")
+	}
+	if !models.IsSupportedLanguage(options.Language) {
+		return models.WeatherOptions{}, fmt.Errorf("unsupported language")
+	}
+	return options, nil
+}
+
+func (s *Server) getWeather(c *gin.Context) {
+	location, err := parseLocationQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	options, err := parseWeatherOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	fmt.Printf("[DEBUG] Getting weather for city: %s\n", city)
-	weather, err := s.weatherService.GetWeather(city)
+	fmt.Printf("[DEBUG] Getting weather for %s location: %s\n", location.Type, location.Query())
+	weather, err := s.weatherService.GetWeather(location, options)
 	if err != nil {
 		fmt.Printf("[ERROR] Weather API error: %v\n", err)
 		if err.Error() == "city not found" {
@@ -99,6 +246,28 @@ func (s *Server) getWeather(c *gin.Context) {
 	c.JSON(http.StatusOK, weather)
 }
 
+func (s *Server) searchCities(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "q is required"})
+		return
+	}
+	if len([]rune(query)) < 2 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "q must be at least 2 characters"})
+		return
+	}
+
+	fmt.Printf("[DEBUG] Searching cities for query: %s\n", query)
+	cities, err := s.weatherService.SearchCities(query)
+	if err != nil {
+		fmt.Printf("[ERROR] City search error: %v\n", err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to search cities"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cities)
+}
+
 func (s *Server) subscribe(c *gin.Context) {
 	var req models.SubscriptionRequest
 	fmt.Println("[DEBUG] Handling subscription request")
@@ -113,13 +282,27 @@ func (s *Server) subscribe(c *gin.Context) {
 
 	fmt.Printf("[DEBUG] Subscription request received: %+v\n", req)
 
-	if err := s.subscriptionService.Subscribe(&req); err != nil {
+	if req.Language != "" && !models.IsSupportedLanguage(req.Language) {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "unsupported language"})
+		return
+	}
+
+	if err := s.subscriptionService.Subscribe(&req, requestMeta(c)); err != nil {
 		fmt.Printf("[ERROR] Subscription error: %v\n", err)
 
 		if err.Error() == "email already subscribed" {
 			c.JSON(http.StatusConflict, models.ErrorResponse{Error: "email already subscribed"})
 			return
 		}
+		if err.Error() == "email address is suppressed" {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "email address cannot be subscribed"})
+			return
+		}
+		if err.Error() == "confirmation resent too recently" {
+			c.Header("Retry-After", strconv.Itoa(s.config.ResendCooldown*60))
+			c.JSON(http.StatusTooManyRequests, models.ErrorResponse{Error: "confirmation email was sent recently, please try again later"})
+			return
+		}
 
 		if err.Error() == "failed to send confirmation email: failed to send email: 426 Upgrade Required" ||
 			strings.Contains(err.Error(), "failed to send confirmation email") {
@@ -135,6 +318,41 @@ func (s *Server) subscribe(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Subscription successful. Confirmation email sent."})
 }
 
+// resendMessage answers every resend request that is not rate limited or failing
+const resendMessage = "If the subscription is awaiting confirmation, a new confirmation email has been sent."
+
+// resendConfirmation emails a new confirmation link for an unconfirmed subscription
+func (s *Server) resendConfirmation(c *gin.Context) {
+	var req models.ResendRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := s.subscriptionService.ResendConfirmation(req.Email, req.City, req.Frequency); err != nil {
+		fmt.Printf("[ERROR] Resend confirmation error: %v\n", err)
+
+		switch {
+		// Unknown, confirmed and suppressed addresses get the same answer as a sent link, so
+		// the endpoint does not reveal who is subscribed
+		case err.Error() == "record not found",
+			err.Error() == "email already subscribed",
+			err.Error() == "email address is suppressed":
+			c.JSON(http.StatusOK, gin.H{"message": resendMessage})
+		case err.Error() == "confirmation resent too recently":
+			c.Header("Retry-After", strconv.Itoa(s.config.ResendCooldown*60))
+			c.JSON(http.StatusTooManyRequests, models.ErrorResponse{Error: "confirmation email was sent recently, please try again later"})
+		case strings.Contains(err.Error(), "failed to send confirmation email"):
+			c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{Error: "unable to send confirmation email"})
+		default:
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to resend confirmation"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": resendMessage})
+}
+
 func (s *Server) confirmSubscription(c *gin.Context) {
 	token := c.Param("token")
 	if token == "" {
@@ -142,9 +360,9 @@ func (s *Server) confirmSubscription(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("[DEBUG] Confirming subscription with token: %s\n", token)
+	fmt.Println("[DEBUG] Confirming subscription")
 
-	if err := s.subscriptionService.ConfirmSubscription(token); err != nil {
+	if err := s.subscriptionService.ConfirmSubscription(token, requestMeta(c)); err != nil {
 		fmt.Printf("[ERROR] Confirmation error: %v\n", err)
 
 		if err.Error() == "record not found" {
@@ -155,6 +373,10 @@ func (s *Server) confirmSubscription(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid token"})
 			return
 		}
+		if err.Error() == "email address is suppressed" {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "email address cannot be subscribed"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to confirm subscription"})
 		return
 	}
@@ -170,9 +392,39 @@ func (s *Server) unsubscribe(c *gin.Context) {
 		return
 	}
 
-	fmt.Printf("[DEBUG] Unsubscribing with token: %s\n", token)
+	fmt.Println("[DEBUG] Unsubscribing")
+
+	s.handleUnsubscribe(c, token)
+}
+
+// unsubscribeOneClick handles RFC 8058 one-click unsubscribe requests sent by mailbox
+// providers for the List-Unsubscribe-Post header
+func (s *Server) unsubscribeOneClick(c *gin.Context) {
+	token := c.Param("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "token is required"})
+		return
+	}
+
+	if c.PostForm("List-Unsubscribe") != "One-Click" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "List-Unsubscribe=One-Click is required"})
+		return
+	}
+
+	fmt.Println("[DEBUG] One-click unsubscribing")
 
-	if err := s.subscriptionService.Unsubscribe(token); err != nil {
+	s.handleUnsubscribe(c, token)
+}
+
+// handleUnsubscribe unsubscribes by token and writes the response. With scope=all the
+// address is unsubscribed from every city, as linked from weather update digests.
+func (s *Server) handleUnsubscribe(c *gin.Context, token string) {
+	unsubscribe := s.subscriptionService.Unsubscribe
+	if c.Query("scope") == "all" {
+		unsubscribe = s.subscriptionService.UnsubscribeAll
+	}
+
+	if err := unsubscribe(token, requestMeta(c)); err != nil {
 		fmt.Printf("[ERROR] Unsubscribe error: %v\n", err)
 
 		if err.Error() == "record not found" {
@@ -191,6 +443,55 @@ func (s *Server) unsubscribe(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Unsubscribed successfully"})
 }
 
+// maxWebhookBodySize bounds the size of bounce notifications
+const maxWebhookBodySize = 1 << 20
+
+// bounceWebhook receives bounce and complaint notifications from the email provider. The
+// shared secret is passed in the secret query parameter or the X-Webhook-Secret header.
+func (s *Server) bounceWebhook(c *gin.Context) {
+	provider := c.Param("provider")
+
+	secret := s.config.Email.BounceWebhookSecret
+	if secret == "" {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "bounce webhook is disabled"})
+		return
+	}
+
+	given := c.GetHeader("X-Webhook-Secret")
+	if given == "" {
+		given = c.Query("secret")
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "invalid webhook secret"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBodySize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "failed to read request body"})
+		return
+	}
+
+	fmt.Printf("[DEBUG] Bounce webhook called for provider: %s\n", provider)
+
+	if err := s.subscriptionService.ProcessBounceWebhook(provider, body); err != nil {
+		fmt.Printf("[ERROR] Bounce webhook error: %v\n", err)
+
+		if err.Error() == "unsupported provider" {
+			c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "unsupported provider"})
+			return
+		}
+		if err.Error() == "invalid notification" {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid notification"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "failed to process notification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification processed"})
+}
+
 // Debug endpoint to check configuration and connectivity
 func (s *Server) debugEndpoint(c *gin.Context) {
 	fmt.Println("[DEBUG] Debug endpoint called")
@@ -200,7 +501,7 @@ func (s *Server) debugEndpoint(c *gin.Context) {
 	dbErr := s.db.Model(&models.Subscription{}).Count(&subscriptionCount).Error
 
 	// Test weather API
-	weatherResponse, weatherErr := s.weatherService.GetWeather("London")
+	weatherResponse, weatherErr := s.weatherService.GetWeather(models.NewCityLocation("London"), models.NewWeatherOptions("", ""))
 
 	// Test SMTP configuration
 	smtpConfig := map[string]string{
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -16,6 +17,9 @@ import (
 	"weatherapi.app/service"
 )
 
+// defaultOptions are the weather options used when no units or lang are requested
+var defaultOptions = models.NewWeatherOptions("", "")
+
 // MockWeatherService for testing
 type mockWeatherService struct {
 	mock.Mock
@@ -24,14 +28,38 @@ type mockWeatherService struct {
 // Ensure mockWeatherService implements service.WeatherServiceInterface
 var _ service.WeatherServiceInterface = (*mockWeatherService)(nil)
 
-func (m *mockWeatherService) GetWeather(city string) (*models.WeatherResponse, error) {
-	args := m.Called(city)
+func (m *mockWeatherService) GetWeather(location models.LocationQuery, options models.WeatherOptions) (*models.WeatherResponse, error) {
+	args := m.Called(location, options)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*models.WeatherResponse), args.Error(1)
 }
 
+func (m *mockWeatherService) SearchCities(query string) ([]models.CitySearchResult, error) {
+	args := m.Called(query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.CitySearchResult), args.Error(1)
+}
+
+func (m *mockWeatherService) GetForecast(location models.LocationQuery, options models.WeatherOptions, days int) (*models.Forecast, error) {
+	args := m.Called(location, options, days)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Forecast), args.Error(1)
+}
+
+func (m *mockWeatherService) GetAlerts(location models.LocationQuery, options models.WeatherOptions) ([]models.WeatherAlert, error) {
+	args := m.Called(location, options)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.WeatherAlert), args.Error(1)
+}
+
 // Test for GET /weather endpoint
 func TestGetWeather(t *testing.T) {
 	// Set up Gin in test mode
@@ -57,7 +85,7 @@ func TestGetWeather(t *testing.T) {
 		Humidity:    76.0,
 		Description: "Partly cloudy",
 	}
-	mockService.On("GetWeather", "London").Return(expectedWeather, nil)
+	mockService.On("GetWeather", models.NewCityLocation("London"), defaultOptions).Return(expectedWeather, nil)
 	
 	// Create test request
 	req := httptest.NewRequest("GET", "/api/weather?city=London", nil)
@@ -100,7 +128,7 @@ func TestGetWeather_CityNotFound(t *testing.T) {
 	router.GET("/api/weather", server.getWeather)
 	
 	// Configure mock to return error
-	mockService.On("GetWeather", "NonExistentCity").Return(nil, fmt.Errorf("city not found"))
+	mockService.On("GetWeather", models.NewCityLocation("NonExistentCity"), defaultOptions).Return(nil, fmt.Errorf("city not found"))
 	
 	// Create request
 	req := httptest.NewRequest("GET", "/api/weather?city=NonExistentCity", nil)
@@ -153,35 +181,328 @@ func TestGetWeather_MissingCity(t *testing.T) {
 	assert.Equal(t, "city is required", errorResponse.Error)
 }
 
+// Test for GET /weather with coordinates, postal code and client IP locations
+func TestGetWeather_LocationQueries(t *testing.T) {
+	router, mockWeather, _ := setupTestServer()
+
+	weather := &models.WeatherResponse{Temperature: 15.0, Humidity: 76.0, Description: "Partly cloudy"}
+	mockWeather.On("GetWeather", models.NewCoordinatesLocation(51.52, -0.11), defaultOptions).Return(weather, nil)
+	mockWeather.On("GetWeather", models.NewPostalCodeLocation("SW1A 1AA"), defaultOptions).Return(weather, nil)
+	mockWeather.On("GetWeather", models.NewIPLocation("81.2.69.160"), defaultOptions).Return(weather, nil)
+
+	requests := []*http.Request{
+		httptest.NewRequest("GET", "/api/weather?lat=51.52&lon=-0.11", nil),
+		httptest.NewRequest("GET", "/api/weather?postcode=SW1A%201AA", nil),
+		httptest.NewRequest("GET", "/api/weather?city=auto:ip", nil),
+	}
+	requests[2].RemoteAddr = "81.2.69.160:51234"
+
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, req.URL.String())
+	}
+
+	// Loopback and private clients cannot be geolocated, so their location is not guessed
+	for _, remoteAddr := range []string{"127.0.0.1:51234", "10.0.0.5:51234"} {
+		req := httptest.NewRequest("GET", "/api/weather?city=auto:ip", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, remoteAddr)
+		assert.Contains(t, w.Body.String(), "cannot determine location from client IP")
+	}
+
+	mockWeather.AssertExpectations(t)
+}
+
+// Test for GET /weather with unit system and language
+func TestGetWeather_UnitsAndLanguage(t *testing.T) {
+	router, mockWeather, _ := setupTestServer()
+
+	weather := &models.WeatherResponse{Units: "imperial", Temperature: 59.0, Humidity: 76.0, Description: "Partiellement nuageux"}
+	mockWeather.On("GetWeather", models.NewCityLocation("Paris"), models.WeatherOptions{Units: "imperial", Language: "fr"}).Return(weather, nil)
+
+	req := httptest.NewRequest("GET", "/api/weather?city=Paris&units=imperial&lang=fr", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.WeatherResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "imperial", response.Units)
+	assert.Equal(t, 59.0, response.Temperature)
+
+	for _, path := range []string{"/api/weather?city=Paris&units=kelvin", "/api/weather?city=Paris&lang=xx"} {
+		req = httptest.NewRequest("GET", path, nil)
+		w = httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+
+	mockWeather.AssertExpectations(t)
+}
+
+// Test for GET /weather with air quality data
+func TestGetWeather_AirQuality(t *testing.T) {
+	router, mockWeather, _ := setupTestServer()
+
+	options := models.NewWeatherOptions("", "")
+	options.AirQuality = true
+	weather := &models.WeatherResponse{
+		Temperature: 15.0,
+		Humidity:    76.0,
+		Description: "Partly cloudy",
+		AirQuality:  &models.AirQuality{PM25: 6.4, PM10: 9.1, O3: 81.5, NO2: 14.9, USEPAIndex: 1},
+	}
+	mockWeather.On("GetWeather", models.NewCityLocation("London"), options).Return(weather, nil)
+
+	req := httptest.NewRequest("GET", "/api/weather?city=London&aqi=yes", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.WeatherResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, weather.AirQuality, response.AirQuality)
+
+	req = httptest.NewRequest("GET", "/api/weather?city=London&aqi=maybe", nil)
+	w = httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockWeather.AssertExpectations(t)
+}
+
+// Test for GET /weather with invalid coordinates
+func TestGetWeather_InvalidCoordinates(t *testing.T) {
+	router, mockWeather, _ := setupTestServer()
+
+	tests := map[string]string{
+		"/api/weather?lat=51.52":           "lat and lon must be provided together",
+		"/api/weather?lat=abc&lon=-0.11":   "lat must be a number between -90 and 90",
+		"/api/weather?lat=51.52&lon=200.5": "lon must be a number between -180 and 180",
+	}
+
+	for path, expectedError := range tests {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+
+		var errorResponse models.ErrorResponse
+		err := json.Unmarshal(w.Body.Bytes(), &errorResponse)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedError, errorResponse.Error)
+	}
+
+	mockWeather.AssertNotCalled(t, "GetWeather", mock.Anything, mock.Anything)
+}
+
+// Test for GET /cities/search endpoint
+func TestSearchCities(t *testing.T) {
+	router, mockWeather, _ := setupTestServer()
+
+	expected := []models.CitySearchResult{
+		{ID: 2801268, Name: "London", Region: "City of London, Greater London", Country: "United Kingdom", Lat: 51.52, Lon: -0.11},
+		{ID: 2796590, Name: "Londonderry", Region: "Londonderry", Country: "United Kingdom", Lat: 55, Lon: -7.32},
+	}
+	mockWeather.On("SearchCities", "Lond").Return(expected, nil)
+
+	req := httptest.NewRequest("GET", "/api/cities/search?q=Lond", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []models.CitySearchResult
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, response)
+
+	mockWeather.AssertExpectations(t)
+}
+
+// Test for GET /cities/search endpoint with a missing or too short query
+func TestSearchCities_InvalidQuery(t *testing.T) {
+	router, mockWeather, _ := setupTestServer()
+
+	for _, path := range []string{"/api/cities/search", "/api/cities/search?q=L"} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+
+	mockWeather.AssertNotCalled(t, "SearchCities", mock.Anything)
+}
+
 // MockSubscriptionService implements a mock subscription service for testing
 type mockSubscriptionService struct {
 	mock.Mock
 }
 
-func (m *mockSubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
-	args := m.Called(req)
+func (m *mockSubscriptionService) Subscribe(req *models.SubscriptionRequest, meta models.RequestMeta) error {
+	args := m.Called(req, meta)
 	return args.Error(0)
 }
 
-func (m *mockSubscriptionService) ConfirmSubscription(token string) error {
-	args := m.Called(token)
+func (m *mockSubscriptionService) ResendConfirmation(email, city, frequency string) error {
+	args := m.Called(email, city, frequency)
 	return args.Error(0)
 }
 
-func (m *mockSubscriptionService) Unsubscribe(token string) error {
-	args := m.Called(token)
+func (m *mockSubscriptionService) ConfirmSubscription(token string, meta models.RequestMeta) error {
+	args := m.Called(token, meta)
 	return args.Error(0)
 }
 
+func (m *mockSubscriptionService) Unsubscribe(token string, meta models.RequestMeta) error {
+	args := m.Called(token, meta)
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) UnsubscribeAll(token string, meta models.RequestMeta) error {
+	args := m.Called(token, meta)
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) PauseSubscription(token string, until *time.Time) (*models.Subscription, error) {
+	args := m.Called(token, until)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Subscription), args.Error(1)
+}
+
+func (m *mockSubscriptionService) ResumeSubscription(token string) (*models.Subscription, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Subscription), args.Error(1)
+}
+
 func (m *mockSubscriptionService) SendWeatherUpdate(frequency string) error {
 	args := m.Called(frequency)
 	return args.Error(0)
 }
 
+func (m *mockSubscriptionService) CheckWeatherAlerts() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) EvaluateNotificationRules() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) ProcessBounceWebhook(provider string, body []byte) error {
+	args := m.Called(provider, body)
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) RequestManageLink(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) ListManagedSubscriptions(token string) ([]models.Subscription, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Subscription), args.Error(1)
+}
+
+func (m *mockSubscriptionService) UpdateManagedSubscription(token string, id uint, update *models.SubscriptionUpdate) (*models.Subscription, error) {
+	args := m.Called(token, id, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Subscription), args.Error(1)
+}
+
+func (m *mockSubscriptionService) DeleteManagedSubscription(token string, id uint, meta models.RequestMeta) error {
+	args := m.Called(token, id)
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) ListSuppressions() ([]models.Suppression, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Suppression), args.Error(1)
+}
+
+func (m *mockSubscriptionService) AddSuppression(email, reason string) (*models.Suppression, error) {
+	args := m.Called(email, reason)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Suppression), args.Error(1)
+}
+
+func (m *mockSubscriptionService) RemoveSuppression(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) ListConsentEvents(email string) ([]models.ConsentEvent, error) {
+	args := m.Called(email)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.ConsentEvent), args.Error(1)
+}
+
+func (m *mockSubscriptionService) RequestPrivacyLink(email, action string) error {
+	args := m.Called(email, action)
+	return args.Error(0)
+}
+
+func (m *mockSubscriptionService) ExportPersonalData(token string) (*models.PersonalData, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.PersonalData), args.Error(1)
+}
+
+func (m *mockSubscriptionService) ErasePersonalData(token string) (*models.ErasureResult, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.ErasureResult), args.Error(1)
+}
+
+func (m *mockSubscriptionService) RevokeTokens(subscriptionID uint) (int64, error) {
+	args := m.Called(subscriptionID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // Helper function to set up a test server with mocks
 func setupTestServer() (*gin.Engine, *mockWeatherService, *mockSubscriptionService) {
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
+	router.SetTrustedProxies(nil)
 	
 	mockWeather := new(mockWeatherService)
 	mockSubscription := new(mockSubscriptionService)
@@ -190,14 +511,41 @@ func setupTestServer() (*gin.Engine, *mockWeatherService, *mockSubscriptionServi
 		router:              router,
 		weatherService:      mockWeather,
 		subscriptionService: mockSubscription,
-		config:              &config.Config{AppBaseURL: "http://localhost:8080"},
+		config: &config.Config{
+			AppBaseURL:  "http://localhost:8080",
+			AdminAPIKey: "admin-key",
+			Email:       config.EmailConfig{BounceWebhookSecret: "webhook-secret"},
+
+			ResendCooldown: 5,
+		},
 	}
 	
 	// Set up routes
 	router.GET("/api/weather", server.getWeather)
+	router.GET("/api/cities/search", server.searchCities)
 	router.POST("/api/subscribe", server.subscribe)
+	router.POST("/api/subscribe/resend", server.resendConfirmation)
 	router.GET("/api/confirm/:token", server.confirmSubscription)
 	router.GET("/api/unsubscribe/:token", server.unsubscribe)
+	router.POST("/api/unsubscribe/:token", server.unsubscribeOneClick)
+	router.GET("/api/pause/:token", server.confirmPause)
+	router.POST("/api/pause/:token", server.pauseSubscription)
+	router.GET("/api/resume/:token", server.confirmResume)
+	router.POST("/api/resume/:token", server.resumeSubscription)
+	router.POST("/api/manage/request", server.requestManageLink)
+	router.GET("/api/manage/:token/subscriptions", server.listManagedSubscriptions)
+	router.PATCH("/api/manage/:token/subscriptions/:id", server.updateManagedSubscription)
+	router.DELETE("/api/manage/:token/subscriptions/:id", server.deleteManagedSubscription)
+	router.POST("/api/privacy/request", server.requestPrivacyLink)
+	router.GET("/api/privacy/export/:token", server.exportPersonalData)
+	router.POST("/api/privacy/erase/:token", server.erasePersonalData)
+	router.POST("/api/webhooks/bounces/:provider", server.bounceWebhook)
+	admin := router.Group("/api/admin", server.adminAuth)
+	admin.GET("/suppressions", server.listSuppressions)
+	admin.POST("/suppressions", server.addSuppression)
+	admin.DELETE("/suppressions/:email", server.removeSuppression)
+	admin.DELETE("/subscriptions/:id/tokens", server.revokeTokens)
+	admin.GET("/consent-events", server.exportConsentEvents)
 	
 	return router, mockWeather, mockSubscription
 }
@@ -207,7 +555,7 @@ func TestSubscribe_Success(t *testing.T) {
 	router, _, mockSubscription := setupTestServer()
 	
 	// Configure mock to return success
-	mockSubscription.On("Subscribe", mock.Anything).Return(nil)
+	mockSubscription.On("Subscribe", mock.Anything, mock.Anything).Return(nil)
 	
 	// Create form data for request
 	formData := "email=test%40example.com&city=London&frequency=daily"
@@ -234,12 +582,92 @@ func TestSubscribe_Success(t *testing.T) {
 	mockSubscription.AssertExpectations(t)
 }
 
+// Test for POST /subscribe endpoint with coordinates instead of a city
+func TestSubscribe_ByCoordinates(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	mockSubscription.On("Subscribe", mock.MatchedBy(func(req *models.SubscriptionRequest) bool {
+		return req.City == "" && req.Latitude != nil && *req.Latitude == 51.52 &&
+			req.Longitude != nil && *req.Longitude == -0.11
+	}), mock.Anything).Return(nil)
+
+	formData := "email=test%40example.com&lat=51.52&lon=-0.11&frequency=daily"
+
+	req := httptest.NewRequest("POST", "/api/subscribe", strings.NewReader(formData))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockSubscription.AssertExpectations(t)
+
+	// Neither a city nor coordinates
+	formData = "email=test%40example.com&lat=51.52&frequency=daily"
+
+	req = httptest.NewRequest("POST", "/api/subscribe", strings.NewReader(formData))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// Test for POST /subscribe endpoint with notification rules in a JSON body
+func TestSubscribe_WithRules(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	mockSubscription.On("Subscribe", mock.MatchedBy(func(req *models.SubscriptionRequest) bool {
+		return len(req.Rules) == 2 &&
+			req.Rules[0].Metric == "min_temp" && req.Rules[0].Operator == "lt" && *req.Rules[0].Threshold == 0 && req.Rules[0].Day == 1 &&
+			req.Rules[1].Metric == "rain_chance" && req.Rules[1].Operator == "gt" && *req.Rules[1].Threshold == 70
+	}), mock.Anything).Return(nil)
+
+	body := `{
+		"email": "test@example.com",
+		"city": "London",
+		"frequency": "daily",
+		"rules": [
+			{"metric": "min_temp", "operator": "lt", "threshold": 0, "day": 1},
+			{"metric": "rain_chance", "operator": "gt", "threshold": 70}
+		]
+	}`
+
+	req := httptest.NewRequest("POST", "/api/subscribe", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockSubscription.AssertExpectations(t)
+
+	invalidRules := []string{
+		`[{"metric": "humidity", "operator": "gt", "threshold": 90}]`,
+		`[{"metric": "min_temp", "operator": "eq", "threshold": 0}]`,
+		`[{"metric": "min_temp", "operator": "lt"}]`,
+		`[{"metric": "min_temp", "operator": "lt", "threshold": 0, "day": 7}]`,
+	}
+	for _, rules := range invalidRules {
+		body = `{"email": "test@example.com", "city": "London", "frequency": "daily", "rules": ` + rules + `}`
+
+		req = httptest.NewRequest("POST", "/api/subscribe", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, rules)
+	}
+}
+
 // Test for POST /subscribe endpoint with already subscribed email
 func TestSubscribe_AlreadySubscribed(t *testing.T) {
 	router, _, mockSubscription := setupTestServer()
 	
 	// Configure mock to return duplicate subscription error
-	mockSubscription.On("Subscribe", mock.Anything).Return(fmt.Errorf("email already subscribed"))
+	mockSubscription.On("Subscribe", mock.Anything, mock.Anything).Return(fmt.Errorf("email already subscribed"))
 	
 	// Create form data for request
 	formData := "email=test%40example.com&city=London&frequency=daily"
@@ -271,10 +699,13 @@ func TestConfirmSubscription_Success(t *testing.T) {
 	
 	// Configure mock to return success
 	token := "valid-confirmation-token"
-	mockSubscription.On("ConfirmSubscription", token).Return(nil)
+	meta := models.RequestMeta{IP: "192.0.2.1", UserAgent: "test-agent"}
+	mockSubscription.On("ConfirmSubscription", token, meta).Return(nil)
 	
 	// Create test request
 	req := httptest.NewRequest("GET", "/api/confirm/"+token, nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "test-agent")
 	w := httptest.NewRecorder()
 	
 	// Serve the request
@@ -294,13 +725,42 @@ func TestConfirmSubscription_Success(t *testing.T) {
 	mockSubscription.AssertExpectations(t)
 }
 
+// TestRequestMeta_TrustedProxies tests that X-Forwarded-For is only believed from trusted proxies
+func TestRequestMeta_TrustedProxies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		proxies []string
+		wantIP  string
+	}{
+		{"no trusted proxies", nil, "192.0.2.1"},
+		{"trusted proxy", []string{"192.0.2.0/24"}, "203.0.113.50"},
+		{"other proxy", []string{"198.51.100.1"}, "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		router := gin.New()
+		assert.NoError(t, router.SetTrustedProxies(tt.proxies))
+		var meta models.RequestMeta
+		router.GET("/meta", func(c *gin.Context) { meta = requestMeta(c) })
+
+		req := httptest.NewRequest("GET", "/meta", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", "203.0.113.50")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, tt.wantIP, meta.IP, tt.name)
+	}
+}
+
 // Test for GET /confirm/:token endpoint with invalid token
 func TestConfirmSubscription_InvalidToken(t *testing.T) {
 	router, _, mockSubscription := setupTestServer()
 	
 	// Configure mock to return invalid token error
 	token := "invalid-token"
-	mockSubscription.On("ConfirmSubscription", token).Return(fmt.Errorf("invalid token type"))
+	mockSubscription.On("ConfirmSubscription", token, mock.Anything).Return(fmt.Errorf("invalid token type"))
 	
 	// Create test request
 	req := httptest.NewRequest("GET", "/api/confirm/"+token, nil)
@@ -328,7 +788,7 @@ func TestUnsubscribe_Success(t *testing.T) {
 	
 	// Configure mock to return success
 	token := "valid-unsubscribe-token"
-	mockSubscription.On("Unsubscribe", token).Return(nil)
+	mockSubscription.On("Unsubscribe", token, mock.Anything).Return(nil)
 	
 	// Create test request
 	req := httptest.NewRequest("GET", "/api/unsubscribe/"+token, nil)
@@ -350,3 +810,583 @@ func TestUnsubscribe_Success(t *testing.T) {
 	// Verify mock expectations
 	mockSubscription.AssertExpectations(t)
 }
+
+// Test for RFC 8058 one-click POST /unsubscribe endpoint
+func TestUnsubscribe_OneClick(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	token := "valid-unsubscribe-token"
+	mockSubscription.On("Unsubscribe", token, mock.Anything).Return(nil)
+
+	req := httptest.NewRequest("POST", "/api/unsubscribe/"+token, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockSubscription.AssertExpectations(t)
+
+	// Unknown tokens are reported like the GET endpoint does
+	mockSubscription.On("Unsubscribe", "unknown-token", mock.Anything).Return(fmt.Errorf("record not found"))
+
+	req = httptest.NewRequest("POST", "/api/unsubscribe/unknown-token", strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// Test that scope=all unsubscribes from every city, also through one-click unsubscribe
+func TestUnsubscribe_All(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	token := "valid-unsubscribe-token"
+	mockSubscription.On("UnsubscribeAll", token, mock.Anything).Return(nil).Twice()
+
+	req := httptest.NewRequest("GET", "/api/unsubscribe/"+token+"?scope=all", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("POST", "/api/unsubscribe/"+token+"?scope=all", strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockSubscription.AssertExpectations(t)
+	mockSubscription.AssertNotCalled(t, "Unsubscribe", mock.Anything, mock.Anything)
+}
+
+// Test that POST /unsubscribe requires the one-click body
+func TestUnsubscribe_OneClickInvalidBody(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	bodies := []string{"", "List-Unsubscribe=Yes", "unsubscribe=One-Click"}
+	for _, body := range bodies {
+		req := httptest.NewRequest("POST", "/api/unsubscribe/valid-unsubscribe-token", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	mockSubscription.AssertNotCalled(t, "Unsubscribe", mock.Anything, mock.Anything)
+}
+
+// Test for POST /webhooks/bounces endpoint
+func TestBounceWebhook(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	body := `[{"email":"bounced@example.com","event":"bounce"}]`
+	mockSubscription.On("ProcessBounceWebhook", "sendgrid", []byte(body)).Return(nil)
+	mockSubscription.On("ProcessBounceWebhook", "ses", []byte("not json")).Return(fmt.Errorf("invalid notification"))
+	mockSubscription.On("ProcessBounceWebhook", "postmark", mock.Anything).Return(fmt.Errorf("unsupported provider"))
+
+	tests := []struct {
+		name     string
+		path     string
+		secret   string
+		body     string
+		expected int
+	}{
+		{"header secret", "/api/webhooks/bounces/sendgrid", "webhook-secret", body, http.StatusOK},
+		{"query secret", "/api/webhooks/bounces/sendgrid?secret=webhook-secret", "", body, http.StatusOK},
+		{"missing secret", "/api/webhooks/bounces/sendgrid", "", body, http.StatusUnauthorized},
+		{"wrong secret", "/api/webhooks/bounces/sendgrid", "wrong", body, http.StatusUnauthorized},
+		{"invalid notification", "/api/webhooks/bounces/ses", "webhook-secret", "not json", http.StatusBadRequest},
+		{"unsupported provider", "/api/webhooks/bounces/postmark", "webhook-secret", body, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		if tt.secret != "" {
+			req.Header.Set("X-Webhook-Secret", tt.secret)
+		}
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.expected, w.Code, tt.name)
+	}
+
+	mockSubscription.AssertNumberOfCalls(t, "ProcessBounceWebhook", 4)
+}
+
+// Test that the bounce webhook is disabled without a secret
+func TestBounceWebhook_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	mockSubscription := new(mockSubscriptionService)
+
+	server := &Server{
+		router:              router,
+		subscriptionService: mockSubscription,
+		config:              &config.Config{},
+	}
+	router.POST("/api/webhooks/bounces/:provider", server.bounceWebhook)
+
+	req := httptest.NewRequest("POST", "/api/webhooks/bounces/sendgrid?secret=", strings.NewReader("[]"))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockSubscription.AssertNotCalled(t, "ProcessBounceWebhook", mock.Anything, mock.Anything)
+}
+
+// Test that suppressed addresses cannot subscribe
+func TestSubscribe_Suppressed(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	mockSubscription.On("Subscribe", mock.Anything, mock.Anything).Return(fmt.Errorf("email address is suppressed"))
+
+	req := httptest.NewRequest("POST", "/api/subscribe", strings.NewReader("email=blocked%40example.com&city=London&frequency=daily"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+// Test that the admin endpoints require the admin API key
+func TestAdminAuth(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	for _, header := range []string{"", "Bearer wrong-key", "admin-key"} {
+		req := httptest.NewRequest("GET", "/api/admin/suppressions", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, header)
+	}
+
+	mockSubscription.AssertNotCalled(t, "ListSuppressions")
+}
+
+// Test for the admin suppression list endpoints
+func TestAdminSuppressions(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	suppression := &models.Suppression{ID: 1, Email: "spam@example.com", Reason: "abuse", Source: models.SuppressionSourceAdmin}
+	mockSubscription.On("ListSuppressions").Return([]models.Suppression{*suppression}, nil)
+	mockSubscription.On("AddSuppression", "spam@example.com", "abuse").Return(suppression, nil).Once()
+	mockSubscription.On("AddSuppression", "spam@example.com", "abuse").Return(nil, fmt.Errorf("email already suppressed"))
+	mockSubscription.On("RemoveSuppression", "spam@example.com").Return(nil)
+	mockSubscription.On("RemoveSuppression", "unknown@example.com").Return(fmt.Errorf("record not found"))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-key")
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("GET", "/api/admin/suppressions", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var suppressions []models.Suppression
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &suppressions))
+	assert.Equal(t, []models.Suppression{*suppression}, suppressions)
+
+	w = send("POST", "/api/admin/suppressions", `{"email":"spam@example.com","reason":"abuse"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = send("POST", "/api/admin/suppressions", `{"email":"spam@example.com","reason":"abuse"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = send("POST", "/api/admin/suppressions", `{"email":"not-an-email"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send("DELETE", "/api/admin/suppressions/spam@example.com", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("DELETE", "/api/admin/suppressions/unknown@example.com", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	mockSubscription.AssertExpectations(t)
+}
+
+// Test for the admin endpoint revoking the tokens of a subscription
+func TestAdminRevokeTokens(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	mockSubscription.On("RevokeTokens", uint(1)).Return(int64(2), nil)
+	mockSubscription.On("RevokeTokens", uint(99)).Return(int64(0), fmt.Errorf("record not found"))
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{"/api/admin/subscriptions/1/tokens", http.StatusOK},
+		{"/api/admin/subscriptions/99/tokens", http.StatusNotFound},
+		{"/api/admin/subscriptions/abc/tokens", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("DELETE", tt.path, nil)
+		req.Header.Set("Authorization", "Bearer admin-key")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.path)
+	}
+
+	mockSubscription.AssertExpectations(t)
+}
+
+// Test for the admin export of consent events as JSON and CSV
+func TestAdminExportConsentEvents(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	createdAt := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	events := []models.ConsentEvent{
+		{ID: 1, Email: "test@example.com", SubscriptionID: 7, City: "London", Event: models.ConsentEventSubscribe, IP: "192.0.2.1", UserAgent: "Mozilla/5.0", CreatedAt: createdAt},
+		{ID: 2, Email: "test@example.com", SubscriptionID: 7, City: "=HYPERLINK()", Event: models.ConsentEventConfirm, IP: "192.0.2.2", UserAgent: "Mozilla/5.0, Gecko", CreatedAt: createdAt},
+	}
+	mockSubscription.On("ListConsentEvents", "test@example.com").Return(events, nil)
+
+	send := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer admin-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("/api/admin/consent-events?email=test@example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var exported []models.ConsentEvent
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &exported))
+	assert.Equal(t, events, exported)
+
+	w = send("/api/admin/consent-events?email=test@example.com&format=csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "id,email,subscription_id,city,event,ip,user_agent,created_at\n"+
+		"1,test@example.com,7,London,subscribe,192.0.2.1,Mozilla/5.0,2024-01-15T10:30:00Z\n"+
+		"2,test@example.com,7,'=HYPERLINK(),confirm,192.0.2.2,\"Mozilla/5.0, Gecko\",2024-01-15T10:30:00Z\n", w.Body.String())
+
+	w = send("/api/admin/consent-events?format=xml")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockSubscription.AssertExpectations(t)
+}
+
+// Test for POST /manage/request endpoint
+func TestRequestManageLink(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	mockSubscription.On("RequestManageLink", "test@example.com").Return(nil)
+	mockSubscription.On("RequestManageLink", "down@example.com").Return(fmt.Errorf("failed to send manage link email: connection refused"))
+
+	tests := []struct {
+		email      string
+		wantStatus int
+	}{
+		{"test%40example.com", http.StatusOK},
+		{"down%40example.com", http.StatusServiceUnavailable},
+		{"not-an-email", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/manage/request", strings.NewReader("email="+tt.email))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.email)
+	}
+
+	mockSubscription.AssertExpectations(t)
+}
+
+// Test for the subscription management endpoints
+func TestManagedSubscriptions(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	subscription := models.Subscription{ID: 1, Email: "test@example.com", City: "London", Frequency: "daily", Confirmed: true}
+	updated := subscription
+	updated.Frequency = "hourly"
+	frequency := "hourly"
+	city := "Paris"
+
+	mockSubscription.On("ListManagedSubscriptions", "manage-token").Return([]models.Subscription{subscription}, nil)
+	mockSubscription.On("ListManagedSubscriptions", "expired-token").Return(nil, fmt.Errorf("record not found"))
+	mockSubscription.On("UpdateManagedSubscription", "manage-token", uint(1), &models.SubscriptionUpdate{Frequency: &frequency}).Return(&updated, nil)
+	mockSubscription.On("UpdateManagedSubscription", "manage-token", uint(1), &models.SubscriptionUpdate{City: &city}).Return(nil, fmt.Errorf("email already subscribed"))
+	mockSubscription.On("DeleteManagedSubscription", "manage-token", uint(1)).Return(nil)
+	mockSubscription.On("DeleteManagedSubscription", "manage-token", uint(2)).Return(fmt.Errorf("record not found"))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("GET", "/api/manage/manage-token/subscriptions", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var subscriptions []models.Subscription
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &subscriptions))
+	assert.Len(t, subscriptions, 1)
+	assert.Equal(t, "London", subscriptions[0].City)
+
+	w = send("GET", "/api/manage/expired-token/subscriptions", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send("PATCH", "/api/manage/manage-token/subscriptions/1", `{"frequency":"hourly"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.Subscription
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "hourly", response.Frequency)
+
+	w = send("PATCH", "/api/manage/manage-token/subscriptions/1", `{"city":"Paris"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = send("PATCH", "/api/manage/manage-token/subscriptions/1", `{"frequency":"weekly"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send("PATCH", "/api/manage/manage-token/subscriptions/abc", `{"frequency":"hourly"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = send("DELETE", "/api/manage/manage-token/subscriptions/1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = send("DELETE", "/api/manage/manage-token/subscriptions/2", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	mockSubscription.AssertExpectations(t)
+}
+
+// Test for POST /privacy/request endpoint
+func TestRequestPrivacyLink(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	mockSubscription.On("RequestPrivacyLink", "test@example.com", models.PrivacyActionExport).Return(nil)
+	mockSubscription.On("RequestPrivacyLink", "test@example.com", models.PrivacyActionErase).Return(nil)
+	mockSubscription.On("RequestPrivacyLink", "down@example.com", models.PrivacyActionErase).Return(fmt.Errorf("failed to send privacy link email: connection refused"))
+
+	tests := []struct {
+		body       string
+		wantStatus int
+	}{
+		{"email=test%40example.com&action=export", http.StatusOK},
+		{"email=test%40example.com&action=erase", http.StatusOK},
+		{"email=down%40example.com&action=erase", http.StatusServiceUnavailable},
+		{"email=test%40example.com&action=delete", http.StatusBadRequest},
+		{"email=not-an-email&action=export", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/privacy/request", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.body)
+	}
+
+	mockSubscription.AssertExpectations(t)
+}
+
+// Test for the data export and erasure endpoints
+func TestPersonalDataExportAndErasure(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	deletedAt := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	data := &models.PersonalData{
+		Email: "test@example.com",
+		Subscriptions: []models.PersonalSubscription{
+			{Subscription: models.Subscription{ID: 1, Email: "test@example.com", City: "London"}},
+			{Subscription: models.Subscription{ID: 2, Email: "test@example.com", City: "Paris"}, DeletedAt: &deletedAt},
+		},
+		ConsentEvents: []models.ConsentEvent{{ID: 1, Email: "test@example.com", Event: models.ConsentEventConfirm, IP: "192.0.2.1"}},
+	}
+	mockSubscription.On("ExportPersonalData", "export-token").Return(data, nil)
+	mockSubscription.On("ExportPersonalData", "expired-token").Return(nil, fmt.Errorf("record not found"))
+	mockSubscription.On("ExportPersonalData", "erase-token").Return(nil, fmt.Errorf("invalid token type"))
+	mockSubscription.On("ErasePersonalData", "erase-token").Return(&models.ErasureResult{Subscriptions: 2, ConsentEvents: 1}, nil)
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("GET", "/api/privacy/export/export-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	var exported map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &exported))
+	assert.Equal(t, "test@example.com", exported["email"])
+	if subscriptions, ok := exported["subscriptions"].([]interface{}); assert.True(t, ok) && assert.Len(t, subscriptions, 2) {
+		assert.Nil(t, subscriptions[0].(map[string]interface{})["deleted_at"])
+		assert.Equal(t, "2024-01-10T12:00:00Z", subscriptions[1].(map[string]interface{})["deleted_at"])
+	}
+
+	w = send("GET", "/api/privacy/export/expired-token")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send("GET", "/api/privacy/export/erase-token")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Opening the emailed erasure link does not erase anything
+	w = send("GET", "/api/privacy/erase/erase-token")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = send("POST", "/api/privacy/erase/erase-token")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var result models.ErasureResult
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, int64(2), result.Subscriptions)
+
+	mockSubscription.AssertExpectations(t)
+}
+
+// Test for the pause and resume endpoints
+func TestPauseAndResume(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	until := time.Date(2030, 8, 1, 0, 0, 0, 0, time.UTC)
+	paused := &models.Subscription{ID: 1, City: "London", Paused: true}
+	pausedUntil := &models.Subscription{ID: 1, City: "London", Paused: true, PausedUntil: &until}
+	mockSubscription.On("PauseSubscription", "valid-unsubscribe-token", (*time.Time)(nil)).Return(paused, nil)
+	mockSubscription.On("PauseSubscription", "valid-unsubscribe-token", &until).Return(pausedUntil, nil)
+	mockSubscription.On("PauseSubscription", "unknown-token", (*time.Time)(nil)).Return(nil, fmt.Errorf("record not found"))
+	mockSubscription.On("ResumeSubscription", "valid-unsubscribe-token").Return(&models.Subscription{ID: 1, City: "London"}, nil)
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		wantMessage string
+	}{
+		{"/api/pause/valid-unsubscribe-token", http.StatusOK, "Updates for London paused"},
+		{"/api/pause/valid-unsubscribe-token?until=2030-08-01", http.StatusOK, "Updates for London paused until 2030-08-01"},
+		{"/api/pause/valid-unsubscribe-token?until=01.08.2030", http.StatusBadRequest, ""},
+		{"/api/pause/unknown-token", http.StatusNotFound, ""},
+		{"/api/resume/valid-unsubscribe-token", http.StatusOK, "Updates for London resumed"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.path)
+		if tt.wantMessage != "" {
+			var response map[string]string
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.wantMessage, response["message"])
+		}
+	}
+
+	// The end of a pause can also be sent in the form body
+	req := httptest.NewRequest("POST", "/api/pause/valid-unsubscribe-token", strings.NewReader("until=2030-08-01"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "paused until 2030-08-01")
+
+	mockSubscription.AssertExpectations(t)
+}
+
+// TestPauseAndResume_GetAsksForConfirmation tests that opening a pause or resume link only
+// leads to the confirmation page
+func TestPauseAndResume_GetAsksForConfirmation(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	tests := []struct {
+		path         string
+		wantLocation string
+	}{
+		{"/api/pause/valid-unsubscribe-token", "/pause?action=pause&token=valid-unsubscribe-token"},
+		{"/api/pause/valid-unsubscribe-token?until=2030-08-01", "/pause?action=pause&token=valid-unsubscribe-token&until=2030-08-01"},
+		{"/api/resume/valid-unsubscribe-token", "/pause?action=resume&token=valid-unsubscribe-token"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusSeeOther, w.Code, tt.path)
+		assert.Equal(t, tt.wantLocation, w.Header().Get("Location"))
+	}
+
+	mockSubscription.AssertNotCalled(t, "PauseSubscription", mock.Anything, mock.Anything)
+	mockSubscription.AssertNotCalled(t, "ResumeSubscription", mock.Anything)
+}
+
+// Test for POST /subscribe/resend endpoint
+func TestResendConfirmation(t *testing.T) {
+	router, _, mockSubscription := setupTestServer()
+
+	mockSubscription.On("ResendConfirmation", "pending@example.com", "London", "").Return(nil)
+	mockSubscription.On("ResendConfirmation", "unknown@example.com", "London", "").Return(fmt.Errorf("record not found"))
+	mockSubscription.On("ResendConfirmation", "confirmed@example.com", "London", "").Return(fmt.Errorf("email already subscribed"))
+	mockSubscription.On("ResendConfirmation", "suppressed@example.com", "London", "").Return(fmt.Errorf("email address is suppressed"))
+	mockSubscription.On("ResendConfirmation", "recent@example.com", "London", "").Return(fmt.Errorf("confirmation resent too recently"))
+	mockSubscription.On("ResendConfirmation", "pending@example.com", "London", "alerts").Return(nil)
+
+	// Unknown, confirmed and suppressed addresses cannot be told apart from a sent link
+	tests := []struct {
+		email      string
+		wantStatus int
+	}{
+		{"pending%40example.com", http.StatusOK},
+		{"unknown%40example.com", http.StatusOK},
+		{"confirmed%40example.com", http.StatusOK},
+		{"suppressed%40example.com", http.StatusOK},
+		{"recent%40example.com", http.StatusTooManyRequests},
+		{"not-an-email", http.StatusBadRequest},
+	}
+
+	var sentBody string
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/subscribe/resend", strings.NewReader("email="+tt.email+"&city=London"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, tt.wantStatus, w.Code, tt.email)
+		if tt.wantStatus == http.StatusTooManyRequests {
+			assert.Equal(t, "300", w.Header().Get("Retry-After"))
+		}
+		if tt.wantStatus == http.StatusOK {
+			if sentBody == "" {
+				sentBody = w.Body.String()
+			}
+			assert.Equal(t, sentBody, w.Body.String(), tt.email)
+		}
+	}
+
+	// The alerts subscription of a city is resent by giving its frequency
+	req := httptest.NewRequest("POST", "/api/subscribe/resend", strings.NewReader("email=pending%40example.com&city=London&frequency=alerts"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	mockSubscription.AssertExpectations(t)
+}
\ No newline at end of file
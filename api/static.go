@@ -9,6 +9,15 @@ func (s *Server) ServeStaticFiles() {
 	s.router.GET("/", func(c *gin.Context) {
 		c.File("public/index.html")
 	})
+	s.router.GET("/manage", func(c *gin.Context) {
+		c.File("public/manage.html")
+	})
+	s.router.GET("/privacy", func(c *gin.Context) {
+		c.File("public/privacy.html")
+	})
+	s.router.GET("/pause", func(c *gin.Context) {
+		c.File("public/pause.html")
+	})
 	
 	s.router.StaticFS("/static", http.Dir("public"))
 }
\ No newline at end of file
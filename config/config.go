@@ -2,21 +2,34 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Server     ServerConfig
-	Database   DatabaseConfig
-	Weather    WeatherConfig
-	Email      EmailConfig
-	Scheduler  SchedulerConfig
-	AppBaseURL string
+	Server      ServerConfig
+	Database    DatabaseConfig
+	Weather     WeatherConfig
+	Email       EmailConfig
+	Scheduler   SchedulerConfig
+	AppBaseURL  string
+	AdminAPIKey string // enables the /api/admin endpoints when set
+
+	ResendCooldown int // minutes before another confirmation, change, management or privacy link email can be requested
+
+	// TokenSigningKeys are comma separated kid:secret pairs signing the tokens of email links;
+	// the first one signs new tokens
+	TokenSigningKeys string
 }
 
 type ServerConfig struct {
 	Port int
+
+	// TrustedProxies are the IP addresses and CIDR ranges whose X-Forwarded-For header is
+	// believed when resolving the client IP; none by default
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
@@ -26,6 +39,10 @@ type DatabaseConfig struct {
 	Password string
 	Name     string
 	SSLMode  string
+
+	// MigrateOnStart applies pending migrations at startup; without it the application
+	// refuses to start until they are applied with `migrate up`
+	MigrateOnStart bool
 }
 
 func (c DatabaseConfig) GetDSN() string {
@@ -34,34 +51,114 @@ func (c DatabaseConfig) GetDSN() string {
 }
 
 type WeatherConfig struct {
-	APIKey  string
-	BaseURL string
+	APIKey         string
+	BaseURL        string
+	SearchCacheTTL int // minutes, 0 disables caching
 }
 
+// Email transports selectable with EMAIL_TRANSPORT
+const (
+	EmailTransportSMTP     = "smtp"
+	EmailTransportSendGrid = "sendgrid"
+	EmailTransportMailgun  = "mailgun"
+	EmailTransportSES      = "ses"
+	EmailTransportFile     = "file"
+)
+
+// SMTP TLS modes selectable with EMAIL_SMTP_TLS
+const (
+	SMTPTLSImplicit      = "implicit"      // SMTPS, TLS from the first byte (usually port 465)
+	SMTPTLSStartTLS      = "starttls"      // STARTTLS is required
+	SMTPTLSOpportunistic = "opportunistic" // STARTTLS when the server offers it
+	SMTPTLSNone          = "none"
+)
+
+// SMTP authentication mechanisms selectable with EMAIL_SMTP_AUTH
+const (
+	SMTPAuthPlain   = "plain"
+	SMTPAuthLogin   = "login"
+	SMTPAuthCRAMMD5 = "cram-md5"
+	SMTPAuthXOAUTH2 = "xoauth2" // EMAIL_SMTP_PASSWORD holds the OAuth 2.0 access token
+	SMTPAuthNone    = "none"
+)
+
 type EmailConfig struct {
+	Transport    string
 	SMTPHost     string
 	SMTPPort     int
 	SMTPUsername string
 	SMTPPassword string
 	FromName     string
 	FromAddress  string
+	TemplateDir  string
+
+	SMTPTLSMode        string
+	SMTPAuth           string
+	SMTPHelo           string // EHLO name, the host name when empty
+	SMTPDialTimeout    int // seconds
+	SMTPCommandTimeout int // seconds
+	SMTPIdleTimeout    int // seconds a connection is kept open between messages, 0 disables reuse
+
+	// HTTP API transports (SendGrid, Mailgun, SES)
+	APIKey        string
+	APIBaseURL    string // overrides the provider's default endpoint
+	MailgunDomain string
+	SESRegion     string
+	SESAccessKey  string
+	SESSecretKey  string
+
+	// File transport
+	MaildirPath string
+
+	// DKIM signing, enabled when a selector and private key are set
+	DKIMDomain         string // defaults to the domain of FromAddress
+	DKIMSelector       string
+	DKIMPrivateKey     string // PEM encoded
+	DKIMPrivateKeyFile string
+
+	// Bounce processing
+	BounceWebhookSecret string // shared secret required by the bounce webhook, which is disabled when empty
+	BounceMaildir       string // Maildir receiving delivery status notifications, polling is disabled when empty
 }
 
 type SchedulerConfig struct {
 	HourlyInterval int
 	DailyInterval  int
+	AlertInterval  int
+	RuleInterval   int
+	BounceInterval int
+
+	UnconfirmedRetention int // hours before unconfirmed subscriptions are purged, 0 disables purging
+	DeletedRetention     int // days before deleted subscriptions are purged, 0 disables purging
 }
 
 func LoadConfig() (*Config, error) {
 	dbPort, _ := strconv.Atoi(getEnvOrDefault("DB_PORT", "5432"))
+	migrateOnStart, _ := strconv.ParseBool(getEnvOrDefault("DB_MIGRATE_ON_START", "true"))
 	serverPort, _ := strconv.Atoi(getEnvOrDefault("SERVER_PORT", "8080"))
 	hourlyInterval, _ := strconv.Atoi(getEnvOrDefault("HOURLY_INTERVAL", "60"))
 	dailyInterval, _ := strconv.Atoi(getEnvOrDefault("DAILY_INTERVAL", "1440"))
+	alertInterval, _ := strconv.Atoi(getEnvOrDefault("ALERT_INTERVAL", "15"))
+	ruleInterval, _ := strconv.Atoi(getEnvOrDefault("RULE_INTERVAL", "60"))
+	bounceInterval, _ := strconv.Atoi(getEnvOrDefault("BOUNCE_INTERVAL", "15"))
+	unconfirmedRetention, _ := strconv.Atoi(getEnvOrDefault("UNCONFIRMED_RETENTION", "72"))
+	deletedRetention, _ := strconv.Atoi(getEnvOrDefault("DELETED_RETENTION", "30"))
+	resendCooldown, _ := strconv.Atoi(getEnvOrDefault("RESEND_COOLDOWN", "5"))
 	smtpPort, _ := strconv.Atoi(getEnvOrDefault("EMAIL_SMTP_PORT", "587"))
+	smtpDialTimeout, _ := strconv.Atoi(getEnvOrDefault("EMAIL_SMTP_DIAL_TIMEOUT", "10"))
+	smtpCommandTimeout, _ := strconv.Atoi(getEnvOrDefault("EMAIL_SMTP_TIMEOUT", "30"))
+	smtpIdleTimeout, _ := strconv.Atoi(getEnvOrDefault("EMAIL_SMTP_IDLE_TIMEOUT", "60"))
+
+	smtpTLSMode := SMTPTLSOpportunistic
+	if smtpPort == 465 {
+		smtpTLSMode = SMTPTLSImplicit
+	}
+	searchCacheTTL, _ := strconv.Atoi(getEnvOrDefault("CITY_SEARCH_CACHE_TTL", "60"))
 
 	config := &Config{
 		Server: ServerConfig{
-			Port: serverPort,
+			Port:           serverPort,
+			TrustedProxies: splitList(getEnvOrDefault("TRUSTED_PROXIES", "")),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnvOrDefault("DB_HOST", "localhost"),
@@ -70,41 +167,141 @@ func LoadConfig() (*Config, error) {
 			Password: getEnvOrDefault("DB_PASSWORD", "postgres"),
 			Name:     getEnvOrDefault("DB_NAME", "weatherapi"),
 			SSLMode:  getEnvOrDefault("DB_SSL_MODE", "disable"),
+
+			MigrateOnStart: migrateOnStart,
 		},
 		Weather: WeatherConfig{
-			APIKey:  getEnvOrDefault("WEATHER_API_KEY", ""),
-			BaseURL: getEnvOrDefault("WEATHER_API_BASE_URL", "https://api.weatherapi.com/v1"),
+			APIKey:         getEnvOrDefault("WEATHER_API_KEY", ""),
+			BaseURL:        getEnvOrDefault("WEATHER_API_BASE_URL", "https://api.weatherapi.com/v1"),
+			SearchCacheTTL: searchCacheTTL,
 		},
 		Email: EmailConfig{
+			Transport:    getEnvOrDefault("EMAIL_TRANSPORT", EmailTransportSMTP),
 			SMTPHost:     getEnvOrDefault("EMAIL_SMTP_HOST", "smtp.gmail.com"),
 			SMTPPort:     smtpPort,
 			SMTPUsername: getEnvOrDefault("EMAIL_SMTP_USERNAME", ""),
 			SMTPPassword: getEnvOrDefault("EMAIL_SMTP_PASSWORD", ""),
 			FromName:     getEnvOrDefault("EMAIL_FROM_NAME", "Weather API"),
 			FromAddress:  getEnvOrDefault("EMAIL_FROM_ADDRESS", "no-reply@weatherapi.app"),
+			TemplateDir:  getEnvOrDefault("EMAIL_TEMPLATE_DIR", ""),
+
+			SMTPTLSMode:        getEnvOrDefault("EMAIL_SMTP_TLS", smtpTLSMode),
+			SMTPAuth:           getEnvOrDefault("EMAIL_SMTP_AUTH", SMTPAuthPlain),
+			SMTPHelo:           getEnvOrDefault("EMAIL_SMTP_HELO", ""),
+			SMTPDialTimeout:    smtpDialTimeout,
+			SMTPCommandTimeout: smtpCommandTimeout,
+			SMTPIdleTimeout:    smtpIdleTimeout,
+
+			APIKey:        getEnvOrDefault("EMAIL_API_KEY", ""),
+			APIBaseURL:    getEnvOrDefault("EMAIL_API_BASE_URL", ""),
+			MailgunDomain: getEnvOrDefault("EMAIL_MAILGUN_DOMAIN", ""),
+			SESRegion:     getEnvOrDefault("EMAIL_SES_REGION", ""),
+			SESAccessKey:  getEnvOrDefault("AWS_ACCESS_KEY_ID", ""),
+			SESSecretKey:  getEnvOrDefault("AWS_SECRET_ACCESS_KEY", ""),
+
+			MaildirPath: getEnvOrDefault("EMAIL_MAILDIR", "maildir"),
+
+			DKIMDomain:         getEnvOrDefault("EMAIL_DKIM_DOMAIN", ""),
+			DKIMSelector:       getEnvOrDefault("EMAIL_DKIM_SELECTOR", ""),
+			DKIMPrivateKey:     getEnvOrDefault("EMAIL_DKIM_PRIVATE_KEY", ""),
+			DKIMPrivateKeyFile: getEnvOrDefault("EMAIL_DKIM_PRIVATE_KEY_FILE", ""),
+
+			BounceWebhookSecret: getEnvOrDefault("BOUNCE_WEBHOOK_SECRET", ""),
+			BounceMaildir:       getEnvOrDefault("BOUNCE_MAILDIR", ""),
 		},
 		Scheduler: SchedulerConfig{
 			HourlyInterval: hourlyInterval,
 			DailyInterval:  dailyInterval,
+			AlertInterval:  alertInterval,
+			RuleInterval:   ruleInterval,
+			BounceInterval: bounceInterval,
+
+			UnconfirmedRetention: unconfirmedRetention,
+			DeletedRetention:     deletedRetention,
 		},
-		AppBaseURL: getEnvOrDefault("APP_URL", "http://localhost:8080"),
+		AppBaseURL:  getEnvOrDefault("APP_URL", "http://localhost:8080"),
+		AdminAPIKey: getEnvOrDefault("ADMIN_API_KEY", ""),
+
+		ResendCooldown: resendCooldown,
+
+		TokenSigningKeys: getEnvOrDefault("TOKEN_SIGNING_KEYS", ""),
 	}
 
 	if config.Weather.APIKey == "" {
 		return nil, fmt.Errorf("WEATHER_API_KEY environment variable is required")
 	}
 
-	if config.Email.SMTPUsername == "" || config.Email.SMTPPassword == "" {
-		return nil, fmt.Errorf("EMAIL_SMTP_USERNAME and EMAIL_SMTP_PASSWORD environment variables are required")
+	if err := config.Email.validate(); err != nil {
+		return nil, err
+	}
+
+	for _, proxy := range config.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q", proxy)
+			}
+		}
 	}
 
 	return config, nil
 }
 
+// validate checks that the settings required by the selected transport are present
+func (c EmailConfig) validate() error {
+	switch c.Transport {
+	case EmailTransportSMTP:
+		switch c.SMTPTLSMode {
+		case SMTPTLSImplicit, SMTPTLSStartTLS, SMTPTLSOpportunistic, SMTPTLSNone:
+		default:
+			return fmt.Errorf("unsupported EMAIL_SMTP_TLS %q", c.SMTPTLSMode)
+		}
+		switch c.SMTPAuth {
+		case SMTPAuthNone:
+			return nil
+		case SMTPAuthPlain, SMTPAuthLogin, SMTPAuthCRAMMD5, SMTPAuthXOAUTH2:
+		default:
+			return fmt.Errorf("unsupported EMAIL_SMTP_AUTH %q", c.SMTPAuth)
+		}
+		if c.SMTPUsername == "" || c.SMTPPassword == "" {
+			return fmt.Errorf("EMAIL_SMTP_USERNAME and EMAIL_SMTP_PASSWORD environment variables are required")
+		}
+	case EmailTransportSendGrid:
+		if c.APIKey == "" {
+			return fmt.Errorf("EMAIL_API_KEY environment variable is required for the sendgrid transport")
+		}
+	case EmailTransportMailgun:
+		if c.APIKey == "" || c.MailgunDomain == "" {
+			return fmt.Errorf("EMAIL_API_KEY and EMAIL_MAILGUN_DOMAIN environment variables are required for the mailgun transport")
+		}
+	case EmailTransportSES:
+		if c.SESRegion == "" || c.SESAccessKey == "" || c.SESSecretKey == "" {
+			return fmt.Errorf("EMAIL_SES_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables are required for the ses transport")
+		}
+	case EmailTransportFile:
+		if c.MaildirPath == "" {
+			return fmt.Errorf("EMAIL_MAILDIR environment variable is required for the file transport")
+		}
+	default:
+		return fmt.Errorf("unsupported EMAIL_TRANSPORT %q", c.Transport)
+	}
+	return nil
+}
+
 func getEnvOrDefault(key, defaultValue string) string {
 	value, exists := os.LookupEnv(key)
 	if !exists {
 		return defaultValue
 	}
 	return value
+}
+
+// splitList returns the non-empty entries of a comma separated list, nil when there are none
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
 }
\ No newline at end of file
@@ -6,7 +6,6 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"weatherapi.app/config"
-	"weatherapi.app/models"
 )
 
 func InitDB(config config.DatabaseConfig) (*gorm.DB, error) {
@@ -18,11 +17,30 @@ func InitDB(config config.DatabaseConfig) (*gorm.DB, error) {
 	return db, nil
 }
 
+// RunMigrations applies the pending migrations built into the binary
 func RunMigrations(db *gorm.DB) error {
-	return db.AutoMigrate(
-		&models.Subscription{},
-		&models.Token{},
-	)
+	migrator, err := NewMigrator(db, Migrations())
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[DEBUG] Applied %d migrations\n", applied)
+	return nil
+}
+
+// CheckMigrations returns an error when a migration built into the binary has not been
+// applied, for deployments that run migrations as a separate step
+func CheckMigrations(db *gorm.DB) error {
+	migrator, err := NewMigrator(db, Migrations())
+	if err != nil {
+		return err
+	}
+	return migrator.CheckCurrent()
 }
 
 func CloseDB(db *gorm.DB) error {
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"weatherapi.app/models"
+)
+
+func openTestDB(t *testing.T, name string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	return db
+}
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_create_cities.up.sql":   {Data: []byte("CREATE TABLE cities (id integer PRIMARY KEY, name text NOT NULL);")},
+		"0001_create_cities.down.sql": {Data: []byte("DROP TABLE cities;")},
+		"0002_add_country.up.sql": {Data: []byte(`ALTER TABLE cities ADD COLUMN country text;
+CREATE INDEX idx_cities_country ON cities (country);`)},
+		"0002_add_country.down.sql": {Data: []byte(`DROP INDEX idx_cities_country;
+ALTER TABLE cities DROP COLUMN country;`)},
+	}
+}
+
+// TestMigrator_UpDownStatus tests applying, listing and reverting migrations
+func TestMigrator_UpDownStatus(t *testing.T) {
+	db := openTestDB(t, "migrate_up_down")
+	migrator, err := NewMigrator(db, testMigrations())
+	assert.NoError(t, err)
+
+	// A new database has every migration pending
+	assert.EqualError(t, migrator.CheckCurrent(), "database schema is not up to date, pending migrations: 1_create_cities, 2_add_country")
+	statuses, err := migrator.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 2) {
+		assert.Equal(t, "create_cities", statuses[0].Name)
+		assert.Nil(t, statuses[0].AppliedAt)
+	}
+
+	applied, err := migrator.Up()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, applied)
+	assert.True(t, db.Migrator().HasColumn("cities", "country"))
+	assert.NoError(t, migrator.CheckCurrent())
+
+	// Applying again is a no-op
+	applied, err = migrator.Up()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, applied)
+
+	statuses, err = migrator.Status()
+	assert.NoError(t, err)
+	for _, status := range statuses {
+		assert.NotNil(t, status.AppliedAt, status.Name)
+	}
+
+	reverted, err := migrator.Down(1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.False(t, db.Migrator().HasColumn("cities", "country"))
+	assert.True(t, db.Migrator().HasTable("cities"))
+	assert.EqualError(t, migrator.CheckCurrent(), "database schema is not up to date, pending migrations: 2_add_country")
+
+	// The baseline is only reverted when forced
+	reverted, err = migrator.Down(5, false)
+	assert.EqualError(t, err, "failed to revert migration 1_create_cities: the baseline drops every table and is only reverted with --force")
+	assert.Equal(t, 0, reverted)
+	assert.True(t, db.Migrator().HasTable("cities"))
+
+	// Reverting more migrations than were applied stops at the first one
+	reverted, err = migrator.Down(5, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+	assert.False(t, db.Migrator().HasTable("cities"))
+}
+
+// TestMigrator_FailedMigration tests that a failing migration is rolled back and not recorded
+func TestMigrator_FailedMigration(t *testing.T) {
+	db := openTestDB(t, "migrate_failed")
+	migrations := testMigrations()
+	migrations["0003_broken.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE broken (id integer); INSERT INTO missing VALUES (1);")}
+	migrations["0003_broken.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE broken;")}
+
+	migrator, err := NewMigrator(db, migrations)
+	assert.NoError(t, err)
+
+	applied, err := migrator.Up()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to apply migration 3_broken")
+	assert.Equal(t, 2, applied)
+	assert.False(t, db.Migrator().HasTable("broken"))
+
+	statuses, err := migrator.Status()
+	assert.NoError(t, err)
+	if assert.Len(t, statuses, 3) {
+		assert.NotNil(t, statuses[1].AppliedAt)
+		assert.Nil(t, statuses[2].AppliedAt)
+	}
+}
+
+// TestLoadMigrations_Invalid tests that incomplete and badly named migrations are rejected
+func TestLoadMigrations_Invalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"missing down": {"0001_create.up.sql": {Data: []byte("SELECT 1;")}},
+		"bad name":     {"create.up.sql": {Data: []byte("SELECT 1;")}, "create.down.sql": {Data: []byte("SELECT 1;")}},
+		"name mismatch": {
+			"0001_create.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_remove.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadMigrations(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestMigrations_Embedded tests that the built-in migrations load and that the baseline
+// creates every column of the models
+func TestMigrations_Embedded(t *testing.T) {
+	migrations, err := LoadMigrations(Migrations())
+	assert.NoError(t, err)
+	if !assert.NotEmpty(t, migrations) {
+		return
+	}
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "baseline", migrations[0].Name)
+
+	var all strings.Builder
+	for _, migration := range migrations {
+		all.WriteString(migration.Up)
+	}
+	sql := all.String()
+
+	for _, model := range []interface{}{
+		&models.Subscription{},
+		&models.Token{},
+		&models.SentAlert{},
+		&models.NotificationRule{},
+		&models.Suppression{},
+		&models.ConsentEvent{},
+		&models.SentLink{},
+	} {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		assert.NoError(t, err)
+		assert.Contains(t, sql, "CREATE TABLE IF NOT EXISTS "+s.Table+" (")
+		for _, column := range s.DBNames {
+			assert.Contains(t, sql, "    "+column+" ", s.Table+"."+column)
+		}
+	}
+}
+
+// TestMigrations_Postgres runs the built-in migrations against PostgreSQL, adopting the tables
+// of the first version of the application. It needs TEST_POSTGRES_DSN to point at a disposable
+// database, since it drops the application tables.
+func TestMigrations_Postgres(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, table := range []string{"schema_migrations", "sent_links", "consent_events", "suppressions", "notification_rules", "sent_alerts", "tokens", "subscriptions"} {
+		assert.NoError(t, db.Exec("DROP TABLE IF EXISTS "+table+" CASCADE").Error)
+	}
+	assert.NoError(t, db.Exec(`CREATE TABLE subscriptions (id bigserial PRIMARY KEY, email text NOT NULL, city text NOT NULL,
+	frequency text NOT NULL, confirmed boolean DEFAULT false, created_at timestamptz, updated_at timestamptz, deleted_at timestamptz);
+CREATE TABLE tokens (id bigserial PRIMARY KEY, token text NOT NULL, subscription_id bigint NOT NULL, type text NOT NULL,
+	expires_at timestamptz, created_at timestamptz, deleted_at timestamptz,
+	CONSTRAINT fk_tokens_subscription FOREIGN KEY (subscription_id) REFERENCES subscriptions (id));
+CREATE UNIQUE INDEX idx_tokens_token ON tokens (token);
+INSERT INTO subscriptions (email, city, frequency, confirmed) VALUES ('legacy@example.com', 'London', 'daily', true);
+INSERT INTO tokens (token, subscription_id, type, expires_at) VALUES ('legacy-unsubscribe', 1, 'unsubscribe', now() + interval '1 day');`).Error)
+
+	migrator, err := NewMigrator(db, Migrations())
+	assert.NoError(t, err)
+	applied, err := migrator.Up()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, applied)
+	assert.NoError(t, migrator.CheckCurrent())
+
+	// Stored tokens are hashed and the columns added since have their defaults
+	assert.False(t, db.Migrator().HasColumn(&models.Token{}, "token"))
+	var token models.Token
+	assert.NoError(t, db.First(&token).Error)
+	assert.Equal(t, models.HashToken("legacy-unsubscribe"), token.TokenHash)
+	var subscription models.Subscription
+	assert.NoError(t, db.First(&subscription).Error)
+	assert.Equal(t, "metric", subscription.Units)
+	assert.Equal(t, 0, subscription.TokenVersion)
+
+	// Every model can be written to the migrated tables
+	assert.NoError(t, db.Create(&models.NotificationRule{SubscriptionID: subscription.ID, Metric: "min_temp", Operator: "lt"}).Error)
+	assert.NoError(t, db.Create(&models.SentAlert{SubscriptionID: subscription.ID, AlertID: "alert"}).Error)
+	assert.NoError(t, db.Create(&models.Suppression{Email: "bounced@example.com", Source: models.BounceTypeBounce}).Error)
+	assert.NoError(t, db.Create(&models.ConsentEvent{Email: "legacy@example.com", SubscriptionID: subscription.ID, Event: models.ConsentEventConfirm}).Error)
+	assert.NoError(t, db.Create(&models.SentLink{Email: "legacy@example.com", Purpose: "manage"}).Error)
+
+	// The baseline is idempotent, so running it on its own tables changes nothing
+	migrations, err := LoadMigrations(Migrations())
+	assert.NoError(t, err)
+	assert.NoError(t, db.Exec(migrations[0].Up).Error)
+
+	_, err = migrator.Down(1, false)
+	assert.Error(t, err)
+	assert.True(t, db.Migrator().HasTable(&models.Subscription{}))
+	reverted, err := migrator.Down(len(migrations), true)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), reverted)
+	assert.False(t, db.Migrator().HasTable(&models.Subscription{}))
+}
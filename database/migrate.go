@@ -0,0 +1,313 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
+// Migrations returns the SQL migrations built into the binary
+func Migrations() fs.FS {
+	migrations, err := fs.Sub(embeddedMigrations, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return migrations
+}
+
+// migrationLockID is the key of the PostgreSQL advisory lock held while a migration is
+// applied or rolled back, so replicas starting at the same time do not race
+const migrationLockID = 7265430912
+
+// baselineVersion is the migration that adopts databases created before migrations were
+// versioned; reverting it drops every table, so it is only reverted when forced
+const baselineVersion = 1
+
+// migrationFilePattern matches <version>_<name>.up.sql and <version>_<name>.down.sql
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change with the SQL that applies and reverts it
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, nil when it is pending
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// schemaMigration is a row of the table recording applied migrations
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// LoadMigrations reads the migrations in the root of fsys, ordered by version. Every
+// version needs both an up and a down file.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %q: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files named %q and %q", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations, recording the applied ones in schema_migrations
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies every pending migration in order, each in its own transaction, and returns the
+// number applied
+func (m *Migrator) Up() (int, error) {
+	applied := 0
+	for _, migration := range m.migrations {
+		migration := migration
+		ran := false
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := lockMigrations(tx); err != nil {
+				return err
+			}
+			if err := ensureTable(tx); err != nil {
+				return err
+			}
+			// Checked under the lock, in case another replica applied it meanwhile
+			var count int64
+			if err := tx.Model(&schemaMigration{}).Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+
+			fmt.Printf("[DEBUG] Applying migration %d_%s\n", migration.Version, migration.Name)
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			ran = true
+			return tx.Create(&schemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if ran {
+			applied++
+		}
+	}
+
+	return applied, nil
+}
+
+// Down reverts the latest steps applied migrations, newest first, and returns the number
+// reverted. The baseline is only reverted with force, since that drops every table.
+func (m *Migrator) Down(steps int, force bool) (int, error) {
+	byVersion := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	reverted := 0
+	for reverted < steps {
+		done := false
+		var latest schemaMigration
+		err := m.db.Transaction(func(tx *gorm.DB) error {
+			if err := lockMigrations(tx); err != nil {
+				return err
+			}
+			if err := ensureTable(tx); err != nil {
+				return err
+			}
+			result := tx.Order("version DESC").Limit(1).Find(&latest)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				done = true
+				return nil
+			}
+
+			migration, ok := byVersion[latest.Version]
+			if !ok {
+				return fmt.Errorf("migration is not known to this build")
+			}
+			if migration.Version == baselineVersion && !force {
+				return fmt.Errorf("the baseline drops every table and is only reverted with --force")
+			}
+			fmt.Printf("[DEBUG] Reverting migration %d_%s\n", migration.Version, migration.Name)
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&schemaMigration{}, latest.Version).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("failed to revert migration %d_%s: %w", latest.Version, latest.Name, err)
+		}
+		if done {
+			break
+		}
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// Status lists every known migration and when it was applied, followed by applied
+// migrations that are not known to this build
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	unknown := make([]MigrationStatus, 0, len(applied))
+	for _, row := range applied {
+		appliedAt := row.AppliedAt
+		unknown = append(unknown, MigrationStatus{Version: row.Version, Name: row.Name, AppliedAt: &appliedAt})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+
+	return append(statuses, unknown...), nil
+}
+
+// CheckCurrent returns an error when any known migration has not been applied
+func (m *Migrator) CheckCurrent() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, fmt.Sprintf("%d_%s", status.Version, status.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is not up to date, pending migrations: %s", strings.Join(pending, ", "))
+	}
+
+	return nil
+}
+
+// applied returns the applied migrations by version; none when the table does not exist yet
+func (m *Migrator) applied() (map[int64]schemaMigration, error) {
+	applied := make(map[int64]schemaMigration)
+	if !m.db.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+
+	var rows []schemaMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// ensureTable creates the schema_migrations table; it runs under the migration lock, since
+// concurrent CREATE TABLE IF NOT EXISTS statements can fail on PostgreSQL
+func ensureTable(tx *gorm.DB) error {
+	err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`).Error
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// lockMigrations takes the migration lock until the end of the transaction on PostgreSQL;
+// other databases are only used by tests and run a single process
+func lockMigrations(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID).Error
+}
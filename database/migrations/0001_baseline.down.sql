@@ -0,0 +1,7 @@
+-- Reverting the baseline drops every table and all data in them
+DROP TABLE IF EXISTS consent_events;
+DROP TABLE IF EXISTS suppressions;
+DROP TABLE IF EXISTS notification_rules;
+DROP TABLE IF EXISTS sent_alerts;
+DROP TABLE IF EXISTS tokens;
+DROP TABLE IF EXISTS subscriptions;
@@ -0,0 +1,122 @@
+-- Baseline: the schema created by AutoMigrate before migrations were versioned. Every
+-- statement is idempotent, so databases created by any earlier version are brought up to
+-- date and new databases are created from scratch.
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id bigserial PRIMARY KEY,
+    email text NOT NULL,
+    city text NOT NULL,
+    latitude decimal,
+    longitude decimal,
+    frequency text NOT NULL,
+    units text NOT NULL DEFAULT 'metric',
+    language text NOT NULL DEFAULT 'en',
+    air_quality boolean DEFAULT false,
+    confirmed boolean DEFAULT false,
+    paused boolean DEFAULT false,
+    paused_until timestamptz,
+    suppressed boolean DEFAULT false,
+    suppression_reason text,
+    token_version bigint NOT NULL DEFAULT 0,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz
+);
+
+-- Columns added to subscriptions after the table was first created
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS latitude decimal;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS longitude decimal;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS units text NOT NULL DEFAULT 'metric';
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS language text NOT NULL DEFAULT 'en';
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS air_quality boolean DEFAULT false;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS paused boolean DEFAULT false;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS paused_until timestamptz;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS suppressed boolean DEFAULT false;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS suppression_reason text;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS token_version bigint NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_email ON subscriptions (email);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_deleted_at ON subscriptions (deleted_at);
+
+CREATE TABLE IF NOT EXISTS tokens (
+    id bigserial PRIMARY KEY,
+    token_hash varchar(64) NOT NULL,
+    subscription_id bigint NOT NULL,
+    type text NOT NULL,
+    payload text,
+    expires_at timestamptz,
+    created_at timestamptz,
+    deleted_at timestamptz,
+    CONSTRAINT fk_tokens_subscription FOREIGN KEY (subscription_id) REFERENCES subscriptions (id)
+);
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS payload text;
+
+-- Tokens used to be stored in plaintext; replace them with their SHA-256 hashes, so existing
+-- links keep working
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'tokens' AND column_name = 'token'
+    ) THEN
+        ALTER TABLE tokens ADD COLUMN IF NOT EXISTS token_hash varchar(64);
+        UPDATE tokens SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+        ALTER TABLE tokens ALTER COLUMN token_hash SET NOT NULL;
+        ALTER TABLE tokens DROP COLUMN token;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tokens_token_hash ON tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_tokens_subscription_id ON tokens (subscription_id);
+CREATE INDEX IF NOT EXISTS idx_tokens_deleted_at ON tokens (deleted_at);
+
+CREATE TABLE IF NOT EXISTS sent_alerts (
+    id bigserial PRIMARY KEY,
+    subscription_id bigint NOT NULL,
+    alert_id text NOT NULL,
+    sent_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sent_alerts_subscription_alert ON sent_alerts (subscription_id, alert_id);
+CREATE INDEX IF NOT EXISTS idx_sent_alerts_sent_at ON sent_alerts (sent_at);
+
+CREATE TABLE IF NOT EXISTS notification_rules (
+    id bigserial PRIMARY KEY,
+    subscription_id bigint NOT NULL,
+    metric text NOT NULL,
+    operator text NOT NULL,
+    threshold decimal,
+    day bigint,
+    last_triggered_for text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    CONSTRAINT fk_subscriptions_rules FOREIGN KEY (subscription_id) REFERENCES subscriptions (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_rules_subscription_id ON notification_rules (subscription_id);
+
+CREATE TABLE IF NOT EXISTS suppressions (
+    id bigserial PRIMARY KEY,
+    email text NOT NULL,
+    reason text,
+    source text NOT NULL,
+    created_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_suppressions_email ON suppressions (email);
+
+CREATE TABLE IF NOT EXISTS consent_events (
+    id bigserial PRIMARY KEY,
+    email text NOT NULL,
+    subscription_id bigint NOT NULL,
+    city text,
+    event text NOT NULL,
+    ip text,
+    user_agent text,
+    created_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_consent_events_email ON consent_events (email);
+CREATE INDEX IF NOT EXISTS idx_consent_events_subscription_id ON consent_events (subscription_id);
+CREATE INDEX IF NOT EXISTS idx_consent_events_created_at ON consent_events (created_at);
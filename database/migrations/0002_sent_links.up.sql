@@ -0,0 +1,12 @@
+-- Remembers when management and privacy links were last emailed to an address, so they
+-- can only be requested once per cooldown.
+
+CREATE TABLE IF NOT EXISTS sent_links (
+    id bigserial PRIMARY KEY,
+    email text NOT NULL,
+    purpose text NOT NULL,
+    sent_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sent_links_email_purpose ON sent_links (email, purpose);
+CREATE INDEX IF NOT EXISTS idx_sent_links_sent_at ON sent_links (sent_at);
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"gorm.io/gorm"
 	"weatherapi.app/api"
 	"weatherapi.app/config"
 	"weatherapi.app/database"
 	"weatherapi.app/scheduler"
+	"weatherapi.app/service"
+	"weatherapi.app/tokens"
 )
 
 // printConfig prints all fields in the configuration
@@ -21,6 +29,7 @@ func printConfig(cfg *config.Config) {
 	// Print Server config
 	fmt.Printf("SERVER:\n")
 	fmt.Printf("  Port: %d\n", cfg.Server.Port)
+	fmt.Printf("  Trusted proxies: %s\n", strings.Join(cfg.Server.TrustedProxies, ", "))
 	
 	// Print Database config
 	fmt.Printf("\nDATABASE:\n")
@@ -30,28 +39,58 @@ func printConfig(cfg *config.Config) {
 	fmt.Printf("  Password: %s\n", maskString(cfg.Database.Password))
 	fmt.Printf("  Name: %s\n", cfg.Database.Name)
 	fmt.Printf("  SSLMode: %s\n", cfg.Database.SSLMode)
+	fmt.Printf("  Migrate On Start: %t\n", cfg.Database.MigrateOnStart)
 	
 	// Print Weather config
 	fmt.Printf("\nWEATHER API:\n")
 	fmt.Printf("  API Key: %s\n", maskString(cfg.Weather.APIKey))
 	fmt.Printf("  Base URL: %s\n", cfg.Weather.BaseURL)
+	fmt.Printf("  City Search Cache TTL: %d minutes\n", cfg.Weather.SearchCacheTTL)
 	
 	// Print Email config
 	fmt.Printf("\nEMAIL:\n")
+	fmt.Printf("  Transport: %s\n", cfg.Email.Transport)
 	fmt.Printf("  SMTP Host: %s\n", cfg.Email.SMTPHost)
 	fmt.Printf("  SMTP Port: %d\n", cfg.Email.SMTPPort)
 	fmt.Printf("  SMTP Username: %s\n", cfg.Email.SMTPUsername)
 	fmt.Printf("  SMTP Password: %s\n", maskString(cfg.Email.SMTPPassword))
+	fmt.Printf("  SMTP TLS: %s\n", cfg.Email.SMTPTLSMode)
+	fmt.Printf("  SMTP Auth: %s\n", cfg.Email.SMTPAuth)
+	fmt.Printf("  SMTP HELO: %s\n", cfg.Email.SMTPHelo)
+	fmt.Printf("  SMTP Timeouts: dial %ds, command %ds, idle %ds\n",
+		cfg.Email.SMTPDialTimeout, cfg.Email.SMTPCommandTimeout, cfg.Email.SMTPIdleTimeout)
 	fmt.Printf("  From Name: %s\n", cfg.Email.FromName)
 	fmt.Printf("  From Address: %s\n", cfg.Email.FromAddress)
+	fmt.Printf("  Template Dir: %s\n", cfg.Email.TemplateDir)
+	fmt.Printf("  API Key: %s\n", maskString(cfg.Email.APIKey))
+	fmt.Printf("  API Base URL: %s\n", cfg.Email.APIBaseURL)
+	fmt.Printf("  Mailgun Domain: %s\n", cfg.Email.MailgunDomain)
+	fmt.Printf("  SES Region: %s\n", cfg.Email.SESRegion)
+	fmt.Printf("  SES Access Key: %s\n", maskString(cfg.Email.SESAccessKey))
+	fmt.Printf("  SES Secret Key: %s\n", maskString(cfg.Email.SESSecretKey))
+	fmt.Printf("  Maildir: %s\n", cfg.Email.MaildirPath)
+	fmt.Printf("  DKIM Domain: %s\n", cfg.Email.DKIMDomain)
+	fmt.Printf("  DKIM Selector: %s\n", cfg.Email.DKIMSelector)
+	fmt.Printf("  DKIM Private Key: %s\n", maskString(cfg.Email.DKIMPrivateKey))
+	fmt.Printf("  DKIM Private Key File: %s\n", cfg.Email.DKIMPrivateKeyFile)
+	fmt.Printf("  Bounce Webhook Secret: %s\n", maskString(cfg.Email.BounceWebhookSecret))
+	fmt.Printf("  Bounce Maildir: %s\n", cfg.Email.BounceMaildir)
 	
 	// Print Scheduler config
 	fmt.Printf("\nSCHEDULER:\n")
 	fmt.Printf("  Hourly Interval: %d minutes\n", cfg.Scheduler.HourlyInterval)
 	fmt.Printf("  Daily Interval: %d minutes\n", cfg.Scheduler.DailyInterval)
+	fmt.Printf("  Alert Interval: %d minutes\n", cfg.Scheduler.AlertInterval)
+	fmt.Printf("  Rule Interval: %d minutes\n", cfg.Scheduler.RuleInterval)
+	fmt.Printf("  Bounce Interval: %d minutes\n", cfg.Scheduler.BounceInterval)
+	fmt.Printf("  Unconfirmed Retention: %d hours\n", cfg.Scheduler.UnconfirmedRetention)
+	fmt.Printf("  Deleted Retention: %d days\n", cfg.Scheduler.DeletedRetention)
 	
 	// Print App Base URL
 	fmt.Printf("\nAPP BASE URL: %s\n", cfg.AppBaseURL)
+	fmt.Printf("ADMIN API KEY: %s\n", maskString(cfg.AdminAPIKey))
+	fmt.Printf("RESEND COOLDOWN: %d minutes\n", cfg.ResendCooldown)
+	fmt.Printf("TOKEN SIGNING KEYS: %s\n", maskString(cfg.TokenSigningKeys))
 	
 	fmt.Println("===================================")
 }
@@ -108,12 +147,87 @@ func isSensitive(key string) bool {
 	return false
 }
 
+// runMigrate runs the migrate subcommand: up applies every pending migration, down [n]
+// reverts the latest n migrations (1 by default) and status lists them. down refuses to
+// revert the baseline, which drops every table, unless --force is given.
+func runMigrate(db *gorm.DB, args []string) error {
+	migrator, err := database.NewMigrator(db, database.Migrations())
+	if err != nil {
+		return err
+	}
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", applied)
+	case "down":
+		steps := 1
+		force := false
+		for _, arg := range args[1:] {
+			if arg == "--force" {
+				force = true
+				continue
+			}
+			steps, err = strconv.Atoi(arg)
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations to revert: %s", arg)
+			}
+		}
+		reverted, err := migrator.Down(steps, force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d migrations\n", reverted)
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", command)
+	}
+
+	return nil
+}
+
 func main() {
 	// Load environment variables from .env file if present
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found or error loading it")
 	}
 	
+	// `weatherapi migrate up|down [n]|status` manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		db, err := database.InitDB(cfg.Database)
+		if err != nil {
+			log.Fatalf("Failed to initialize database: %v", err)
+		}
+		defer database.CloseDB(db)
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+	
 	// Print all environment variables
 	printAllEnvVars()
 
@@ -126,15 +240,32 @@ func main() {
 	// Print the loaded configuration
 	printConfig(cfg)
 
+	// Make sure every email template renders and the DKIM key loads before sending anything
+	if err := service.ValidateEmailConfig(cfg); err != nil {
+		log.Fatalf("Invalid email configuration: %v", err)
+	}
+
+	// Links in emails must keep working across restarts and replicas, so there is no generated key
+	if cfg.TokenSigningKeys == "" {
+		log.Fatalf("TOKEN_SIGNING_KEYS environment variable is required")
+	}
+	if _, err := tokens.NewSigner(cfg.TokenSigningKeys); err != nil {
+		log.Fatalf("Invalid TOKEN_SIGNING_KEYS: %v", err)
+	}
+
 	// Initialize database
 	db, err := database.InitDB(cfg.Database)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
-	// Run database migrations
-	if err := database.RunMigrations(db); err != nil {
-		log.Fatalf("Failed to run database migrations: %v", err)
+	// Run database migrations, or make sure they have been run
+	if cfg.Database.MigrateOnStart {
+		if err := database.RunMigrations(db); err != nil {
+			log.Fatalf("Failed to run database migrations: %v", err)
+		}
+	} else if err := database.CheckMigrations(db); err != nil {
+		log.Fatalf("%v; run `weatherapi migrate up` first", err)
 	}
 
 	// Initialize and start scheduler for sending weather updates
@@ -143,7 +274,25 @@ func main() {
 
 	// Initialize and start the API server
 	server := api.NewServer(db, cfg)
-	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	go func() {
+		if err := server.Start(); err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Wait for SIGINT or SIGTERM, then let requests in progress finish and close the SMTP
+	// connections so the mail server sees a QUIT instead of a dropped connection
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	fmt.Println("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Printf("[ERROR] Failed to shut down server: %v\n", err)
+	}
+	if err := schedulerService.Close(); err != nil {
+		fmt.Printf("[ERROR] Failed to close scheduler email transport: %v\n", err)
 	}
 }
\ No newline at end of file
@@ -1,45 +1,541 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
 type Subscription struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Email     string         `json:"email" gorm:"index;not null"`
-	City      string         `json:"city" gorm:"not null"`
-	Frequency string         `json:"frequency" gorm:"not null"`
-	Confirmed bool           `json:"confirmed" gorm:"default:false"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID                uint               `json:"id" gorm:"primaryKey"`
+	Email             string             `json:"email" gorm:"index;not null"`
+	City              string             `json:"city" gorm:"not null"`
+	Latitude          *float64           `json:"latitude,omitempty"`
+	Longitude         *float64           `json:"longitude,omitempty"`
+	Frequency         string             `json:"frequency" gorm:"not null"`
+	Units             string             `json:"units" gorm:"not null;default:metric"`
+	Language          string             `json:"language" gorm:"not null;default:en"`
+	AirQuality        bool               `json:"air_quality" gorm:"default:false"`
+	Confirmed         bool               `json:"confirmed" gorm:"default:false"`
+	Paused            bool               `json:"paused" gorm:"default:false"`
+	PausedUntil       *time.Time         `json:"paused_until,omitempty"`          // resumed automatically once passed
+	Suppressed        bool               `json:"suppressed" gorm:"default:false"` // set after a hard bounce or complaint
+	SuppressionReason string             `json:"suppression_reason,omitempty"`
+	TokenVersion      int                `json:"-" gorm:"not null;default:0"` // signed link tokens of an older version are rejected
+	Rules             []NotificationRule `json:"rules,omitempty" gorm:"foreignKey:SubscriptionID"`
+	CreatedAt         time.Time          `json:"created_at"`
+	UpdatedAt         time.Time          `json:"updated_at"`
+	DeletedAt         gorm.DeletedAt     `json:"-" gorm:"index"`
 }
 
+const (
+	BounceTypeBounce    = "bounce"
+	BounceTypeComplaint = "complaint"
+)
+
+// BounceEvent is a permanent delivery failure or spam complaint reported for a recipient
+type BounceEvent struct {
+	Email  string
+	Type   string
+	Reason string
+}
+
+// Suppression sources; bounces and complaints use the bounce type as their source
+const (
+	SuppressionSourceAdmin = "admin"
+)
+
+// Suppression blocks an email address from subscribing and from receiving any email
+type Suppression struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Email     string    `json:"email" gorm:"uniqueIndex;not null"` // stored lower-cased
+	Reason    string    `json:"reason"`
+	Source    string    `json:"source" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SuppressionRequest adds an email address to the suppression list
+type SuppressionRequest struct {
+	Email  string `json:"email" form:"email" binding:"required,email"`
+	Reason string `json:"reason" form:"reason"`
+}
+
+// ConsentEvent records when and how an address gave or withdrew its consent to receive
+// emails. Events are only ever added, never changed.
+type ConsentEvent struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	Email          string    `json:"email" gorm:"index;not null"` // stored lower-cased
+	SubscriptionID uint      `json:"subscription_id" gorm:"index;not null"`
+	City           string    `json:"city"`
+	Event          string    `json:"event" gorm:"not null"`
+	IP             string    `json:"ip"`
+	UserAgent      string    `json:"user_agent"`
+	CreatedAt      time.Time `json:"created_at" gorm:"index"`
+}
+
+const (
+	ConsentEventSubscribe   = "subscribe"   // the subscription form was submitted
+	ConsentEventConfirm     = "confirm"     // the confirmation link was opened
+	ConsentEventUnsubscribe = "unsubscribe" // an unsubscribe link was opened or posted to
+)
+
+// ErrConsentAppendOnly is returned when a consent event would be changed or deleted
+var ErrConsentAppendOnly = fmt.Errorf("consent events are append-only")
+
+func (ConsentEvent) BeforeUpdate(*gorm.DB) error {
+	return ErrConsentAppendOnly
+}
+
+func (ConsentEvent) BeforeDelete(*gorm.DB) error {
+	return ErrConsentAppendOnly
+}
+
+// RequestMeta describes the HTTP request that caused a consent event
+type RequestMeta struct {
+	IP        string
+	UserAgent string
+}
+
+const (
+	RuleMetricMinTemp     = "min_temp"
+	RuleMetricMaxTemp     = "max_temp"
+	RuleMetricRainChance  = "rain_chance"
+	RuleMetricSnowChance  = "snow_chance"
+	RuleMetricMaxWind     = "max_wind"
+	RuleMetricTotalPrecip = "total_precip"
+	RuleMetricUV          = "uv"
+
+	RuleOperatorLessThan       = "lt"
+	RuleOperatorLessOrEqual    = "lte"
+	RuleOperatorGreaterThan    = "gt"
+	RuleOperatorGreaterOrEqual = "gte"
+)
+
+// NotificationRule notifies a subscriber when a forecast value crosses a threshold,
+// e.g. "tomorrow's low is below 0". Thresholds are in the subscription's units.
+type NotificationRule struct {
+	ID             uint         `json:"id" gorm:"primaryKey"`
+	SubscriptionID uint         `json:"subscription_id" gorm:"index;not null"`
+	Subscription   Subscription `json:"-" gorm:"foreignKey:SubscriptionID"`
+	Metric         string       `json:"metric" gorm:"not null"`
+	Operator       string       `json:"operator" gorm:"not null"`
+	Threshold      float64      `json:"threshold"`
+	Day            int          `json:"day"` // 0 for today, 1 for tomorrow, 2 for the day after
+	// LastTriggeredFor is the forecast date the rule last fired for, so it fires once per day
+	LastTriggeredFor string    `json:"last_triggered_for,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// TriggeredRule is a notification rule whose condition holds for a forecast day
+type TriggeredRule struct {
+	Rule  NotificationRule
+	Day   ForecastDay
+	Value float64
+}
+
+// LocationQuery returns the location the subscription receives weather for
+func (s Subscription) LocationQuery() LocationQuery {
+	if s.Latitude != nil && s.Longitude != nil {
+		return NewCoordinatesLocation(*s.Latitude, *s.Longitude)
+	}
+	return NewCityLocation(s.City)
+}
+
+// WeatherOptions returns the unit, language and air quality preferences of the subscription
+func (s Subscription) WeatherOptions() WeatherOptions {
+	options := NewWeatherOptions(s.Units, s.Language)
+	options.AirQuality = s.AirQuality
+	return options
+}
+
+const (
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+
+	DefaultLanguage = "en"
+)
+
+// supportedLanguages are the condition text languages offered by the weather provider
+var supportedLanguages = map[string]bool{
+	"en": true, "ar": true, "bn": true, "bg": true, "zh": true, "zh_tw": true, "cs": true,
+	"da": true, "nl": true, "fi": true, "fr": true, "de": true, "el": true, "hi": true,
+	"hu": true, "it": true, "ja": true, "jv": true, "ko": true, "zh_cmn": true, "mr": true,
+	"pl": true, "pt": true, "pa": true, "ro": true, "ru": true, "sr": true, "si": true,
+	"sk": true, "es": true, "sv": true, "ta": true, "te": true, "tr": true, "uk": true,
+	"ur": true, "vi": true, "zh_wuu": true, "zh_hsn": true, "zh_yue": true, "zu": true,
+}
+
+// IsSupportedLanguage reports whether the weather provider can localize condition text to lang
+func IsSupportedLanguage(lang string) bool {
+	return supportedLanguages[lang]
+}
+
+// WeatherOptions controls the unit system and language of weather data and
+// whether air quality data is included
+type WeatherOptions struct {
+	Units      string
+	Language   string
+	AirQuality bool
+}
+
+// NewWeatherOptions returns options with metric units and English used for empty values
+func NewWeatherOptions(units, language string) WeatherOptions {
+	if units == "" {
+		units = UnitsMetric
+	}
+	if language == "" {
+		language = DefaultLanguage
+	}
+	return WeatherOptions{Units: units, Language: language}
+}
+
+const (
+	LocationTypeCity        = "city"
+	LocationTypeCoordinates = "coordinates"
+	LocationTypePostalCode  = "postal_code"
+	LocationTypeIP          = "ip"
+)
+
+// LocationQuery identifies a location to get weather for
+type LocationQuery struct {
+	Type       string
+	City       string
+	Latitude   float64
+	Longitude  float64
+	PostalCode string
+	IP         string // public IP address of the client; the API resolves it, never the provider
+}
+
+func NewCityLocation(city string) LocationQuery {
+	return LocationQuery{Type: LocationTypeCity, City: city}
+}
+
+func NewCoordinatesLocation(lat, lon float64) LocationQuery {
+	return LocationQuery{Type: LocationTypeCoordinates, Latitude: lat, Longitude: lon}
+}
+
+func NewPostalCodeLocation(postalCode string) LocationQuery {
+	return LocationQuery{Type: LocationTypePostalCode, PostalCode: postalCode}
+}
+
+func NewIPLocation(ip string) LocationQuery {
+	return LocationQuery{Type: LocationTypeIP, IP: ip}
+}
+
+// Query formats the location as the weather provider's "q" parameter
+func (l LocationQuery) Query() string {
+	switch l.Type {
+	case LocationTypeCoordinates:
+		return fmt.Sprintf("%.4f,%.4f", l.Latitude, l.Longitude)
+	case LocationTypePostalCode:
+		return l.PostalCode
+	case LocationTypeIP:
+		return l.IP
+	default:
+		return l.City
+	}
+}
+
+// Validate rejects an IP location without an address, which the provider would otherwise
+// resolve to the IP address of the server asking it
+func (l LocationQuery) Validate() error {
+	if l.Type == LocationTypeIP && l.IP == "" {
+		return fmt.Errorf("ip address is required")
+	}
+	return nil
+}
+
+// Token is a random token stored as its SHA-256 hash, so the tokens of links in emails
+// cannot be read from the database
 type Token struct {
 	ID             uint           `json:"id" gorm:"primaryKey"`
-	Token          string         `json:"token" gorm:"uniqueIndex;not null"`
+	TokenHash      string         `json:"-" gorm:"size:64;uniqueIndex;not null"`
+	Plaintext      string         `json:"-" gorm:"-"` // only set on a newly created token
 	SubscriptionID uint           `json:"subscription_id" gorm:"index;not null"`
 	Subscription   Subscription   `json:"-" gorm:"foreignKey:SubscriptionID"`
-	Type           string         `json:"type" gorm:"not null"` // "confirmation" or "unsubscribe"
+	Type           string         `json:"type" gorm:"not null"` // "confirmation", "unsubscribe" or "change"
+	Payload        string         `json:"-" gorm:"type:text"`   // the pending PreferenceChange of a change token, as JSON
 	ExpiresAt      time.Time      `json:"expires_at"`
 	CreatedAt      time.Time      `json:"created_at"`
 	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// HashToken returns the hex encoded SHA-256 hash a token is stored and looked up by
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+const (
+	TokenTypeConfirmation = "confirmation"
+	TokenTypeUnsubscribe  = "unsubscribe"
+	TokenTypeChange       = "change" // applies the preference change stored in its payload
+)
+
+// Subscription frequencies: weather updates every hour or every day, or only emails about
+// severe weather alerts
+const (
+	FrequencyHourly = "hourly"
+	FrequencyDaily  = "daily"
+	FrequencyAlerts = "alerts"
+)
+
+// SentAlert records that a weather alert was emailed to a subscription
+type SentAlert struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	SubscriptionID uint      `json:"subscription_id" gorm:"uniqueIndex:idx_sent_alerts_subscription_alert;not null"`
+	AlertID        string    `json:"alert_id" gorm:"uniqueIndex:idx_sent_alerts_subscription_alert;not null"`
+	SentAt         time.Time `json:"sent_at" gorm:"index"`
+}
+
+// SentLink records when a link of a purpose was last emailed to an address. Management and
+// privacy links are sent to any address that asks for one, so each is sent at most once per
+// cooldown.
+type SentLink struct {
+	ID      uint      `json:"id" gorm:"primaryKey"`
+	Email   string    `json:"email" gorm:"uniqueIndex:idx_sent_links_email_purpose;not null"` // stored lower-cased
+	Purpose string    `json:"purpose" gorm:"uniqueIndex:idx_sent_links_email_purpose;not null"`
+	SentAt  time.Time `json:"sent_at" gorm:"index"`
+}
+
+// WeatherDigest is the weather update email of one address, covering every city it is
+// subscribed to at a frequency
+type WeatherDigest struct {
+	Frequency         string
+	Cities            []DigestCity
+	UnsubscribeAllURL string // unsubscribes the address from every city
+	ManageURL         string // subscription management page, optional
+}
+
+// DigestCity is the current weather of one subscription in a digest
+type DigestCity struct {
+	City           string
+	Weather        *WeatherResponse
+	UnsubscribeURL string
+}
+
+// WeatherAlert is a severe weather warning issued for a location
+type WeatherAlert struct {
+	ID          string     `json:"id"`
+	Headline    string     `json:"headline"`
+	Event       string     `json:"event"`
+	Severity    string     `json:"severity"`
+	Urgency     string     `json:"urgency"`
+	Certainty   string     `json:"certainty"`
+	Category    string     `json:"category"`
+	Areas       string     `json:"areas"`
+	Description string     `json:"description"`
+	Instruction string     `json:"instruction"`
+	Effective   *time.Time `json:"effective,omitempty"`
+	Expires     *time.Time `json:"expires,omitempty"`
+}
+
+// Forecast is a daily weather forecast in the requested unit system
+type Forecast struct {
+	Units    string          `json:"units"`
+	Location WeatherLocation `json:"location"`
+	Days     []ForecastDay   `json:"days"`
+}
+
+type ForecastDay struct {
+	Date         string  `json:"date"`
+	MaxTemp      float64 `json:"max_temp"`
+	MinTemp      float64 `json:"min_temp"`
+	AvgTemp      float64 `json:"avg_temp"`
+	MaxWind      float64 `json:"max_wind"`
+	TotalPrecip  float64 `json:"total_precip"`
+	ChanceOfRain int     `json:"chance_of_rain"`
+	ChanceOfSnow int     `json:"chance_of_snow"`
+	UVIndex      float64 `json:"uv_index"`
+	Description  string  `json:"description"`
+}
+
+// WeatherResponse holds current weather in the requested unit system: temperatures in °C or °F,
+// wind in km/h or mph, pressure in mb or inHg, precipitation in mm or in and visibility in km or miles
 type WeatherResponse struct {
-	Temperature float64 `json:"temperature"`
-	Humidity    float64 `json:"humidity"`
-	Description string  `json:"description"`
+	Units         string          `json:"units"`
+	Temperature   float64         `json:"temperature"`
+	FeelsLike     float64         `json:"feels_like"`
+	Humidity      float64         `json:"humidity"`
+	Description   string          `json:"description"`
+	ConditionCode int             `json:"condition_code"`
+	ConditionIcon string          `json:"condition_icon"`
+	IsDay         bool            `json:"is_day"`
+	WindSpeed     float64         `json:"wind_speed"`
+	WindDegree    int             `json:"wind_degree"`
+	WindDirection string          `json:"wind_direction"`
+	WindGust      float64         `json:"wind_gust"`
+	Pressure      float64         `json:"pressure"`
+	Precipitation float64         `json:"precipitation"`
+	Visibility    float64         `json:"visibility"`
+	CloudCover    int             `json:"cloud_cover"`
+	UVIndex       float64         `json:"uv_index"`
+	ObservedAt    *time.Time      `json:"observed_at,omitempty"`
+	Location      WeatherLocation `json:"location"`
+	AirQuality    *AirQuality     `json:"air_quality,omitempty"`
+}
+
+// AirQuality holds pollutant concentrations in μg/m3 and air quality indexes
+type AirQuality struct {
+	CO           float64 `json:"co"`
+	NO2          float64 `json:"no2"`
+	O3           float64 `json:"o3"`
+	SO2          float64 `json:"so2"`
+	PM25         float64 `json:"pm2_5"`
+	PM10         float64 `json:"pm10"`
+	USEPAIndex   int     `json:"us_epa_index"`
+	GBDefraIndex int     `json:"gb_defra_index"`
+}
+
+// USEPACategory describes the US EPA index (1-6) in words
+func (a AirQuality) USEPACategory() string {
+	switch a.USEPAIndex {
+	case 1:
+		return "Good"
+	case 2:
+		return "Moderate"
+	case 3:
+		return "Unhealthy for sensitive groups"
+	case 4:
+		return "Unhealthy"
+	case 5:
+		return "Very unhealthy"
+	case 6:
+		return "Hazardous"
+	default:
+		return "Unknown"
+	}
+}
+
+// WeatherLocation is the location resolved by the weather provider
+type WeatherLocation struct {
+	Name      string  `json:"name"`
+	Region    string  `json:"region"`
+	Country   string  `json:"country"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	Timezone  string  `json:"tz_id"`
+	LocalTime string  `json:"localtime"`
+}
+
+type CitySearchResult struct {
+	ID      int     `json:"id"`
+	Name    string  `json:"name"`
+	Region  string  `json:"region"`
+	Country string  `json:"country"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	URL     string  `json:"url"`
 }
 
 type SubscriptionRequest struct {
+	Email      string        `json:"email" form:"email" binding:"required,email"`
+	City       string        `json:"city" form:"city" binding:"required_without=Latitude"`
+	Latitude   *float64      `json:"lat" form:"lat" binding:"required_with=Longitude,omitempty,min=-90,max=90"`
+	Longitude  *float64      `json:"lon" form:"lon" binding:"required_with=Latitude,omitempty,min=-180,max=180"`
+	Frequency  string        `json:"frequency" form:"frequency" binding:"required,oneof=hourly daily alerts"`
+	Units      string        `json:"units" form:"units" binding:"omitempty,oneof=metric imperial"`
+	Language   string        `json:"lang" form:"lang"`
+	AirQuality bool          `json:"air_quality" form:"air_quality"`
+	Rules      []RuleRequest `json:"rules" form:"-" binding:"omitempty,max=10,dive"`
+}
+
+// ResendRequest asks for a new confirmation link of an unconfirmed subscription; without a
+// frequency it is the weather update subscription of the city
+type ResendRequest struct {
 	Email     string `json:"email" form:"email" binding:"required,email"`
 	City      string `json:"city" form:"city" binding:"required"`
-	Frequency string `json:"frequency" form:"frequency" binding:"required,oneof=hourly daily"`
+	Frequency string `json:"frequency" form:"frequency" binding:"omitempty,oneof=hourly daily alerts"`
+}
+
+// ManageLinkRequest asks for a management link to be emailed to an address
+type ManageLinkRequest struct {
+	Email string `json:"email" form:"email" binding:"required,email"`
+}
+
+// Data protection actions an address can be emailed a link for
+const (
+	PrivacyActionExport = "export" // download all data held about the address
+	PrivacyActionErase  = "erase"  // permanently erase all data held about the address
+)
+
+// PrivacyRequest asks for a data export or erasure link to be emailed to an address
+type PrivacyRequest struct {
+	Email  string `json:"email" form:"email" binding:"required,email"`
+	Action string `json:"action" form:"action" binding:"required,oneof=export erase"`
+}
+
+// PersonalData is everything stored about an email address, as returned by a data export
+type PersonalData struct {
+	Email         string                 `json:"email"`
+	ExportedAt    time.Time              `json:"exported_at"`
+	Subscriptions []PersonalSubscription `json:"subscriptions"`
+	SentAlerts    []SentAlert            `json:"sent_alerts"`
+	ConsentEvents []ConsentEvent         `json:"consent_events"`
+	Suppression   *Suppression           `json:"suppression,omitempty"`
+}
+
+// PersonalSubscription is a subscription in a data export. Deleted subscriptions are kept
+// until the retention job purges them, so they are exported with the time they were deleted.
+type PersonalSubscription struct {
+	Subscription
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// HasData reports whether any subscription or consent event is stored for the address
+func (d *PersonalData) HasData() bool {
+	return len(d.Subscriptions) > 0 || len(d.ConsentEvents) > 0
+}
+
+// ErasureResult counts what was erased for an address
+type ErasureResult struct {
+	Subscriptions int64 `json:"subscriptions"`  // deleted with their tokens, rules and sent alerts
+	ConsentEvents int64 `json:"consent_events"` // pseudonymized, since the audit trail is append-only
+}
+
+// ErasedEmail is the pseudonym that replaces an address in the consent events of an erased
+// address. It cannot be reversed, but the events of an address can still be found by hashing
+// it again, for example to answer a later dispute about consent.
+func ErasedEmail(email string) string {
+	return "erased:" + HashToken(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// SubscriptionUpdate changes a subscription from the management page; nil fields are left unchanged
+type SubscriptionUpdate struct {
+	City      *string `json:"city" binding:"omitempty,min=1"`
+	Frequency *string `json:"frequency" binding:"omitempty,oneof=hourly daily alerts"`
+	Paused    *bool   `json:"paused"`
+	// PausedUntil pauses the subscription until the given time, after which it resumes by itself
+	PausedUntil *time.Time `json:"paused_until"`
+}
+
+// PreferenceChange is a requested change of the preferences of a confirmed subscription,
+// applied once it is confirmed through the emailed link
+type PreferenceChange struct {
+	Frequency  string        `json:"frequency"`
+	Units      string        `json:"units"`
+	Language   string        `json:"lang"`
+	AirQuality bool          `json:"air_quality"`
+	Rules      []RuleRequest `json:"rules,omitempty"` // replace the current rules when given
+}
+
+// PauseRequest pauses a subscription; with Until (YYYY-MM-DD) it resumes at the start of that day, UTC
+type PauseRequest struct {
+	Until string `form:"until" binding:"omitempty,datetime=2006-01-02"`
+}
+
+type RuleRequest struct {
+	Metric    string   `json:"metric" binding:"required,oneof=min_temp max_temp rain_chance snow_chance max_wind total_precip uv"`
+	Operator  string   `json:"operator" binding:"required,oneof=lt lte gt gte"`
+	Threshold *float64 `json:"threshold" binding:"required"`
+	Day       int      `json:"day" binding:"min=0,max=2"`
 }
 
 type ErrorResponse struct {
 	Error string `json:"error"`
-}
\ No newline at end of file
+}
@@ -3,10 +3,12 @@ package repository
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"weatherapi.app/models"
 )
 
@@ -18,11 +20,21 @@ func NewSubscriptionRepository(db *gorm.DB) *SubscriptionRepository {
 	return &SubscriptionRepository{db: db}
 }
 
-func (r *SubscriptionRepository) FindByEmail(email, city string) (*models.Subscription, error) {
-	fmt.Printf("[DEBUG] SubscriptionRepository.FindByEmail: email=%s, city=%s\n", email, city)
+// FindByEmail returns the subscription of an address to a city of the same kind as frequency.
+// Weather updates (hourly or daily) and alerts are separate kinds, so an address can have one
+// of each for a city.
+func (r *SubscriptionRepository) FindByEmail(email, city, frequency string) (*models.Subscription, error) {
+	fmt.Printf("[DEBUG] SubscriptionRepository.FindByEmail: email=%s, city=%s, frequency=%s\n", email, city, frequency)
 	
+	query := r.db.Where("email = ? AND city = ?", email, city)
+	if frequency == models.FrequencyAlerts {
+		query = query.Where("frequency = ?", models.FrequencyAlerts)
+	} else {
+		query = query.Where("frequency <> ?", models.FrequencyAlerts)
+	}
+
 	var subscription models.Subscription
-	result := r.db.Where("email = ? AND city = ?", email, city).First(&subscription)
+	result := query.First(&subscription)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			fmt.Println("[DEBUG] No subscription found")
@@ -50,6 +62,21 @@ func (r *SubscriptionRepository) FindByID(id uint) (*models.Subscription, error)
 	return &subscription, nil
 }
 
+// FindAllByEmail returns every subscription of an email address, ignoring case
+func (r *SubscriptionRepository) FindAllByEmail(email string) ([]models.Subscription, error) {
+	fmt.Printf("[DEBUG] SubscriptionRepository.FindAllByEmail: email=%s\n", email)
+
+	var subscriptions []models.Subscription
+	result := r.db.Where("LOWER(email) = LOWER(?)", email).Order("id").Find(&subscriptions)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when finding subscriptions by email: %v\n", result.Error)
+		return nil, result.Error
+	}
+
+	fmt.Printf("[DEBUG] Found %d subscriptions\n", len(subscriptions))
+	return subscriptions, nil
+}
+
 func (r *SubscriptionRepository) Create(subscription *models.Subscription) error {
 	fmt.Printf("[DEBUG] SubscriptionRepository.Create: %+v\n", subscription)
 	
@@ -92,8 +119,15 @@ func (r *SubscriptionRepository) Delete(subscription *models.Subscription) error
 func (r *SubscriptionRepository) GetSubscriptionsForUpdates(frequency string) ([]models.Subscription, error) {
 	fmt.Printf("[DEBUG] SubscriptionRepository.GetSubscriptionsForUpdates: frequency=%s\n", frequency)
 	
+	if err := resumeExpiredPauses(r.db); err != nil {
+		return nil, err
+	}
+	
 	var subscriptions []models.Subscription
-	result := r.db.Where("frequency = ? AND confirmed = ?", frequency, true).Find(&subscriptions)
+	result := r.db.
+		Where("frequency = ? AND confirmed = ? AND suppressed = ? AND paused = ?", frequency, true, false, false).
+		Where("LOWER(email) NOT IN (?)", r.db.Model(&models.Suppression{}).Select("email")).
+		Find(&subscriptions)
 	if result.Error != nil {
 		fmt.Printf("[ERROR] Database error when getting subscriptions for updates: %v\n", result.Error)
 		return nil, result.Error
@@ -103,6 +137,38 @@ func (r *SubscriptionRepository) GetSubscriptionsForUpdates(frequency string) ([
 	return subscriptions, nil
 }
 
+// SuppressByEmail marks every subscription of an email address as suppressed and returns how many were affected
+func (r *SubscriptionRepository) SuppressByEmail(email, reason string) (int64, error) {
+	fmt.Printf("[DEBUG] SubscriptionRepository.SuppressByEmail: email=%s, reason=%s\n", email, reason)
+
+	result := r.db.Model(&models.Subscription{}).
+		Where("LOWER(email) = LOWER(?) AND suppressed = ?", email, false).
+		Updates(map[string]interface{}{"suppressed": true, "suppression_reason": reason})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when suppressing subscriptions: %v\n", result.Error)
+		return 0, result.Error
+	}
+
+	fmt.Printf("[DEBUG] Suppressed %d subscriptions for: %s\n", result.RowsAffected, email)
+	return result.RowsAffected, nil
+}
+
+// UnsuppressByEmail lifts the bounce suppression of every subscription of an email address
+func (r *SubscriptionRepository) UnsuppressByEmail(email string) error {
+	fmt.Printf("[DEBUG] SubscriptionRepository.UnsuppressByEmail: email=%s\n", email)
+
+	result := r.db.Model(&models.Subscription{}).
+		Where("LOWER(email) = LOWER(?) AND suppressed = ?", email, true).
+		Updates(map[string]interface{}{"suppressed": false, "suppression_reason": ""})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when unsuppressing subscriptions: %v\n", result.Error)
+		return result.Error
+	}
+
+	fmt.Printf("[DEBUG] Unsuppressed %d subscriptions for: %s\n", result.RowsAffected, email)
+	return nil
+}
+
 type TokenRepository struct {
 	db *gorm.DB
 }
@@ -112,13 +178,21 @@ func NewTokenRepository(db *gorm.DB) *TokenRepository {
 }
 
 func (r *TokenRepository) CreateToken(subscriptionID uint, tokenType string, expiresIn time.Duration) (*models.Token, error) {
+	return r.CreateTokenWithPayload(subscriptionID, tokenType, "", expiresIn)
+}
+
+// CreateTokenWithPayload creates a token carrying data for its action, such as a pending preference change
+func (r *TokenRepository) CreateTokenWithPayload(subscriptionID uint, tokenType, payload string, expiresIn time.Duration) (*models.Token, error) {
 	fmt.Printf("[DEBUG] TokenRepository.CreateToken: subscriptionID=%d, type=%s, expiresIn=%v\n", 
 		subscriptionID, tokenType, expiresIn)
 	
+	plaintext := uuid.New().String()
 	token := &models.Token{
-		Token:          uuid.New().String(),
+		TokenHash:      models.HashToken(plaintext),
+		Plaintext:      plaintext,
 		SubscriptionID: subscriptionID,
 		Type:           tokenType,
+		Payload:        payload,
 		ExpiresAt:      time.Now().Add(expiresIn),
 	}
 	
@@ -128,26 +202,26 @@ func (r *TokenRepository) CreateToken(subscriptionID uint, tokenType string, exp
 		return nil, result.Error
 	}
 	
-	fmt.Printf("[DEBUG] Created token: %s, ID: %d\n", token.Token, token.ID)
+	fmt.Printf("[DEBUG] Created token ID: %d\n", token.ID)
 	return token, nil
 }
 
 func (r *TokenRepository) FindByToken(tokenStr string) (*models.Token, error) {
-	fmt.Printf("[DEBUG] TokenRepository.FindByToken: token=%s\n", tokenStr)
+	fmt.Println("[DEBUG] TokenRepository.FindByToken called")
 	
 	var token models.Token
-	result := r.db.Where("token = ? AND expires_at > ?", tokenStr, time.Now()).First(&token)
+	result := r.db.Where("token_hash = ? AND expires_at > ?", models.HashToken(tokenStr), time.Now()).First(&token)
 	if result.Error != nil {
 		fmt.Printf("[ERROR] Database error when finding token: %v\n", result.Error)
 		return nil, result.Error
 	}
 	
-	fmt.Printf("[DEBUG] Found token: %+v\n", token)
+	fmt.Printf("[DEBUG] Found token ID: %d, type: %s\n", token.ID, token.Type)
 	return &token, nil
 }
 
 func (r *TokenRepository) DeleteToken(token *models.Token) error {
-	fmt.Printf("[DEBUG] TokenRepository.DeleteToken: %+v\n", token)
+	fmt.Printf("[DEBUG] TokenRepository.DeleteToken: ID=%d\n", token.ID)
 	
 	result := r.db.Delete(token)
 	if result.Error != nil {
@@ -159,6 +233,67 @@ func (r *TokenRepository) DeleteToken(token *models.Token) error {
 	return nil
 }
 
+// FindLatest returns the most recently created token of a type for a subscription, or nil when there is none
+func (r *TokenRepository) FindLatest(subscriptionID uint, tokenType string) (*models.Token, error) {
+	fmt.Printf("[DEBUG] TokenRepository.FindLatest: subscriptionID=%d, type=%s\n", subscriptionID, tokenType)
+
+	var token models.Token
+	result := r.db.Where("subscription_id = ? AND type = ?", subscriptionID, tokenType).Order("created_at DESC, id DESC").First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		fmt.Printf("[ERROR] Database error when finding latest token: %v\n", result.Error)
+		return nil, result.Error
+	}
+
+	return &token, nil
+}
+
+// DeleteBySubscription deletes every token of a type for a subscription
+func (r *TokenRepository) DeleteBySubscription(subscriptionID uint, tokenType string) error {
+	fmt.Printf("[DEBUG] TokenRepository.DeleteBySubscription: subscriptionID=%d, type=%s\n", subscriptionID, tokenType)
+
+	result := r.db.Where("subscription_id = ? AND type = ?", subscriptionID, tokenType).Delete(&models.Token{})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when deleting tokens: %v\n", result.Error)
+		return result.Error
+	}
+
+	fmt.Printf("[DEBUG] Deleted %d tokens\n", result.RowsAffected)
+	return nil
+}
+
+// RevokeAll deletes every stored token of a subscription and bumps its token version, which
+// invalidates the signed tokens of the links already sent to it. It returns the number of
+// stored tokens deleted.
+func (r *TokenRepository) RevokeAll(subscriptionID uint) (int64, error) {
+	fmt.Printf("[DEBUG] TokenRepository.RevokeAll: subscriptionID=%d\n", subscriptionID)
+
+	var revoked int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Subscription{}).Where("id = ?", subscriptionID).
+			Update("token_version", gorm.Expr("token_version + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		result = tx.Unscoped().Where("subscription_id = ?", subscriptionID).Delete(&models.Token{})
+		revoked = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		fmt.Printf("[ERROR] Database error when revoking tokens: %v\n", err)
+		return 0, err
+	}
+
+	fmt.Printf("[DEBUG] Revoked %d tokens of subscription %d\n", revoked, subscriptionID)
+	return revoked, nil
+}
+
 func (r *TokenRepository) DeleteExpiredTokens() error {
 	fmt.Println("[DEBUG] TokenRepository.DeleteExpiredTokens called")
 	
@@ -170,4 +305,477 @@ func (r *TokenRepository) DeleteExpiredTokens() error {
 	
 	fmt.Printf("[DEBUG] Deleted %d expired tokens\n", result.RowsAffected)
 	return nil
-}
\ No newline at end of file
+}
+type AlertRepository struct {
+	db *gorm.DB
+}
+
+func NewAlertRepository(db *gorm.DB) *AlertRepository {
+	return &AlertRepository{db: db}
+}
+
+func (r *AlertRepository) HasBeenSent(subscriptionID uint, alertID string) (bool, error) {
+	fmt.Printf("[DEBUG] AlertRepository.HasBeenSent: subscriptionID=%d, alertID=%s\n", subscriptionID, alertID)
+
+	var count int64
+	result := r.db.Model(&models.SentAlert{}).
+		Where("subscription_id = ? AND alert_id = ?", subscriptionID, alertID).
+		Count(&count)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when checking sent alert: %v\n", result.Error)
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
+
+func (r *AlertRepository) MarkSent(subscriptionID uint, alertID string) error {
+	fmt.Printf("[DEBUG] AlertRepository.MarkSent: subscriptionID=%d, alertID=%s\n", subscriptionID, alertID)
+
+	sentAlert := &models.SentAlert{
+		SubscriptionID: subscriptionID,
+		AlertID:        alertID,
+		SentAt:         time.Now(),
+	}
+
+	result := r.db.Create(sentAlert)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when recording sent alert: %v\n", result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+func (r *AlertRepository) DeleteSentBefore(before time.Time) error {
+	fmt.Printf("[DEBUG] AlertRepository.DeleteSentBefore: before=%v\n", before)
+
+	result := r.db.Where("sent_at < ?", before).Delete(&models.SentAlert{})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when deleting sent alerts: %v\n", result.Error)
+		return result.Error
+	}
+
+	fmt.Printf("[DEBUG] Deleted %d sent alerts\n", result.RowsAffected)
+	return nil
+}
+
+// PurgeUnconfirmed permanently deletes subscriptions created before a time that were never
+// confirmed, together with their tokens and rules. Subscriptions with a confirmation link that
+// is still valid are kept, so a resent confirmation can still be used.
+func (r *SubscriptionRepository) PurgeUnconfirmed(before time.Time) (int64, error) {
+	fmt.Printf("[DEBUG] SubscriptionRepository.PurgeUnconfirmed: before=%v\n", before)
+
+	pending := r.db.Model(&models.Token{}).Select("subscription_id").
+		Where("type = ? AND expires_at > ?", models.TokenTypeConfirmation, time.Now())
+
+	var ids []uint
+	result := r.db.Unscoped().Model(&models.Subscription{}).
+		Where("confirmed = ? AND created_at < ?", false, before).
+		Where("id NOT IN (?)", pending).
+		Pluck("id", &ids)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when finding unconfirmed subscriptions: %v\n", result.Error)
+		return 0, result.Error
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	tx := r.db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	purged, err := purgeSubscriptions(tx, ids)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		fmt.Printf("[ERROR] Database error when committing purge: %v\n", err)
+		return 0, err
+	}
+
+	fmt.Printf("[DEBUG] Purged %d unconfirmed subscriptions\n", purged)
+	return purged, nil
+}
+
+// PurgeDeleted permanently deletes subscriptions that were deleted before a time, together
+// with their tokens, rules and sent alerts, and the used tokens deleted before that time
+func (r *SubscriptionRepository) PurgeDeleted(before time.Time) (int64, error) {
+	fmt.Printf("[DEBUG] SubscriptionRepository.PurgeDeleted: before=%v\n", before)
+
+	var ids []uint
+	result := r.db.Unscoped().Model(&models.Subscription{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Pluck("id", &ids)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when finding deleted subscriptions: %v\n", result.Error)
+		return 0, result.Error
+	}
+
+	tx := r.db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	purged, err := purgeSubscriptions(tx, ids)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", before).Delete(&models.Token{}).Error; err != nil {
+		fmt.Printf("[ERROR] Database error when purging deleted tokens: %v\n", err)
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		fmt.Printf("[ERROR] Database error when committing purge: %v\n", err)
+		return 0, err
+	}
+
+	fmt.Printf("[DEBUG] Purged %d deleted subscriptions\n", purged)
+	return purged, nil
+}
+
+// purgeSubscriptions permanently deletes subscriptions with their tokens, notification rules
+// and sent alerts inside a transaction, returning the number of subscriptions deleted
+func purgeSubscriptions(tx *gorm.DB, ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := tx.Unscoped().Where("subscription_id IN ?", ids).Delete(&models.Token{}).Error; err != nil {
+		fmt.Printf("[ERROR] Database error when purging tokens: %v\n", err)
+		return 0, err
+	}
+	if err := tx.Where("subscription_id IN ?", ids).Delete(&models.NotificationRule{}).Error; err != nil {
+		fmt.Printf("[ERROR] Database error when purging notification rules: %v\n", err)
+		return 0, err
+	}
+	if err := tx.Where("subscription_id IN ?", ids).Delete(&models.SentAlert{}).Error; err != nil {
+		fmt.Printf("[ERROR] Database error when purging sent alerts: %v\n", err)
+		return 0, err
+	}
+	result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.Subscription{})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when purging subscriptions: %v\n", result.Error)
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// resumeExpiredPauses resumes the subscriptions whose pause has ended, so they are picked
+// up again by the queries for updates, alerts and notification rules
+func resumeExpiredPauses(db *gorm.DB) error {
+	result := db.Model(&models.Subscription{}).
+		Where("paused = ? AND paused_until IS NOT NULL AND paused_until <= ?", true, time.Now()).
+		Updates(map[string]interface{}{"paused": false, "paused_until": nil})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when resuming paused subscriptions: %v\n", result.Error)
+		return result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		fmt.Printf("[DEBUG] Resumed %d paused subscriptions\n", result.RowsAffected)
+	}
+	return nil
+}
+
+type RuleRepository struct {
+	db *gorm.DB
+}
+
+func NewRuleRepository(db *gorm.DB) *RuleRepository {
+	return &RuleRepository{db: db}
+}
+
+func (r *RuleRepository) GetRulesForEvaluation() ([]models.NotificationRule, error) {
+	fmt.Println("[DEBUG] RuleRepository.GetRulesForEvaluation called")
+
+	if err := resumeExpiredPauses(r.db); err != nil {
+		return nil, err
+	}
+
+	var rules []models.NotificationRule
+	result := r.db.
+		Joins("JOIN subscriptions ON subscriptions.id = notification_rules.subscription_id").
+		Where("subscriptions.confirmed = ? AND subscriptions.suppressed = ? AND subscriptions.paused = ? AND subscriptions.deleted_at IS NULL", true, false, false).
+		Where("LOWER(subscriptions.email) NOT IN (?)", r.db.Model(&models.Suppression{}).Select("email")).
+		Preload("Subscription").
+		Order("notification_rules.subscription_id, notification_rules.id").
+		Find(&rules)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when getting notification rules: %v\n", result.Error)
+		return nil, result.Error
+	}
+
+	fmt.Printf("[DEBUG] Found %d notification rules\n", len(rules))
+	return rules, nil
+}
+
+func (r *RuleRepository) MarkTriggered(ruleID uint, forecastDate string) error {
+	fmt.Printf("[DEBUG] RuleRepository.MarkTriggered: ruleID=%d, forecastDate=%s\n", ruleID, forecastDate)
+
+	result := r.db.Model(&models.NotificationRule{}).
+		Where("id = ?", ruleID).
+		Update("last_triggered_for", forecastDate)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when marking rule as triggered: %v\n", result.Error)
+		return result.Error
+	}
+
+	return nil
+}
+
+// LinkRepository remembers when links that anyone can request were last emailed to an address
+type LinkRepository struct {
+	db *gorm.DB
+}
+
+func NewLinkRepository(db *gorm.DB) *LinkRepository {
+	return &LinkRepository{db: db}
+}
+
+// Claim records that a link of a purpose is about to be emailed to an address. It reports
+// false, recording nothing, when such a link was already sent within the cooldown. A claim is
+// a single conditional write, so concurrent requests cannot both send a link.
+func (r *LinkRepository) Claim(email, purpose string, cooldown time.Duration) (bool, error) {
+	fmt.Printf("[DEBUG] LinkRepository.Claim: email=%s, purpose=%s\n", email, purpose)
+
+	now := time.Now()
+	sentLink := &models.SentLink{Email: normalizeEmail(email), Purpose: purpose, SentAt: now}
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(sentLink)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when recording sent link: %v\n", result.Error)
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// A link was sent before; only a send older than the cooldown can be replaced
+	result = r.db.Model(&models.SentLink{}).
+		Where("email = ? AND purpose = ? AND sent_at <= ?", sentLink.Email, purpose, now.Add(-cooldown)).
+		Update("sent_at", now)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when updating sent link: %v\n", result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func (r *LinkRepository) DeleteSentBefore(before time.Time) error {
+	fmt.Printf("[DEBUG] LinkRepository.DeleteSentBefore: before=%v\n", before)
+
+	result := r.db.Where("sent_at < ?", before).Delete(&models.SentLink{})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when deleting sent links: %v\n", result.Error)
+		return result.Error
+	}
+
+	fmt.Printf("[DEBUG] Deleted %d sent links\n", result.RowsAffected)
+	return nil
+}
+
+type SuppressionRepository struct {
+	db *gorm.DB
+}
+
+func NewSuppressionRepository(db *gorm.DB) *SuppressionRepository {
+	return &SuppressionRepository{db: db}
+}
+
+// normalizeEmail is the form email addresses are stored in on the suppression list
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (r *SuppressionRepository) IsSuppressed(email string) (bool, error) {
+	fmt.Printf("[DEBUG] SuppressionRepository.IsSuppressed: email=%s\n", email)
+
+	var count int64
+	result := r.db.Model(&models.Suppression{}).Where("email = ?", normalizeEmail(email)).Count(&count)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when checking suppression: %v\n", result.Error)
+		return false, result.Error
+	}
+
+	return count > 0, nil
+}
+
+// Add puts an email address on the suppression list. It reports false without an error
+// when the address was already suppressed.
+func (r *SuppressionRepository) Add(suppression *models.Suppression) (bool, error) {
+	fmt.Printf("[DEBUG] SuppressionRepository.Add: %+v\n", suppression)
+
+	suppression.Email = normalizeEmail(suppression.Email)
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(suppression)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when adding suppression: %v\n", result.Error)
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+func (r *SuppressionRepository) Remove(email string) error {
+	fmt.Printf("[DEBUG] SuppressionRepository.Remove: email=%s\n", email)
+
+	result := r.db.Where("email = ?", normalizeEmail(email)).Delete(&models.Suppression{})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when removing suppression: %v\n", result.Error)
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (r *SuppressionRepository) List() ([]models.Suppression, error) {
+	fmt.Println("[DEBUG] SuppressionRepository.List called")
+
+	var suppressions []models.Suppression
+	result := r.db.Order("created_at DESC, id DESC").Find(&suppressions)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when listing suppressions: %v\n", result.Error)
+		return nil, result.Error
+	}
+
+	return suppressions, nil
+}
+
+type ConsentRepository struct {
+	db *gorm.DB
+}
+
+func NewConsentRepository(db *gorm.DB) *ConsentRepository {
+	return &ConsentRepository{db: db}
+}
+
+// List returns the consent events of an address in the order they were recorded, or the
+// events of every address when email is empty
+func (r *ConsentRepository) List(email string) ([]models.ConsentEvent, error) {
+	fmt.Printf("[DEBUG] ConsentRepository.List: email=%s\n", email)
+
+	query := r.db.Order("id")
+	if email != "" {
+		query = query.Where("email = ?", normalizeEmail(email))
+	}
+
+	var events []models.ConsentEvent
+	if err := query.Find(&events).Error; err != nil {
+		fmt.Printf("[ERROR] Database error when listing consent events: %v\n", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// PrivacyRepository reads and erases everything stored about an email address
+type PrivacyRepository struct {
+	db *gorm.DB
+}
+
+func NewPrivacyRepository(db *gorm.DB) *PrivacyRepository {
+	return &PrivacyRepository{db: db}
+}
+
+// Export returns everything stored about an address, including deleted subscriptions that
+// have not been purged yet
+func (r *PrivacyRepository) Export(email string) (*models.PersonalData, error) {
+	fmt.Printf("[DEBUG] PrivacyRepository.Export: email=%s\n", email)
+
+	data := &models.PersonalData{
+		Email:         normalizeEmail(email),
+		ExportedAt:    time.Now().UTC(),
+		Subscriptions: []models.PersonalSubscription{},
+		SentAlerts:    []models.SentAlert{},
+	}
+
+	var subscriptions []models.Subscription
+	result := r.db.Unscoped().Preload("Rules").Where("LOWER(email) = LOWER(?)", email).Order("id").Find(&subscriptions)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when exporting subscriptions: %v\n", result.Error)
+		return nil, result.Error
+	}
+	ids := make([]uint, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		exported := models.PersonalSubscription{Subscription: subscription}
+		if subscription.DeletedAt.Valid {
+			deletedAt := subscription.DeletedAt.Time
+			exported.DeletedAt = &deletedAt
+		}
+		data.Subscriptions = append(data.Subscriptions, exported)
+		ids = append(ids, subscription.ID)
+	}
+
+	if len(ids) > 0 {
+		if err := r.db.Where("subscription_id IN ?", ids).Order("id").Find(&data.SentAlerts).Error; err != nil {
+			fmt.Printf("[ERROR] Database error when exporting sent alerts: %v\n", err)
+			return nil, err
+		}
+	}
+
+	if err := r.db.Where("email = ?", data.Email).Order("id").Find(&data.ConsentEvents).Error; err != nil {
+		fmt.Printf("[ERROR] Database error when exporting consent events: %v\n", err)
+		return nil, err
+	}
+
+	var suppressions []models.Suppression
+	if err := r.db.Where("email = ?", data.Email).Limit(1).Find(&suppressions).Error; err != nil {
+		fmt.Printf("[ERROR] Database error when exporting suppression: %v\n", err)
+		return nil, err
+	}
+	if len(suppressions) > 0 {
+		data.Suppression = &suppressions[0]
+	}
+
+	return data, nil
+}
+
+// Erase permanently deletes the subscriptions of an address with their tokens, rules, sent
+// alerts and sent links, and pseudonymizes its consent events. A suppression entry is kept, since it is what
+// stops the address from being emailed again.
+func (r *PrivacyRepository) Erase(email string) (*models.ErasureResult, error) {
+	fmt.Println("[DEBUG] PrivacyRepository.Erase called")
+
+	var ids []uint
+	result := r.db.Unscoped().Model(&models.Subscription{}).Where("LOWER(email) = LOWER(?)", email).Pluck("id", &ids)
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when finding subscriptions to erase: %v\n", result.Error)
+		return nil, result.Error
+	}
+
+	tx := r.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	erased, err := purgeSubscriptions(tx, ids)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Where("email = ?", normalizeEmail(email)).Delete(&models.SentLink{}).Error; err != nil {
+		fmt.Printf("[ERROR] Database error when deleting sent links: %v\n", err)
+		tx.Rollback()
+		return nil, err
+	}
+	// Consent events are append-only, so the hooks that enforce it are skipped here
+	result = tx.Session(&gorm.Session{SkipHooks: true}).Model(&models.ConsentEvent{}).
+		Where("email = ?", normalizeEmail(email)).
+		Updates(map[string]interface{}{"email": models.ErasedEmail(email), "ip": "", "user_agent": ""})
+	if result.Error != nil {
+		fmt.Printf("[ERROR] Database error when pseudonymizing consent events: %v\n", result.Error)
+		tx.Rollback()
+		return nil, result.Error
+	}
+	if err := tx.Commit().Error; err != nil {
+		fmt.Printf("[ERROR] Database error when committing erasure: %v\n", err)
+		return nil, err
+	}
+
+	fmt.Printf("[DEBUG] Erased %d subscriptions and pseudonymized %d consent events\n", erased, result.RowsAffected)
+	return &models.ErasureResult{Subscriptions: erased, ConsentEvents: result.RowsAffected}, nil
+}
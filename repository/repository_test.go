@@ -16,7 +16,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	assert.NoError(t, err)
 
 	// Run migrations
-	err = db.AutoMigrate(&models.Subscription{}, &models.Token{})
+	err = db.AutoMigrate(&models.Subscription{}, &models.Token{}, &models.SentAlert{}, &models.NotificationRule{}, &models.Suppression{}, &models.ConsentEvent{}, &models.SentLink{})
 	assert.NoError(t, err)
 
 	return db
@@ -28,7 +28,7 @@ func TestSubscriptionRepository_FindByEmail(t *testing.T) {
 	repo := NewSubscriptionRepository(db)
 
 	// Test with non-existent subscription
-	sub, err := repo.FindByEmail("nonexistent@example.com", "London")
+	sub, err := repo.FindByEmail("nonexistent@example.com", "London", "daily")
 	assert.NoError(t, err)
 	assert.Nil(t, sub)
 
@@ -44,13 +44,36 @@ func TestSubscriptionRepository_FindByEmail(t *testing.T) {
 	assert.NoError(t, result.Error)
 
 	// Test with existing subscription
-	sub, err = repo.FindByEmail("test@example.com", "London")
+	sub, err = repo.FindByEmail("test@example.com", "London", "daily")
 	assert.NoError(t, err)
 	assert.NotNil(t, sub)
 	assert.Equal(t, "test@example.com", sub.Email)
 	assert.Equal(t, "London", sub.City)
 	assert.Equal(t, "daily", sub.Frequency)
 	assert.True(t, sub.Confirmed)
+
+	// Hourly and daily updates are the same kind of subscription
+	sub, err = repo.FindByEmail("test@example.com", "London", "hourly")
+	assert.NoError(t, err)
+	assert.NotNil(t, sub)
+
+	// Alerts are a separate subscription for the same city
+	sub, err = repo.FindByEmail("test@example.com", "London", models.FrequencyAlerts)
+	assert.NoError(t, err)
+	assert.Nil(t, sub)
+
+	alerts := models.Subscription{Email: "test@example.com", City: "London", Frequency: models.FrequencyAlerts}
+	assert.NoError(t, db.Create(&alerts).Error)
+	sub, err = repo.FindByEmail("test@example.com", "London", models.FrequencyAlerts)
+	assert.NoError(t, err)
+	if assert.NotNil(t, sub) {
+		assert.Equal(t, alerts.ID, sub.ID)
+	}
+	sub, err = repo.FindByEmail("test@example.com", "London", "daily")
+	assert.NoError(t, err)
+	if assert.NotNil(t, sub) {
+		assert.Equal(t, testSub.ID, sub.ID)
+	}
 }
 
 // TestSubscriptionRepository_Create tests creating a new subscription
@@ -100,15 +123,17 @@ func TestTokenRepository_CreateToken(t *testing.T) {
 	token, err := repo.CreateToken(testSub.ID, "confirmation", 24*time.Hour)
 	assert.NoError(t, err)
 	assert.NotNil(t, token)
-	assert.NotEmpty(t, token.Token)
+	assert.NotEmpty(t, token.Plaintext)
 	assert.Equal(t, testSub.ID, token.SubscriptionID)
 	assert.Equal(t, "confirmation", token.Type)
 
-	// Verify token was created in DB
+	// Verify token was created in DB, holding only the hash of the token
 	var dbToken models.Token
 	result = db.First(&dbToken, token.ID)
 	assert.NoError(t, result.Error)
-	assert.Equal(t, token.Token, dbToken.Token)
+	assert.Empty(t, dbToken.Plaintext)
+	assert.Equal(t, models.HashToken(token.Plaintext), dbToken.TokenHash)
+	assert.NotContains(t, dbToken.TokenHash, token.Plaintext)
 	assert.Equal(t, testSub.ID, dbToken.SubscriptionID)
 	assert.Equal(t, "confirmation", dbToken.Type)
 }
@@ -132,7 +157,7 @@ func TestTokenRepository_FindByToken(t *testing.T) {
 	// Create a test token
 	tokenString := "test-token-123"
 	testToken := models.Token{
-		Token:          tokenString,
+		TokenHash:      models.HashToken(tokenString),
 		SubscriptionID: testSub.ID,
 		Type:           "confirmation",
 		ExpiresAt:      time.Now().Add(24 * time.Hour),
@@ -145,12 +170,522 @@ func TestTokenRepository_FindByToken(t *testing.T) {
 	token, err := repo.FindByToken(tokenString)
 	assert.NoError(t, err)
 	assert.NotNil(t, token)
-	assert.Equal(t, tokenString, token.Token)
+	assert.Equal(t, testToken.ID, token.ID)
 	assert.Equal(t, testSub.ID, token.SubscriptionID)
 	assert.Equal(t, "confirmation", token.Type)
 
+	// The stored hash is not a token
+	token, err = repo.FindByToken(testToken.TokenHash)
+	assert.Error(t, err)
+	assert.Nil(t, token)
+
 	// Test with non-existent token
 	token, err = repo.FindByToken("nonexistent-token")
 	assert.Error(t, err)
 	assert.Nil(t, token)
 }
+
+// TestAlertRepository tests recording sent alerts and cleaning them up
+func TestAlertRepository(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewAlertRepository(db)
+
+	sent, err := repo.HasBeenSent(1, "alert-1")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	err = repo.MarkSent(1, "alert-1")
+	assert.NoError(t, err)
+
+	sent, err = repo.HasBeenSent(1, "alert-1")
+	assert.NoError(t, err)
+	assert.True(t, sent)
+
+	// Other subscriptions have not received the alert
+	sent, err = repo.HasBeenSent(2, "alert-1")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	// The same alert cannot be recorded twice for a subscription
+	err = repo.MarkSent(1, "alert-1")
+	assert.Error(t, err)
+
+	err = repo.DeleteSentBefore(time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	sent, err = repo.HasBeenSent(1, "alert-1")
+	assert.NoError(t, err)
+	assert.False(t, sent)
+}
+
+// TestLinkRepository_Claim tests that a link can be claimed once per cooldown for each address and purpose
+func TestLinkRepository_Claim(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewLinkRepository(db)
+
+	claimed, err := repo.Claim("Claim@example.com", "manage", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Addresses are compared case-insensitively
+	claimed, err = repo.Claim("claim@example.com", "manage", time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	// Other purposes have their own cooldown
+	claimed, err = repo.Claim("claim@example.com", "export", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// Once the cooldown has passed the link can be claimed again
+	assert.NoError(t, db.Model(&models.SentLink{}).Where("email = ?", "claim@example.com").Update("sent_at", time.Now().Add(-2*time.Hour)).Error)
+	claimed, err = repo.Claim("claim@example.com", "manage", time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	assert.NoError(t, repo.DeleteSentBefore(time.Now().Add(-time.Hour)))
+	var count int64
+	db.Model(&models.SentLink{}).Where("email = ?", "claim@example.com").Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestRuleRepository tests loading rules of confirmed subscriptions and marking them as triggered
+func TestRuleRepository(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRuleRepository(db)
+
+	confirmed := models.Subscription{
+		Email:     "rules-confirmed@example.com",
+		City:      "Oslo",
+		Frequency: "daily",
+		Confirmed: true,
+		Rules: []models.NotificationRule{
+			{Metric: "min_temp", Operator: "lt", Threshold: 0, Day: 1},
+		},
+	}
+	assert.NoError(t, db.Create(&confirmed).Error)
+
+	unconfirmed := models.Subscription{
+		Email:     "rules-unconfirmed@example.com",
+		City:      "Oslo",
+		Frequency: "daily",
+		Rules: []models.NotificationRule{
+			{Metric: "rain_chance", Operator: "gt", Threshold: 70},
+		},
+	}
+	assert.NoError(t, db.Create(&unconfirmed).Error)
+
+	rules, err := repo.GetRulesForEvaluation()
+	assert.NoError(t, err)
+
+	var found *models.NotificationRule
+	for i := range rules {
+		assert.NotEqual(t, unconfirmed.ID, rules[i].SubscriptionID)
+		if rules[i].SubscriptionID == confirmed.ID {
+			found = &rules[i]
+		}
+	}
+	assert.NotNil(t, found)
+	assert.Equal(t, "rules-confirmed@example.com", found.Subscription.Email)
+
+	err = repo.MarkTriggered(found.ID, "2024-01-22")
+	assert.NoError(t, err)
+
+	var stored models.NotificationRule
+	assert.NoError(t, db.First(&stored, found.ID).Error)
+	assert.Equal(t, "2024-01-22", stored.LastTriggeredFor)
+}
+
+// TestSubscriptionRepository_SuppressByEmail tests that suppressed subscriptions no longer get updates
+func TestSubscriptionRepository_SuppressByEmail(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSubscriptionRepository(db)
+
+	bounced := []models.Subscription{
+		{Email: "Bounced@example.com", City: "Paris", Frequency: "hourly", Confirmed: true},
+		{Email: "bounced@example.com", City: "Rome", Frequency: "hourly", Confirmed: true},
+	}
+	assert.NoError(t, db.Create(&bounced).Error)
+	active := models.Subscription{Email: "active@example.com", City: "Paris", Frequency: "hourly", Confirmed: true}
+	assert.NoError(t, db.Create(&active).Error)
+
+	suppressed, err := repo.SuppressByEmail("bounced@example.com", "bounce: 550 user unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), suppressed)
+
+	// Suppressing again does not touch already suppressed subscriptions
+	suppressed, err = repo.SuppressByEmail("bounced@example.com", "complaint")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), suppressed)
+
+	var stored models.Subscription
+	assert.NoError(t, db.First(&stored, bounced[0].ID).Error)
+	assert.True(t, stored.Suppressed)
+	assert.Equal(t, "bounce: 550 user unknown", stored.SuppressionReason)
+
+	subscriptions, err := repo.GetSubscriptionsForUpdates("hourly")
+	assert.NoError(t, err)
+
+	var ids []uint
+	for _, subscription := range subscriptions {
+		ids = append(ids, subscription.ID)
+	}
+	assert.Contains(t, ids, active.ID)
+	assert.NotContains(t, ids, bounced[0].ID)
+	assert.NotContains(t, ids, bounced[1].ID)
+}
+
+// TestSuppressionRepository tests the suppression list and that it stops updates
+func TestSuppressionRepository(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSuppressionRepository(db)
+	subscriptionRepo := NewSubscriptionRepository(db)
+
+	listed := models.Subscription{Email: "Listed@example.com", City: "Berlin", Frequency: "daily", Confirmed: true}
+	assert.NoError(t, db.Create(&listed).Error)
+
+	added, err := repo.Add(&models.Suppression{Email: " Listed@Example.com ", Reason: "abuse", Source: models.SuppressionSourceAdmin})
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	added, err = repo.Add(&models.Suppression{Email: "listed@example.com", Source: models.BounceTypeBounce})
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	suppressed, err := repo.IsSuppressed("LISTED@example.com")
+	assert.NoError(t, err)
+	assert.True(t, suppressed)
+
+	suppressions, err := repo.List()
+	assert.NoError(t, err)
+	assert.Equal(t, "listed@example.com", suppressions[0].Email)
+	assert.Equal(t, "abuse", suppressions[0].Reason)
+
+	subscriptions, err := subscriptionRepo.GetSubscriptionsForUpdates("daily")
+	assert.NoError(t, err)
+	for _, subscription := range subscriptions {
+		assert.NotEqual(t, listed.ID, subscription.ID)
+	}
+
+	assert.NoError(t, repo.Remove("listed@example.com"))
+	assert.ErrorIs(t, repo.Remove("listed@example.com"), gorm.ErrRecordNotFound)
+
+	suppressed, err = repo.IsSuppressed("listed@example.com")
+	assert.NoError(t, err)
+	assert.False(t, suppressed)
+}
+
+// TestSubscriptionRepository_FindAllByEmail tests finding every subscription of an address
+func TestSubscriptionRepository_FindAllByEmail(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSubscriptionRepository(db)
+
+	subscriptions := []models.Subscription{
+		{Email: "digest@example.com", City: "London", Frequency: "daily"},
+		{Email: "Digest@Example.com", City: "Paris", Frequency: "hourly"},
+		{Email: "other-digest@example.com", City: "Rome", Frequency: "daily"},
+	}
+	assert.NoError(t, db.Create(&subscriptions).Error)
+
+	found, err := repo.FindAllByEmail("DIGEST@example.com")
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, "London", found[0].City)
+	assert.Equal(t, "Paris", found[1].City)
+}
+
+// TestSubscriptionRepository_PausedExcluded tests that paused subscriptions get no updates
+func TestSubscriptionRepository_PausedExcluded(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSubscriptionRepository(db)
+
+	paused := models.Subscription{Email: "paused@example.com", City: "Oslo", Frequency: "hourly", Confirmed: true, Paused: true}
+	assert.NoError(t, db.Create(&paused).Error)
+	running := models.Subscription{Email: "running@example.com", City: "Oslo", Frequency: "hourly", Confirmed: true}
+	assert.NoError(t, db.Create(&running).Error)
+
+	subscriptions, err := repo.GetSubscriptionsForUpdates("hourly")
+	assert.NoError(t, err)
+
+	var ids []uint
+	for _, subscription := range subscriptions {
+		ids = append(ids, subscription.ID)
+	}
+	assert.Contains(t, ids, running.ID)
+	assert.NotContains(t, ids, paused.ID)
+}
+
+// TestSubscriptionRepository_AutoResume tests that subscriptions resume once their pause has ended
+func TestSubscriptionRepository_AutoResume(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSubscriptionRepository(db)
+
+	ended := time.Now().Add(-time.Minute)
+	later := time.Now().Add(24 * time.Hour)
+	holiday := models.Subscription{Email: "holiday@example.com", City: "Lisbon", Frequency: "daily", Confirmed: true, Paused: true, PausedUntil: &ended}
+	assert.NoError(t, db.Create(&holiday).Error)
+	away := models.Subscription{Email: "away@example.com", City: "Lisbon", Frequency: "daily", Confirmed: true, Paused: true, PausedUntil: &later}
+	assert.NoError(t, db.Create(&away).Error)
+
+	subscriptions, err := repo.GetSubscriptionsForUpdates("daily")
+	assert.NoError(t, err)
+
+	var ids []uint
+	for _, subscription := range subscriptions {
+		ids = append(ids, subscription.ID)
+	}
+	assert.Contains(t, ids, holiday.ID)
+	assert.NotContains(t, ids, away.ID)
+
+	var stored models.Subscription
+	assert.NoError(t, db.First(&stored, holiday.ID).Error)
+	assert.False(t, stored.Paused)
+	assert.Nil(t, stored.PausedUntil)
+}
+
+// TestSubscriptionRepository_PurgeUnconfirmed tests purging old unconfirmed subscriptions
+func TestSubscriptionRepository_PurgeUnconfirmed(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSubscriptionRepository(db)
+	tokenRepo := NewTokenRepository(db)
+
+	old := time.Now().Add(-96 * time.Hour)
+	stale := models.Subscription{Email: "stale@example.com", City: "Madrid", Frequency: "daily", CreatedAt: old}
+	resent := models.Subscription{Email: "resent@example.com", City: "Madrid", Frequency: "daily", CreatedAt: old}
+	confirmed := models.Subscription{Email: "kept@example.com", City: "Madrid", Frequency: "daily", Confirmed: true, CreatedAt: old}
+	fresh := models.Subscription{Email: "fresh@example.com", City: "Madrid", Frequency: "daily"}
+	for _, subscription := range []*models.Subscription{&stale, &resent, &confirmed, &fresh} {
+		assert.NoError(t, db.Create(subscription).Error)
+	}
+
+	expired := models.Token{TokenHash: models.HashToken("stale-token"), SubscriptionID: stale.ID, Type: models.TokenTypeConfirmation, ExpiresAt: old.Add(24 * time.Hour)}
+	assert.NoError(t, db.Create(&expired).Error)
+	_, err := tokenRepo.CreateToken(resent.ID, models.TokenTypeConfirmation, 24*time.Hour)
+	assert.NoError(t, err)
+
+	purged, err := repo.PurgeUnconfirmed(time.Now().Add(-72 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	var count int64
+	db.Unscoped().Model(&models.Subscription{}).Where("id = ?", stale.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Unscoped().Model(&models.Token{}).Where("subscription_id = ?", stale.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	for _, kept := range []models.Subscription{resent, confirmed, fresh} {
+		_, err := repo.FindByID(kept.ID)
+		assert.NoError(t, err, kept.Email)
+	}
+}
+
+// TestSubscriptionRepository_PurgeDeleted tests that subscriptions deleted before the retention
+// window are purged with everything that refers to them
+func TestSubscriptionRepository_PurgeDeleted(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewSubscriptionRepository(db)
+
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	expired := models.Subscription{Email: "expired-delete@example.com", City: "Lisbon", Frequency: "daily", Confirmed: true}
+	recent := models.Subscription{Email: "recent-delete@example.com", City: "Lisbon", Frequency: "daily", Confirmed: true}
+	active := models.Subscription{Email: "active-delete@example.com", City: "Lisbon", Frequency: "daily", Confirmed: true}
+	for _, subscription := range []*models.Subscription{&expired, &recent, &active} {
+		assert.NoError(t, db.Create(subscription).Error)
+	}
+	assert.NoError(t, db.Create(&models.NotificationRule{SubscriptionID: expired.ID, Metric: "min_temp", Operator: "lt", Threshold: 0}).Error)
+	assert.NoError(t, db.Create(&models.SentAlert{SubscriptionID: expired.ID, AlertID: "purge-alert", SentAt: old}).Error)
+	assert.NoError(t, db.Create(&models.Token{TokenHash: models.HashToken("purge-expired"), SubscriptionID: expired.ID, Type: models.TokenTypeUnsubscribe, ExpiresAt: time.Now()}).Error)
+	usedToken := models.Token{TokenHash: models.HashToken("purge-used"), SubscriptionID: active.ID, Type: models.TokenTypeConfirmation, ExpiresAt: old}
+	assert.NoError(t, db.Create(&usedToken).Error)
+
+	assert.NoError(t, db.Model(&models.Subscription{}).Where("id = ?", expired.ID).Update("deleted_at", old).Error)
+	assert.NoError(t, db.Model(&models.Token{}).Where("id = ?", usedToken.ID).Update("deleted_at", old).Error)
+	assert.NoError(t, repo.Delete(&recent))
+
+	purged, err := repo.PurgeDeleted(time.Now().Add(-30 * 24 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	var count int64
+	db.Unscoped().Model(&models.Subscription{}).Where("id = ?", expired.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Model(&models.NotificationRule{}).Where("subscription_id = ?", expired.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Model(&models.SentAlert{}).Where("subscription_id = ?", expired.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Unscoped().Model(&models.Token{}).Where("subscription_id IN ?", []uint{expired.ID, active.ID}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	// Recently deleted subscriptions are kept until their retention window has passed
+	db.Unscoped().Model(&models.Subscription{}).Where("id = ?", recent.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+	_, err = repo.FindByID(active.ID)
+	assert.NoError(t, err)
+}
+
+// TestTokenRepository_FindLatest tests finding and replacing the tokens of a subscription
+func TestTokenRepository_FindLatest(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewTokenRepository(db)
+
+	subscription := models.Subscription{Email: "latest@example.com", City: "Vienna", Frequency: "daily"}
+	assert.NoError(t, db.Create(&subscription).Error)
+
+	latest, err := repo.FindLatest(subscription.ID, models.TokenTypeConfirmation)
+	assert.NoError(t, err)
+	assert.Nil(t, latest)
+
+	_, err = repo.CreateToken(subscription.ID, models.TokenTypeConfirmation, time.Hour)
+	assert.NoError(t, err)
+	second, err := repo.CreateToken(subscription.ID, models.TokenTypeConfirmation, time.Hour)
+	assert.NoError(t, err)
+
+	latest, err = repo.FindLatest(subscription.ID, models.TokenTypeConfirmation)
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, latest.ID)
+
+	assert.NoError(t, repo.DeleteBySubscription(subscription.ID, models.TokenTypeConfirmation))
+	latest, err = repo.FindLatest(subscription.ID, models.TokenTypeConfirmation)
+	assert.NoError(t, err)
+	assert.Nil(t, latest)
+}
+
+// TestTokenRepository_RevokeAll tests revoking every token of a subscription
+func TestTokenRepository_RevokeAll(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewTokenRepository(db)
+
+	subscription := models.Subscription{Email: "revoke@example.com", City: "Oslo", Frequency: "daily", Confirmed: true}
+	other := models.Subscription{Email: "revoke@example.com", City: "Bergen", Frequency: "daily", Confirmed: true}
+	assert.NoError(t, db.Create(&subscription).Error)
+	assert.NoError(t, db.Create(&other).Error)
+
+	confirmation, err := repo.CreateToken(subscription.ID, models.TokenTypeConfirmation, time.Hour)
+	assert.NoError(t, err)
+	_, err = repo.CreateToken(subscription.ID, models.TokenTypeUnsubscribe, time.Hour)
+	assert.NoError(t, err)
+	kept, err := repo.CreateToken(other.ID, models.TokenTypeUnsubscribe, time.Hour)
+	assert.NoError(t, err)
+
+	revoked, err := repo.RevokeAll(subscription.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), revoked)
+
+	_, err = repo.FindByToken(confirmation.Plaintext)
+	assert.Error(t, err)
+	_, err = repo.FindByToken(kept.Plaintext)
+	assert.NoError(t, err)
+
+	var stored models.Subscription
+	assert.NoError(t, db.First(&stored, subscription.ID).Error)
+	assert.Equal(t, 1, stored.TokenVersion)
+	var untouched models.Subscription
+	assert.NoError(t, db.First(&untouched, other.ID).Error)
+	assert.Equal(t, 0, untouched.TokenVersion)
+
+	_, err = repo.RevokeAll(999999)
+	assert.EqualError(t, err, "record not found")
+}
+
+// TestConsentRepository_List tests listing the consent events of an address
+func TestConsentRepository_List(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewConsentRepository(db)
+
+	for _, event := range []models.ConsentEvent{
+		{Email: "consent@example.com", SubscriptionID: 1, City: "Lisbon", Event: models.ConsentEventSubscribe},
+		{Email: "other-consent@example.com", SubscriptionID: 2, City: "Porto", Event: models.ConsentEventSubscribe},
+		{Email: "consent@example.com", SubscriptionID: 1, City: "Lisbon", Event: models.ConsentEventConfirm},
+	} {
+		assert.NoError(t, db.Create(&event).Error)
+	}
+
+	events, err := repo.List(" Consent@Example.com ")
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, models.ConsentEventSubscribe, events[0].Event)
+		assert.Equal(t, models.ConsentEventConfirm, events[1].Event)
+	}
+
+	all, err := repo.List("")
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(all), 3)
+}
+
+// TestPrivacyRepository_ExportAndErase tests exporting and then erasing the data of an address
+func TestPrivacyRepository_ExportAndErase(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPrivacyRepository(db)
+
+	active := models.Subscription{Email: "Privacy@example.com", City: "Oslo", Frequency: "daily", Confirmed: true}
+	deleted := models.Subscription{Email: "privacy@example.com", City: "Bergen", Frequency: "hourly", Confirmed: true}
+	other := models.Subscription{Email: "bystander@example.com", City: "Oslo", Frequency: "daily", Confirmed: true}
+	for _, subscription := range []*models.Subscription{&active, &deleted, &other} {
+		assert.NoError(t, db.Create(subscription).Error)
+	}
+	assert.NoError(t, db.Delete(&deleted).Error)
+	assert.NoError(t, db.Create(&models.NotificationRule{SubscriptionID: active.ID, Metric: "max_wind", Operator: "gt", Threshold: 50}).Error)
+	assert.NoError(t, db.Create(&models.SentAlert{SubscriptionID: active.ID, AlertID: "privacy-alert", SentAt: time.Now()}).Error)
+	assert.NoError(t, db.Create(&models.Token{TokenHash: models.HashToken("privacy-token"), SubscriptionID: active.ID, Type: models.TokenTypeUnsubscribe, ExpiresAt: time.Now().Add(time.Hour)}).Error)
+	assert.NoError(t, db.Create(&models.ConsentEvent{Email: "privacy@example.com", SubscriptionID: active.ID, City: "Oslo", Event: models.ConsentEventConfirm, IP: "192.0.2.1", UserAgent: "browser"}).Error)
+	assert.NoError(t, db.Create(&models.ConsentEvent{Email: "bystander@example.com", SubscriptionID: other.ID, City: "Oslo", Event: models.ConsentEventConfirm, IP: "192.0.2.2"}).Error)
+	assert.NoError(t, db.Create(&models.Suppression{Email: "privacy@example.com", Reason: "complaint", Source: models.BounceTypeComplaint}).Error)
+	assert.NoError(t, db.Create(&models.SentLink{Email: "privacy@example.com", Purpose: "erase", SentAt: time.Now()}).Error)
+
+	data, err := repo.Export("PRIVACY@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "privacy@example.com", data.Email)
+	assert.True(t, data.HasData())
+	if assert.Len(t, data.Subscriptions, 2) {
+		assert.Equal(t, "Oslo", data.Subscriptions[0].City)
+		assert.Nil(t, data.Subscriptions[0].DeletedAt)
+		assert.Len(t, data.Subscriptions[0].Rules, 1)
+		assert.Equal(t, "Bergen", data.Subscriptions[1].City)
+		assert.NotNil(t, data.Subscriptions[1].DeletedAt)
+	}
+	if assert.Len(t, data.SentAlerts, 1) {
+		assert.Equal(t, "privacy-alert", data.SentAlerts[0].AlertID)
+	}
+	assert.Len(t, data.ConsentEvents, 1)
+	if assert.NotNil(t, data.Suppression) {
+		assert.Equal(t, "complaint", data.Suppression.Reason)
+	}
+
+	result, err := repo.Erase("privacy@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.Subscriptions)
+	assert.Equal(t, int64(1), result.ConsentEvents)
+
+	var count int64
+	db.Unscoped().Model(&models.Subscription{}).Where("id IN ?", []uint{active.ID, deleted.ID}).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Unscoped().Model(&models.Token{}).Where("subscription_id = ?", active.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Model(&models.NotificationRule{}).Where("subscription_id = ?", active.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Model(&models.SentAlert{}).Where("subscription_id = ?", active.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+	db.Model(&models.SentLink{}).Where("email = ?", "privacy@example.com").Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	var events []models.ConsentEvent
+	assert.NoError(t, db.Where("subscription_id = ?", active.ID).Find(&events).Error)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, models.ErasedEmail("privacy@example.com"), events[0].Email)
+		assert.Empty(t, events[0].IP)
+		assert.Empty(t, events[0].UserAgent)
+		assert.Equal(t, models.ConsentEventConfirm, events[0].Event)
+	}
+
+	// Only the suppression entry is left, and other addresses are untouched
+	data, err = repo.Export("privacy@example.com")
+	assert.NoError(t, err)
+	assert.False(t, data.HasData())
+	assert.NotNil(t, data.Suppression)
+	_, err = NewSubscriptionRepository(db).FindByID(other.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Where("email = ?", "bystander@example.com").Find(&events).Error)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "192.0.2.2", events[0].IP)
+	}
+}
@@ -15,6 +15,8 @@ type Scheduler struct {
 	config              *config.Config
 	subscriptionRepo    *repository.SubscriptionRepository
 	tokenRepo           *repository.TokenRepository
+	alertRepo           *repository.AlertRepository
+	linkRepo            *repository.LinkRepository
 	weatherService      *service.WeatherService
 	emailService        *service.EmailService
 	subscriptionService *service.SubscriptionService
@@ -26,11 +28,23 @@ func NewScheduler(db *gorm.DB, config *config.Config) *Scheduler {
 	
 	subscriptionRepo := repository.NewSubscriptionRepository(db)
 	tokenRepo := repository.NewTokenRepository(db)
+	alertRepo := repository.NewAlertRepository(db)
+	linkRepo := repository.NewLinkRepository(db)
+	ruleRepo := repository.NewRuleRepository(db)
+	suppressionRepo := repository.NewSuppressionRepository(db)
+	consentRepo := repository.NewConsentRepository(db)
+	privacyRepo := repository.NewPrivacyRepository(db)
 	
 	subscriptionService := service.NewSubscriptionService(
 		db,
 		subscriptionRepo,
 		tokenRepo,
+		alertRepo,
+		linkRepo,
+		ruleRepo,
+		suppressionRepo,
+		consentRepo,
+		privacyRepo,
 		emailService,
 		weatherService,
 		config,
@@ -41,6 +55,8 @@ func NewScheduler(db *gorm.DB, config *config.Config) *Scheduler {
 		config:              config,
 		subscriptionRepo:    subscriptionRepo,
 		tokenRepo:           tokenRepo,
+		alertRepo:           alertRepo,
+		linkRepo:            linkRepo,
 		weatherService:      weatherService,
 		emailService:        emailService,
 		subscriptionService: subscriptionService,
@@ -49,6 +65,14 @@ func NewScheduler(db *gorm.DB, config *config.Config) *Scheduler {
 
 func (s *Scheduler) Start() {
 	go s.scheduleDaily(24*time.Hour, s.cleanupExpiredTokens)
+	go s.scheduleDaily(24*time.Hour, s.cleanupSentAlerts)
+	go s.scheduleDaily(24*time.Hour, s.cleanupSentLinks)
+	if s.config.Scheduler.UnconfirmedRetention > 0 {
+		go s.scheduleDaily(time.Hour, s.purgeUnconfirmedSubscriptions)
+	}
+	if s.config.Scheduler.DeletedRetention > 0 {
+		go s.scheduleDaily(24*time.Hour, s.purgeDeletedSubscriptions)
+	}
 	
 	go s.scheduleInterval(time.Duration(s.config.Scheduler.HourlyInterval)*time.Minute, func() {
 		if err := s.subscriptionService.SendWeatherUpdate("hourly"); err != nil {
@@ -61,6 +85,31 @@ func (s *Scheduler) Start() {
 			fmt.Printf("Error sending daily weather updates: %v\n", err)
 		}
 	})
+	
+	go s.scheduleInterval(time.Duration(s.config.Scheduler.AlertInterval)*time.Minute, func() {
+		if err := s.subscriptionService.CheckWeatherAlerts(); err != nil {
+			fmt.Printf("Error checking weather alerts: %v\n", err)
+		}
+	})
+	
+	go s.scheduleInterval(time.Duration(s.config.Scheduler.RuleInterval)*time.Minute, func() {
+		if err := s.subscriptionService.EvaluateNotificationRules(); err != nil {
+			fmt.Printf("Error evaluating notification rules: %v\n", err)
+		}
+	})
+	
+	if s.config.Email.BounceMaildir != "" {
+		go s.scheduleInterval(time.Duration(s.config.Scheduler.BounceInterval)*time.Minute, func() {
+			if err := s.subscriptionService.ProcessBounceMaildir(s.config.Email.BounceMaildir); err != nil {
+				fmt.Printf("Error processing bounce maildir: %v\n", err)
+			}
+		})
+	}
+}
+
+// Close closes the connections of the email transport; a job still running reconnects
+func (s *Scheduler) Close() error {
+	return s.emailService.Close()
 }
 
 func (s *Scheduler) scheduleInterval(interval time.Duration, job func()) {
@@ -85,4 +134,38 @@ func (s *Scheduler) cleanupExpiredTokens() {
 	if err := s.tokenRepo.DeleteExpiredTokens(); err != nil {
 		fmt.Printf("Error cleaning up expired tokens: %v\n", err)
 	}
-}
\ No newline at end of file
+}
+
+// sentAlertRetention is how long sent alerts are remembered for de-duplication
+const sentAlertRetention = 30 * 24 * time.Hour
+
+func (s *Scheduler) cleanupSentAlerts() {
+	if err := s.alertRepo.DeleteSentBefore(time.Now().Add(-sentAlertRetention)); err != nil {
+		fmt.Printf("Error cleaning up sent alerts: %v\n", err)
+	}
+}
+
+// cleanupSentLinks forgets links sent before the cooldown, as they no longer hold back another
+func (s *Scheduler) cleanupSentLinks() {
+	cooldown := time.Duration(s.config.ResendCooldown) * time.Minute
+	if err := s.linkRepo.DeleteSentBefore(time.Now().Add(-cooldown)); err != nil {
+		fmt.Printf("Error cleaning up sent links: %v\n", err)
+	}
+}
+
+// purgeUnconfirmedSubscriptions deletes subscriptions that were not confirmed within the retention window
+func (s *Scheduler) purgeUnconfirmedSubscriptions() {
+	retention := time.Duration(s.config.Scheduler.UnconfirmedRetention) * time.Hour
+	if _, err := s.subscriptionRepo.PurgeUnconfirmed(time.Now().Add(-retention)); err != nil {
+		fmt.Printf("Error purging unconfirmed subscriptions: %v\n", err)
+	}
+}
+
+// purgeDeletedSubscriptions permanently deletes subscriptions that were deleted longer ago
+// than the retention window, so unsubscribed addresses are not kept forever
+func (s *Scheduler) purgeDeletedSubscriptions() {
+	retention := time.Duration(s.config.Scheduler.DeletedRetention) * 24 * time.Hour
+	if _, err := s.subscriptionRepo.PurgeDeleted(time.Now().Add(-retention)); err != nil {
+		fmt.Printf("Error purging deleted subscriptions: %v\n", err)
+	}
+}
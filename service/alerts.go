@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"weatherapi.app/models"
+)
+
+// GetAlerts returns the severe weather alerts currently issued for the location
+func (s *WeatherService) GetAlerts(location models.LocationQuery, options models.WeatherOptions) ([]models.WeatherAlert, error) {
+	fmt.Printf("[DEBUG] WeatherService.GetAlerts called for %s location: %s\n", location.Type, location.Query())
+
+	if err := location.Validate(); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("key", s.config.Weather.APIKey)
+	params.Set("q", location.Query())
+	params.Set("days", "1")
+	params.Set("aqi", "no")
+	params.Set("alerts", "yes")
+	if options.Language != "" && options.Language != models.DefaultLanguage {
+		params.Set("lang", options.Language)
+	}
+	requestURL := fmt.Sprintf("%s/forecast.json?%s", s.config.Weather.BaseURL, params.Encode())
+
+	resp, err := s.client.Get(requestURL)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to get weather alerts: %v\n", err)
+		return nil, fmt.Errorf("failed to get weather alerts: %w", err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("[DEBUG] Weather API alerts response status: %d\n", resp.StatusCode)
+
+	if err := checkWeatherAPIStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var result weatherAPIAlertsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fmt.Printf("[ERROR] Failed to decode weather alerts: %v\n", err)
+		return nil, fmt.Errorf("failed to decode weather alerts: %w", err)
+	}
+
+	alerts := []models.WeatherAlert{}
+	if result.Alerts != nil {
+		for _, alert := range result.Alerts.Alert {
+			alerts = append(alerts, alert.toWeatherAlert())
+		}
+	}
+
+	fmt.Printf("[DEBUG] Found %d weather alerts for: %s\n", len(alerts), location.Query())
+	return alerts, nil
+}
+
+// CheckWeatherAlerts emails alert subscribers about alerts for their location that they
+// have not been sent yet. Locations shared by several subscribers are only looked up once.
+func (s *SubscriptionService) CheckWeatherAlerts() error {
+	fmt.Println("[DEBUG] CheckWeatherAlerts called")
+
+	subscriptions, err := s.subscriptionRepo.GetSubscriptionsForUpdates(models.FrequencyAlerts)
+	if err != nil {
+		fmt.Printf("[ERROR] Error getting alert subscriptions: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("[DEBUG] Found %d alert subscriptions\n", len(subscriptions))
+
+	alertsByLocation := make(map[string][]models.WeatherAlert)
+
+	for _, subscription := range subscriptions {
+		location := subscription.LocationQuery()
+		options := subscription.WeatherOptions()
+		cacheKey := location.Query() + "|" + options.Language
+
+		alerts, ok := alertsByLocation[cacheKey]
+		if !ok {
+			alerts, err = s.weatherService.GetAlerts(location, options)
+			if err != nil {
+				fmt.Printf("[ERROR] Error getting alerts for %s: %v\n", subscription.City, err)
+				continue
+			}
+			alertsByLocation[cacheKey] = alerts
+		}
+
+		for i := range alerts {
+			alert := &alerts[i]
+
+			sent, err := s.alertRepo.HasBeenSent(subscription.ID, alert.ID)
+			if err != nil {
+				fmt.Printf("[ERROR] Error checking sent alert %s for subscription %d: %v\n", alert.ID, subscription.ID, err)
+				continue
+			}
+			if sent {
+				continue
+			}
+
+			unsubscribeURL, err := s.unsubscribeURL(&subscription)
+			if err != nil {
+				continue
+			}
+
+			if err := s.emailService.SendWeatherAlertEmail(subscription.Email, subscription.City, alert, unsubscribeURL); err != nil {
+				// Not marked as sent, so it is retried on the next check
+				fmt.Printf("[WARNING] Error sending weather alert email, will retry: %v\n", err)
+				continue
+			}
+
+			if err := s.alertRepo.MarkSent(subscription.ID, alert.ID); err != nil {
+				fmt.Printf("[ERROR] Error recording sent alert %s for subscription %d: %v\n", alert.ID, subscription.ID, err)
+				continue
+			}
+
+			fmt.Printf("[DEBUG] Sent weather alert %s to: %s\n", alert.ID, subscription.Email)
+		}
+	}
+
+	fmt.Println("[DEBUG] CheckWeatherAlerts completed")
+	return nil
+}
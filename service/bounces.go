@@ -0,0 +1,254 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"weatherapi.app/models"
+)
+
+// Bounce webhook providers
+const (
+	BounceProviderSES      = "ses"
+	BounceProviderSendGrid = "sendgrid"
+)
+
+// snsHTTPClient confirms SNS topic subscriptions
+var snsHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// snsHostPattern matches the SNS endpoints subscription confirmations may point to
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// snsEnvelope is the SNS HTTP notification wrapping SES events
+type snsEnvelope struct {
+	Type         string `json:"Type"`
+	Message      string `json:"Message"`
+	SubscribeURL string `json:"SubscribeURL"`
+}
+
+// sesNotification is an SES bounce or complaint event
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Bounce           struct {
+		BounceType        string `json:"bounceType"`
+		BounceSubType     string `json:"bounceSubType"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+// sendGridEvent is one entry of a SendGrid event webhook batch
+type sendGridEvent struct {
+	Email  string `json:"email"`
+	Event  string `json:"event"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// parseSESNotification extracts bounce events from an SNS delivered SES notification.
+// Transient bounces are ignored. A subscription confirmation returns its SubscribeURL instead.
+func parseSESNotification(body []byte) ([]models.BounceEvent, string, error) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, "", fmt.Errorf("invalid notification")
+	}
+
+	switch envelope.Type {
+	case "SubscriptionConfirmation":
+		return nil, envelope.SubscribeURL, nil
+	case "Notification":
+	default:
+		return nil, "", fmt.Errorf("invalid notification")
+	}
+
+	var notification sesNotification
+	if err := json.Unmarshal([]byte(envelope.Message), &notification); err != nil {
+		return nil, "", fmt.Errorf("invalid notification")
+	}
+
+	notificationType := notification.NotificationType
+	if notificationType == "" {
+		notificationType = notification.EventType
+	}
+
+	var events []models.BounceEvent
+	switch notificationType {
+	case "Bounce":
+		if notification.Bounce.BounceType != "Permanent" {
+			return nil, "", nil
+		}
+		for _, recipient := range notification.Bounce.BouncedRecipients {
+			reason := recipient.DiagnosticCode
+			if reason == "" {
+				reason = notification.Bounce.BounceSubType
+			}
+			events = append(events, models.BounceEvent{Email: recipient.EmailAddress, Type: models.BounceTypeBounce, Reason: reason})
+		}
+	case "Complaint":
+		for _, recipient := range notification.Complaint.ComplainedRecipients {
+			events = append(events, models.BounceEvent{
+				Email:  recipient.EmailAddress,
+				Type:   models.BounceTypeComplaint,
+				Reason: notification.Complaint.ComplaintFeedbackType,
+			})
+		}
+	}
+
+	return events, "", nil
+}
+
+// parseSendGridEvents extracts bounce events from a SendGrid event webhook batch.
+// Blocked messages are temporary failures and are ignored.
+func parseSendGridEvents(body []byte) ([]models.BounceEvent, error) {
+	var batch []sendGridEvent
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, fmt.Errorf("invalid notification")
+	}
+
+	var events []models.BounceEvent
+	for _, event := range batch {
+		switch {
+		case event.Event == "bounce" && event.Type != "blocked":
+			events = append(events, models.BounceEvent{Email: event.Email, Type: models.BounceTypeBounce, Reason: event.Reason})
+		case event.Event == "spamreport":
+			events = append(events, models.BounceEvent{Email: event.Email, Type: models.BounceTypeComplaint, Reason: "spamreport"})
+		}
+	}
+	return events, nil
+}
+
+// confirmSNSSubscription visits the SubscribeURL of an SNS subscription confirmation
+func confirmSNSSubscription(subscribeURL string) error {
+	parsed, err := url.Parse(subscribeURL)
+	if err != nil || parsed.Scheme != "https" || !snsHostPattern.MatchString(parsed.Hostname()) {
+		return fmt.Errorf("invalid notification")
+	}
+
+	resp, err := snsHTTPClient.Get(subscribeURL)
+	if err != nil {
+		return fmt.Errorf("failed to confirm SNS subscription: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to confirm SNS subscription: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ProcessBounceWebhook suppresses the recipients of a provider bounce or complaint notification
+func (s *SubscriptionService) ProcessBounceWebhook(provider string, body []byte) error {
+	fmt.Printf("[DEBUG] ProcessBounceWebhook called for provider: %s\n", provider)
+
+	var events []models.BounceEvent
+	var err error
+	switch provider {
+	case BounceProviderSES:
+		var subscribeURL string
+		events, subscribeURL, err = parseSESNotification(body)
+		if err == nil && subscribeURL != "" {
+			fmt.Println("[DEBUG] Confirming SNS subscription")
+			return confirmSNSSubscription(subscribeURL)
+		}
+	case BounceProviderSendGrid:
+		events, err = parseSendGridEvents(body)
+	default:
+		return fmt.Errorf("unsupported provider")
+	}
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to parse %s notification: %v\n", provider, err)
+		return err
+	}
+
+	return s.suppressRecipients(events)
+}
+
+// ProcessBounceMaildir parses the delivery status notifications in the new directory of a
+// Maildir, suppresses their failed recipients and moves each processed message to cur
+func (s *SubscriptionService) ProcessBounceMaildir(dir string) error {
+	fmt.Printf("[DEBUG] ProcessBounceMaildir called for: %s\n", dir)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to read bounce maildir: %v\n", err)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "cur"), 0755); err != nil {
+		fmt.Printf("[ERROR] Failed to create bounce maildir: %v\n", err)
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, "new", entry.Name())
+
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to open bounce message %s: %v\n", entry.Name(), err)
+			continue
+		}
+		events, err := parseDSN(file)
+		file.Close()
+		if err != nil {
+			// Not every message in a bounce mailbox is a DSN; leave it for a human
+			fmt.Printf("[WARNING] Skipping message %s: %v\n", entry.Name(), err)
+		} else if err := s.suppressRecipients(events); err != nil {
+			// Keep the message in new so it is retried on the next run
+			continue
+		}
+
+		seen := filepath.Join(dir, "cur", entry.Name()+":2,S")
+		if err := os.Rename(path, seen); err != nil {
+			fmt.Printf("[ERROR] Failed to move processed bounce message %s: %v\n", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// suppressRecipients adds every bounced or complaining recipient to the suppression list and
+// marks their subscriptions as suppressed
+func (s *SubscriptionService) suppressRecipients(events []models.BounceEvent) error {
+	for _, event := range events {
+		email := strings.TrimSpace(event.Email)
+		if email == "" {
+			continue
+		}
+
+		reason := event.Type
+		if event.Reason != "" {
+			reason = fmt.Sprintf("%s: %s", event.Type, event.Reason)
+		}
+
+		if _, err := s.suppressionRepo.Add(&models.Suppression{Email: email, Reason: event.Reason, Source: event.Type}); err != nil {
+			fmt.Printf("[ERROR] Failed to add %s to the suppression list: %v\n", email, err)
+			return err
+		}
+
+		suppressed, err := s.subscriptionRepo.SuppressByEmail(email, reason)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to suppress %s: %v\n", email, err)
+			return err
+		}
+		fmt.Printf("[DEBUG] Suppressed %d subscriptions of %s after %s\n", suppressed, email, event.Type)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"weatherapi.app/models"
+)
+
+// recordConsent adds a consent event for a subscription inside the transaction that changes
+// it, so a subscription never changes without its audit trail
+func recordConsent(tx *gorm.DB, subscription *models.Subscription, event string, meta models.RequestMeta) error {
+	consent := &models.ConsentEvent{
+		Email:          strings.ToLower(strings.TrimSpace(subscription.Email)),
+		SubscriptionID: subscription.ID,
+		City:           subscription.City,
+		Event:          event,
+		IP:             meta.IP,
+		UserAgent:      meta.UserAgent,
+	}
+	if err := tx.Create(consent).Error; err != nil {
+		fmt.Printf("[ERROR] Error recording %s consent event for subscription %d: %v\n", event, subscription.ID, err)
+		return err
+	}
+	return nil
+}
+
+// ListConsentEvents returns the consent audit trail of an address, or of every address when
+// email is empty
+func (s *SubscriptionService) ListConsentEvents(email string) ([]models.ConsentEvent, error) {
+	return s.consentRepo.List(email)
+}
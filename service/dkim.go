@@ -0,0 +1,145 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-msgauth/dkim"
+	"weatherapi.app/config"
+)
+
+// dkimHeaderKeys are the headers covered by the DKIM signature. Headers missing from a message
+// are signed as absent, so they cannot be added in transit without breaking the signature.
+var dkimHeaderKeys = []string{
+	"From",
+	"To",
+	"Subject",
+	"Date",
+	"Message-Id",
+	"Mime-Version",
+	"Content-Type",
+	"List-Unsubscribe",
+	"List-Unsubscribe-Post",
+}
+
+// dkimSigner adds a DKIM-Signature header to outgoing messages
+type dkimSigner struct {
+	domain   string
+	selector string
+	key      crypto.Signer
+}
+
+// newDKIMSigner creates a signer from the DKIM settings, or returns nil when signing is not configured
+func newDKIMSigner(cfg config.EmailConfig) (*dkimSigner, error) {
+	if cfg.DKIMSelector == "" && cfg.DKIMPrivateKey == "" && cfg.DKIMPrivateKeyFile == "" {
+		return nil, nil
+	}
+	if cfg.DKIMSelector == "" {
+		return nil, fmt.Errorf("EMAIL_DKIM_SELECTOR is required for DKIM signing")
+	}
+
+	keyPEM := []byte(cfg.DKIMPrivateKey)
+	if cfg.DKIMPrivateKeyFile != "" {
+		var err error
+		keyPEM, err = os.ReadFile(cfg.DKIMPrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DKIM private key: %w", err)
+		}
+	}
+	if len(keyPEM) == 0 {
+		return nil, fmt.Errorf("EMAIL_DKIM_PRIVATE_KEY or EMAIL_DKIM_PRIVATE_KEY_FILE is required for DKIM signing")
+	}
+
+	key, err := parseDKIMPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	domain := cfg.DKIMDomain
+	if domain == "" {
+		domain = addressDomain(cfg.FromAddress)
+	}
+	if domain == "" {
+		return nil, fmt.Errorf("EMAIL_DKIM_DOMAIN is required for DKIM signing")
+	}
+
+	return &dkimSigner{domain: domain, selector: cfg.DKIMSelector, key: key}, nil
+}
+
+// parseDKIMPrivateKey parses a PEM encoded PKCS#1 RSA or PKCS#8 RSA/Ed25519 private key
+func parseDKIMPrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	// Keys passed through environment variables often have escaped newlines
+	keyPEM = bytes.ReplaceAll(keyPEM, []byte(`\n`), []byte("\n"))
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("invalid DKIM private key: no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DKIM private key: %w", err)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DKIM private key: %w", err)
+		}
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			return key, nil
+		case ed25519.PrivateKey:
+			return key, nil
+		}
+		return nil, fmt.Errorf("invalid DKIM private key: unsupported key type %T", key)
+	}
+
+	return nil, fmt.Errorf("invalid DKIM private key: unsupported PEM block %q", block.Type)
+}
+
+// sign returns message with a DKIM-Signature header prepended
+func (s *dkimSigner) sign(message []byte) ([]byte, error) {
+	var signed bytes.Buffer
+	err := dkim.Sign(&signed, bytes.NewReader(message), &dkim.SignOptions{
+		Domain:                 s.domain,
+		Selector:               s.selector,
+		Signer:                 s.key,
+		HeaderKeys:             dkimHeaderKeys,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to DKIM sign message: %w", err)
+	}
+	return signed.Bytes(), nil
+}
+
+// addressDomain returns the domain part of an email address
+func addressDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(address[at+1:])
+}
+
+// ValidateEmailConfig checks the email templates and, when configured, the DKIM signing key
+func ValidateEmailConfig(cfg *config.Config) error {
+	if err := ValidateEmailTemplates(cfg); err != nil {
+		return err
+	}
+	if _, err := newDKIMSigner(cfg.Email); err != nil {
+		return err
+	}
+	return nil
+}
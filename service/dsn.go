@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"weatherapi.app/models"
+)
+
+// maxDSNSize bounds how much of a bounce message is read
+const maxDSNSize = 10 << 20
+
+// parseDSN extracts permanently failed recipients from an RFC 3464 delivery status notification
+func parseDSN(r io.Reader) ([]models.BounceEvent, error) {
+	message, err := mail.ReadMessage(io.LimitReader(r, maxDSNSize))
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+
+	status, err := findDeliveryStatus(message.Header.Get("Content-Type"), message.Body)
+	if err != nil {
+		return nil, err
+	}
+	if status == nil {
+		return nil, fmt.Errorf("not a delivery status notification")
+	}
+
+	return parseDeliveryStatus(status)
+}
+
+// findDeliveryStatus walks a MIME body and returns the content of its message/delivery-status part
+func findDeliveryStatus(contentType string, body io.Reader) ([]byte, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil
+	}
+
+	switch {
+	case mediaType == "message/delivery-status" || mediaType == "message/global-delivery-status":
+		return io.ReadAll(body)
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid MIME message: %w", err)
+			}
+
+			var partBody io.Reader = part
+			if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+				partBody = base64.NewDecoder(base64.StdEncoding, part)
+			}
+
+			status, err := findDeliveryStatus(part.Header.Get("Content-Type"), partBody)
+			if err != nil || status != nil {
+				return status, err
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// parseDeliveryStatus reads the per-recipient fields of a delivery-status body. The first
+// block holds per-message fields and is skipped.
+func parseDeliveryStatus(status []byte) ([]models.BounceEvent, error) {
+	normalized := bytes.ReplaceAll(status, []byte("\r\n"), []byte("\n"))
+	blocks := strings.Split(strings.TrimSpace(string(normalized)), "\n\n")
+	if len(blocks) < 2 {
+		return nil, fmt.Errorf("delivery status has no recipients")
+	}
+
+	var events []models.BounceEvent
+	for _, block := range blocks[1:] {
+		reader := textproto.NewReader(bufio.NewReader(strings.NewReader(strings.TrimSpace(block) + "\n\n")))
+		fields, err := reader.ReadMIMEHeader()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("invalid delivery status: %w", err)
+		}
+
+		if !strings.EqualFold(strings.TrimSpace(fields.Get("Action")), "failed") {
+			continue
+		}
+		statusCode := strings.TrimSpace(fields.Get("Status"))
+		if !strings.HasPrefix(statusCode, "5") {
+			continue
+		}
+
+		recipient := dsnAddress(fields.Get("Final-Recipient"))
+		if recipient == "" {
+			recipient = dsnAddress(fields.Get("Original-Recipient"))
+		}
+		if recipient == "" {
+			continue
+		}
+
+		reason := dsnAddress(fields.Get("Diagnostic-Code"))
+		if reason == "" {
+			reason = statusCode
+		}
+		events = append(events, models.BounceEvent{Email: recipient, Type: models.BounceTypeBounce, Reason: reason})
+	}
+
+	return events, nil
+}
+
+// dsnAddress strips the type prefix of a DSN field such as "rfc822; user@example.com"
+func dsnAddress(value string) string {
+	if _, address, ok := strings.Cut(value, ";"); ok {
+		value = address
+	}
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
@@ -1,62 +1,203 @@
 package service
 
 import (
+	"bytes"
 	"fmt"
-	"net/smtp"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"weatherapi.app/config"
 	"weatherapi.app/models"
 )
 
 type EmailService struct {
-	config *config.Config
+	config    *config.Config
+	templates *emailTemplates
+	transport EmailTransport
+	dkim      *dkimSigner
+	now       func() time.Time
 }
 
 func NewEmailService(config *config.Config) *EmailService {
-	return &EmailService{
-		config: config,
+	transport, err := NewEmailTransport(config)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to create email transport, falling back to SMTP: %v\n", err)
+		transport = newSMTPTransport(config.Email)
 	}
+
+	return NewEmailServiceWithTransport(config, transport)
 }
 
-// sendEmail sends an email using Gmail SMTP server
-func (s *EmailService) sendEmail(to, subject, body string, isHtml bool) error {
-	fmt.Printf("[DEBUG] EmailService.sendEmail called with: to=%s, subject=%s\n", to, subject)
+// Close releases the connections of the transport, such as a pooled SMTP connection
+func (s *EmailService) Close() error {
+	return s.transport.Close()
+}
 
-	// SMTP server configuration
-	smtpHost := s.config.Email.SMTPHost
-	smtpPort := s.config.Email.SMTPPort
-	smtpUsername := s.config.Email.SMTPUsername
-	smtpPassword := s.config.Email.SMTPPassword
-	fromName := s.config.Email.FromName
-	fromAddress := s.config.Email.FromAddress
+// NewEmailServiceWithTransport creates an email service that delivers through transport
+func NewEmailServiceWithTransport(config *config.Config, transport EmailTransport) *EmailService {
+	templates, err := loadEmailTemplates(config.Email.TemplateDir)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to load email templates, using the built-in ones: %v\n", err)
+		templates = defaultEmailTemplates
+	}
 
-	// Set up authentication information
-	auth := smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
+	dkim, err := newDKIMSigner(config.Email)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to load DKIM key, sending unsigned emails: %v\n", err)
+	}
+
+	return &EmailService{
+		config:    config,
+		templates: templates,
+		transport: transport,
+		dkim:      dkim,
+		now:       time.Now,
+	}
+}
 
-	// Set up email headers
-	mimeHeaders := "MIME-Version: 1.0\r\n"
-	contentType := "Content-Type: text/plain; charset=UTF-8\r\n"
-	if isHtml {
-		contentType = "Content-Type: text/html; charset=UTF-8\r\n"
+// layout returns the shared layout fields of an email
+func (s *EmailService) layout(subject, unsubscribeURL string) emailLayout {
+	return emailLayout{
+		Brand:          brandFromConfig(s.config),
+		Subject:        subject,
+		UnsubscribeURL: unsubscribeURL,
 	}
+}
 
+// composeMessage builds a multipart/alternative message with a plain-text and an HTML part.
+// A random MIME boundary is used when boundary is empty.
+func (s *EmailService) composeMessage(to, subject string, headers map[string]string, email *renderedEmail, boundary string) ([]byte, error) {
 	subject = strings.ReplaceAll(subject, "\r\n", "")
 	subject = strings.ReplaceAll(subject, "\n", "")
-	
-	from := fmt.Sprintf("%s <%s>", fromName, fromAddress)
-	headers := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n%s%s\r\n", 
-		from, to, subject, mimeHeaders, contentType)
 
-	// Combine headers and message body
-	message := headers + body
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if boundary != "" {
+		if err := writer.SetBoundary(boundary); err != nil {
+			return nil, fmt.Errorf("invalid MIME boundary: %w", err)
+		}
+	}
 
-	// Connect to the SMTP server and send email
-	smtpAddr := fmt.Sprintf("%s:%d", smtpHost, smtpPort)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", email.Text},
+		{"text/html; charset=UTF-8", email.HTML},
+	}
+	for _, part := range parts {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create MIME part: %w", err)
+		}
+		qp := quotedprintable.NewWriter(partWriter)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("failed to encode MIME part: %w", err)
+		}
+		if err := qp.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode MIME part: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close MIME message: %w", err)
+	}
+
+	from := mail.Address{Name: s.config.Email.FromName, Address: s.config.Email.FromAddress}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from.String())
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", s.now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: %s\r\n", s.messageID())
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(headers[name])
+		fmt.Fprintf(&message, "%s: %s\r\n", textproto.CanonicalMIMEHeaderKey(name), value)
+	}
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=\"%s\"\r\n", writer.Boundary())
+	fmt.Fprintf(&message, "\r\n")
+	message.Write(body.Bytes())
 
-	fmt.Printf("[DEBUG] Sending email via SMTP: server=%s, from=%s, to=%s\n", smtpAddr, fromAddress, to)
-	err := smtp.SendMail(smtpAddr, auth, fromAddress, []string{to}, []byte(message))
+	return message.Bytes(), nil
+}
+
+// messageID returns a globally unique Message-ID in the sender's domain
+func (s *EmailService) messageID() string {
+	domain := addressDomain(s.config.Email.FromAddress)
+	if domain == "" {
+		domain = "localhost"
+	}
+	return fmt.Sprintf("<%s@%s>", uuid.NewString(), domain)
+}
+
+// listUnsubscribeHeaders returns the RFC 2369 and RFC 8058 one-click unsubscribe headers
+// for an email with an unsubscribe link, which mailbox providers POST to directly
+func listUnsubscribeHeaders(unsubscribeURL string) map[string]string {
+	if unsubscribeURL == "" {
+		return nil
+	}
+	return map[string]string{
+		"List-Unsubscribe":      fmt.Sprintf("<%s>", unsubscribeURL),
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// sendTemplate renders the named email template and sends it
+func (s *EmailService) sendTemplate(to, subject, name string, data emailData) error {
+	email, err := s.templates.render(name, data)
 	if err != nil {
+		fmt.Printf("[ERROR] Failed to render email template %s: %v\n", name, err)
+		return fmt.Errorf("failed to render email: %w", err)
+	}
+
+	return s.sendEmail(to, subject, listUnsubscribeHeaders(data.layoutFields().UnsubscribeURL), email)
+}
+
+// sendEmail composes the message and hands it to the configured transport
+func (s *EmailService) sendEmail(to, subject string, headers map[string]string, email *renderedEmail) error {
+	fmt.Printf("[DEBUG] EmailService.sendEmail called with: to=%s, subject=%s\n", to, subject)
+
+	raw, err := s.composeMessage(to, subject, headers, email, "")
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to compose email: %v\n", err)
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	if s.dkim != nil {
+		raw, err = s.dkim.sign(raw)
+		if err != nil {
+			fmt.Printf("[ERROR] Failed to sign email: %v\n", err)
+			return fmt.Errorf("failed to send email: %w", err)
+		}
+	}
+
+	message := &EmailMessage{
+		From:    mail.Address{Name: s.config.Email.FromName, Address: s.config.Email.FromAddress},
+		To:      to,
+		Subject: subject,
+		Text:    email.Text,
+		HTML:    email.HTML,
+		Headers: headers,
+		Raw:     raw,
+	}
+
+	if err := s.transport.Send(message); err != nil {
 		fmt.Printf("[ERROR] Failed to send email: %v\n", err)
 		return fmt.Errorf("failed to send email: %w", err)
 	}
@@ -70,14 +211,11 @@ func (s *EmailService) SendConfirmationEmail(email, confirmURL, city string) err
 
 	subject := fmt.Sprintf("Confirm your weather subscription for %s", city)
 
-	htmlContent := fmt.Sprintf(
-		"<p>Please confirm your subscription to weather updates for %s by clicking the following link:</p>"+
-			"<p><a href=\"%s\">Confirm Subscription</a></p>"+
-			"<p>This link will expire in 24 hours.</p>",
-		city, confirmURL,
-	)
-
-	return s.sendEmail(email, subject, htmlContent, true)
+	return s.sendTemplate(email, subject, templateConfirmation, confirmationEmailData{
+		emailLayout: s.layout(subject, ""),
+		City:        city,
+		ConfirmURL:  confirmURL,
+	})
 }
 
 func (s *EmailService) SendWelcomeEmail(email, city, frequency, unsubscribeURL string) error {
@@ -86,19 +224,99 @@ func (s *EmailService) SendWelcomeEmail(email, city, frequency, unsubscribeURL s
 
 	subject := fmt.Sprintf("Welcome to Weather Updates for %s", city)
 
-	frequencyText := "every hour"
-	if frequency == "daily" {
-		frequencyText = "every day"
+	return s.sendTemplate(email, subject, templateWelcome, welcomeEmailData{
+		emailLayout:   s.layout(subject, unsubscribeURL),
+		City:          city,
+		Frequency:     frequency,
+		FrequencyText: frequencyText(frequency),
+	})
+}
+
+// frequencyText describes how often emails of a frequency are sent
+func frequencyText(frequency string) string {
+	switch frequency {
+	case "daily":
+		return "every day"
+	case models.FrequencyAlerts:
+		return "as soon as a severe weather alert is issued"
 	}
+	return "every hour"
+}
+
+// SendChangeConfirmationEmail asks to confirm a change of the preferences of a confirmed subscription
+func (s *EmailService) SendChangeConfirmationEmail(email, confirmURL, city string, change *models.PreferenceChange) error {
+	fmt.Printf("[DEBUG] SendChangeConfirmationEmail called for: %s, city: %s\n", email, city)
+
+	subject := fmt.Sprintf("Confirm the changes to your weather subscription for %s", city)
+
+	var rules []string
+	for _, rule := range rulesFromRequest(0, change.Rules) {
+		rules = append(rules, describeRule(rule, change.Units))
+	}
+
+	return s.sendTemplate(email, subject, templateChange, changeConfirmationEmailData{
+		emailLayout:   s.layout(subject, ""),
+		City:          city,
+		ConfirmURL:    confirmURL,
+		Frequency:     change.Frequency,
+		FrequencyText: frequencyText(change.Frequency),
+		Units:         change.Units,
+		Language:      change.Language,
+		AirQuality:    change.AirQuality,
+		Rules:         rules,
+	})
+}
+
+// SendSubscriptionUpdatedEmail tells the owner of a subscription that it was changed on the
+// management page, so a change they did not make does not go unnoticed
+func (s *EmailService) SendSubscriptionUpdatedEmail(email string, subscription *models.Subscription, manageURL string) error {
+	fmt.Printf("[DEBUG] SendSubscriptionUpdatedEmail called for: %s, city: %s\n", email, subscription.City)
+
+	subject := fmt.Sprintf("Your weather subscription for %s was changed", subscription.City)
+
+	pausedUntil := ""
+	if subscription.Paused && subscription.PausedUntil != nil {
+		pausedUntil = subscription.PausedUntil.UTC().Format("2 January 2006 15:04 MST")
+	}
+
+	return s.sendTemplate(email, subject, templateUpdated, subscriptionUpdatedEmailData{
+		emailLayout:   s.layout(subject, ""),
+		City:          subscription.City,
+		Frequency:     subscription.Frequency,
+		FrequencyText: frequencyText(subscription.Frequency),
+		Paused:        subscription.Paused,
+		PausedUntil:   pausedUntil,
+		ManageURL:     manageURL,
+	})
+}
+
+// SendManageLinkEmail sends the magic link to the subscription management page
+func (s *EmailService) SendManageLinkEmail(email, manageURL string) error {
+	fmt.Printf("[DEBUG] SendManageLinkEmail called for: %s\n", email)
+
+	subject := "Manage your weather subscriptions"
+
+	return s.sendTemplate(email, subject, templateManageLink, manageLinkEmailData{
+		emailLayout: s.layout(subject, ""),
+		ManageURL:   manageURL,
+	})
+}
+
+// SendPrivacyLinkEmail sends the link to export or erase all data held about an address
+func (s *EmailService) SendPrivacyLinkEmail(email, action, privacyURL string) error {
+	fmt.Printf("[DEBUG] SendPrivacyLinkEmail called for: %s, action: %s\n", email, action)
 
-	htmlContent := fmt.Sprintf(
-		"<p>Thank you for subscribing to %s weather updates for %s.</p>"+
-			"<p>You will receive updates %s.</p>"+
-			"<p>To unsubscribe, <a href=\"%s\">click here</a>.</p>",
-		frequency, city, frequencyText, unsubscribeURL,
-	)
+	erase := action == models.PrivacyActionErase
+	subject := "Download your weather subscription data"
+	if erase {
+		subject = "Erase your weather subscription data"
+	}
 
-	return s.sendEmail(email, subject, htmlContent, true)
+	return s.sendTemplate(email, subject, templatePrivacyLink, privacyLinkEmailData{
+		emailLayout: s.layout(subject, ""),
+		Erase:       erase,
+		PrivacyURL:  privacyURL,
+	})
 }
 
 func (s *EmailService) SendUnsubscribeConfirmationEmail(email, city string) error {
@@ -106,27 +324,89 @@ func (s *EmailService) SendUnsubscribeConfirmationEmail(email, city string) erro
 
 	subject := fmt.Sprintf("You have unsubscribed from weather updates for %s", city)
 
-	htmlContent := fmt.Sprintf(
-		"<p>You have successfully unsubscribed from weather updates for %s.</p>",
-		city,
-	)
+	return s.sendTemplate(email, subject, templateUnsubscribe, unsubscribeEmailData{
+		emailLayout: s.layout(subject, ""),
+		City:        city,
+	})
+}
 
-	return s.sendEmail(email, subject, htmlContent, true)
+// unitLabels are the display units for a weather response's unit system
+type unitLabels struct {
+	Temperature   string
+	Speed         string
+	Precipitation string
 }
 
-func (s *EmailService) SendWeatherUpdateEmail(email, city string, weather *models.WeatherResponse, unsubscribeURL string) error {
-	fmt.Printf("[DEBUG] SendWeatherUpdateEmail called for: %s, city: %s\n", email, city)
+func labelsForUnits(units string) unitLabels {
+	if units == models.UnitsImperial {
+		return unitLabels{Temperature: "°F", Speed: "mph", Precipitation: "in"}
+	}
+	return unitLabels{Temperature: "°C", Speed: "km/h", Precipitation: "mm"}
+}
 
-	subject := fmt.Sprintf("Weather Update for %s", city)
+// SendWeatherUpdateEmail sends one weather update covering every city of the digest
+func (s *EmailService) SendWeatherUpdateEmail(email string, digest *models.WeatherDigest) error {
+	fmt.Printf("[DEBUG] SendWeatherUpdateEmail called for: %s, cities: %d\n", email, len(digest.Cities))
+
+	cities := make([]string, 0, len(digest.Cities))
+	views := make([]digestCityView, 0, len(digest.Cities))
+	for _, city := range digest.Cities {
+		cities = append(cities, city.City)
+		views = append(views, digestCityView{
+			City:           city.City,
+			Weather:        city.Weather,
+			Labels:         labelsForUnits(city.Weather.Units),
+			UnsubscribeURL: city.UnsubscribeURL,
+		})
+	}
+
+	subject := fmt.Sprintf("Weather Update for %s", joinCities(cities))
+
+	return s.sendTemplate(email, subject, templateWeatherUpdate, weatherUpdateEmailData{
+		emailLayout: s.layout(subject, digest.UnsubscribeAllURL),
+		Cities:      views,
+		ManageURL:   digest.ManageURL,
+	})
+}
 
-	htmlContent := fmt.Sprintf(
-		"<h2>Current weather for %s</h2>"+
-			"<p><strong>Temperature:</strong> %.1f°C</p>"+
-			"<p><strong>Humidity:</strong> %.1f%%</p>"+
-			"<p><strong>Description:</strong> %s</p>"+
-			"<p>To unsubscribe, <a href=\"%s\">click here</a>.</p>",
-		city, weather.Temperature, weather.Humidity, weather.Description, unsubscribeURL,
-	)
+// joinCities lists city names for a subject line, e.g. "London, Paris and Rome"
+func joinCities(cities []string) string {
+	if len(cities) <= 1 {
+		return strings.Join(cities, "")
+	}
+	return strings.Join(cities[:len(cities)-1], ", ") + " and " + cities[len(cities)-1]
+}
+
+func (s *EmailService) SendWeatherAlertEmail(email, city string, alert *models.WeatherAlert, unsubscribeURL string) error {
+	fmt.Printf("[DEBUG] SendWeatherAlertEmail called for: %s, city: %s, alert: %s\n", email, city, alert.ID)
+
+	subject := fmt.Sprintf("Weather alert for %s: %s", city, alert.Event)
+
+	return s.sendTemplate(email, subject, templateWeatherAlert, weatherAlertEmailData{
+		emailLayout: s.layout(subject, unsubscribeURL),
+		City:        city,
+		Alert:       alert,
+	})
+}
+
+func (s *EmailService) SendRuleTriggeredEmail(email, city string, triggered []models.TriggeredRule, units, unsubscribeURL string) error {
+	fmt.Printf("[DEBUG] SendRuleTriggeredEmail called for: %s, city: %s, rules: %d\n", email, city, len(triggered))
+
+	subject := fmt.Sprintf("Weather notification for %s", city)
+
+	rules := make([]triggeredRuleView, 0, len(triggered))
+	for _, t := range triggered {
+		rules = append(rules, triggeredRuleView{
+			Description: describeRule(t.Rule, units),
+			Date:        t.Day.Date,
+			Value:       formatRuleValue(t.Rule.Metric, t.Value, units),
+			Summary:     t.Day.Description,
+		})
+	}
 
-	return s.sendEmail(email, subject, htmlContent, true)
+	return s.sendTemplate(email, subject, templateRuleTriggered, ruleTriggeredEmailData{
+		emailLayout: s.layout(subject, unsubscribeURL),
+		City:        city,
+		Rules:       rules,
+	})
 }
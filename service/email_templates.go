@@ -0,0 +1,446 @@
+package service
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"weatherapi.app/config"
+	"weatherapi.app/models"
+)
+
+//go:embed templates/*.html templates/*.txt
+var embeddedTemplates embed.FS
+
+// Email template names, each backed by a <name>.html and <name>.txt file
+const (
+	templateConfirmation  = "confirmation"
+	templateWelcome       = "welcome"
+	templateUnsubscribe   = "unsubscribe"
+	templateWeatherUpdate = "weather_update"
+	templateWeatherAlert  = "weather_alert"
+	templateRuleTriggered = "rule_triggered"
+	templateManageLink    = "manage_link"
+	templatePrivacyLink   = "privacy_link"
+	templateChange        = "change_confirmation"
+	templateUpdated       = "subscription_updated"
+)
+
+var emailTemplateNames = []string{
+	templateConfirmation,
+	templateWelcome,
+	templateUnsubscribe,
+	templateWeatherUpdate,
+	templateWeatherAlert,
+	templateRuleTriggered,
+	templateManageLink,
+	templatePrivacyLink,
+	templateChange,
+	templateUpdated,
+}
+
+// emailBrand identifies the sender in the layout templates
+type emailBrand struct {
+	Name string
+	URL  string
+}
+
+// emailLayout holds the fields shared by every email through the layout templates
+type emailLayout struct {
+	Brand          emailBrand
+	Subject        string
+	UnsubscribeURL string
+}
+
+// emailData is implemented by every template data type through the embedded emailLayout
+type emailData interface {
+	layoutFields() emailLayout
+}
+
+func (l emailLayout) layoutFields() emailLayout {
+	return l
+}
+
+func brandFromConfig(cfg *config.Config) emailBrand {
+	return emailBrand{Name: cfg.Email.FromName, URL: cfg.AppBaseURL}
+}
+
+type confirmationEmailData struct {
+	emailLayout
+	City       string
+	ConfirmURL string
+}
+
+type welcomeEmailData struct {
+	emailLayout
+	City          string
+	Frequency     string
+	FrequencyText string
+}
+
+type changeConfirmationEmailData struct {
+	emailLayout
+	City          string
+	ConfirmURL    string
+	Frequency     string
+	FrequencyText string
+	Units         string
+	Language      string
+	AirQuality    bool
+	Rules         []string // descriptions of the new rules; empty when the rules stay unchanged
+}
+
+type subscriptionUpdatedEmailData struct {
+	emailLayout
+	City          string
+	Frequency     string
+	FrequencyText string
+	Paused        bool
+	PausedUntil   string // empty when paused until resumed by hand
+	ManageURL     string
+}
+
+type manageLinkEmailData struct {
+	emailLayout
+	ManageURL string
+}
+
+type privacyLinkEmailData struct {
+	emailLayout
+	Erase      bool // the link erases the data rather than exporting it
+	PrivacyURL string
+}
+
+type unsubscribeEmailData struct {
+	emailLayout
+	City string
+}
+
+type weatherUpdateEmailData struct {
+	emailLayout
+	Cities    []digestCityView
+	ManageURL string
+}
+
+// digestCityView is the weather of one city in a weather update email
+type digestCityView struct {
+	City           string
+	Weather        *models.WeatherResponse
+	Labels         unitLabels
+	UnsubscribeURL string
+}
+
+type weatherAlertEmailData struct {
+	emailLayout
+	City  string
+	Alert *models.WeatherAlert
+}
+
+type ruleTriggeredEmailData struct {
+	emailLayout
+	City  string
+	Rules []triggeredRuleView
+}
+
+// triggeredRuleView is a fired rule already formatted for display
+type triggeredRuleView struct {
+	Description string
+	Date        string
+	Value       string
+	Summary     string
+}
+
+// renderedEmail is the HTML and plain-text body of an email
+type renderedEmail struct {
+	HTML string
+	Text string
+}
+
+// emailTemplates holds the parsed HTML and plain-text template of each email
+type emailTemplates struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// parseEmailTemplates parses the layout and every email template from fsys
+func parseEmailTemplates(fsys fs.FS) (*emailTemplates, error) {
+	htmlLayout, err := htmltemplate.ParseFS(fsys, "layout.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML layout: %w", err)
+	}
+	textLayout, err := texttemplate.ParseFS(fsys, "layout.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse text layout: %w", err)
+	}
+
+	templates := &emailTemplates{
+		html: make(map[string]*htmltemplate.Template),
+		text: make(map[string]*texttemplate.Template),
+	}
+
+	for _, name := range emailTemplateNames {
+		htmlTemplate, err := htmltemplate.Must(htmlLayout.Clone()).ParseFS(fsys, name+".html")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s.html: %w", name, err)
+		}
+		templates.html[name] = htmlTemplate
+
+		textTemplate, err := texttemplate.Must(textLayout.Clone()).ParseFS(fsys, name+".txt")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s.txt: %w", name, err)
+		}
+		templates.text[name] = textTemplate
+	}
+
+	return templates, nil
+}
+
+// embeddedTemplateFS returns the embedded templates rooted at the template directory
+func embeddedTemplateFS() fs.FS {
+	fsys, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// defaultEmailTemplates are the templates embedded in the binary
+var defaultEmailTemplates = func() *emailTemplates {
+	templates, err := parseEmailTemplates(embeddedTemplateFS())
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}()
+
+// overlayFS serves files from override when present and from base otherwise
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	file, err := o.override.Open(name)
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.base.Open(name)
+}
+
+// loadEmailTemplates parses the templates in dir on top of the embedded defaults.
+// Any template file missing from dir falls back to the embedded one; an empty dir uses the defaults.
+func loadEmailTemplates(dir string) (*emailTemplates, error) {
+	if dir == "" {
+		return defaultEmailTemplates, nil
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("email template directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("email template directory: %s is not a directory", dir)
+	}
+
+	override := os.DirFS(dir)
+	entries, err := fs.ReadDir(override, ".")
+	if err != nil {
+		return nil, fmt.Errorf("email template directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !isEmailTemplateFile(entry.Name()) {
+			fmt.Printf("[WARNING] Ignoring unknown file in email template directory: %s\n", entry.Name())
+		}
+	}
+
+	return parseEmailTemplates(overlayFS{override: override, base: embeddedTemplateFS()})
+}
+
+// isEmailTemplateFile reports whether name is one of the template files that can be overridden
+func isEmailTemplateFile(name string) bool {
+	base := strings.TrimSuffix(strings.TrimSuffix(name, ".html"), ".txt")
+	if base == name {
+		return false
+	}
+	if base == "layout" {
+		return true
+	}
+	for _, templateName := range emailTemplateNames {
+		if base == templateName {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateEmailTemplates checks that every email template, including overrides from
+// EMAIL_TEMPLATE_DIR, parses and renders against sample data
+func ValidateEmailTemplates(cfg *config.Config) error {
+	templates, err := loadEmailTemplates(cfg.Email.TemplateDir)
+	if err != nil {
+		return err
+	}
+	return templates.validate(brandFromConfig(cfg))
+}
+
+// validate renders every email with sample data
+func (t *emailTemplates) validate(brand emailBrand) error {
+	for _, name := range emailTemplateNames {
+		if _, err := t.render(name, sampleEmailData(name, brand)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sampleEmailData returns representative data for the named email, exercising every optional section
+func sampleEmailData(name string, brand emailBrand) emailData {
+	unsubscribeURL := brand.URL + "/api/unsubscribe/sample-token"
+	effective := time.Date(2024, 1, 21, 6, 0, 0, 0, time.UTC)
+	expires := time.Date(2024, 1, 22, 18, 0, 0, 0, time.UTC)
+
+	switch name {
+	case templateConfirmation:
+		return confirmationEmailData{
+			emailLayout: emailLayout{Brand: brand, Subject: "Confirm your weather subscription for London"},
+			City:        "London",
+			ConfirmURL:  brand.URL + "/api/confirm/sample-token",
+		}
+	case templateWelcome:
+		return welcomeEmailData{
+			emailLayout:   emailLayout{Brand: brand, Subject: "Welcome to Weather Updates for London", UnsubscribeURL: unsubscribeURL},
+			City:          "London",
+			Frequency:     "daily",
+			FrequencyText: "every day",
+		}
+	case templateChange:
+		return changeConfirmationEmailData{
+			emailLayout:   emailLayout{Brand: brand, Subject: "Confirm the changes to your weather subscription for London"},
+			City:          "London",
+			ConfirmURL:    brand.URL + "/api/confirm/sample-token",
+			Frequency:     "hourly",
+			FrequencyText: "every hour",
+			Units:         models.UnitsImperial,
+			Language:      "fr",
+			AirQuality:    true,
+			Rules:         []string{"tomorrow's low temperature is below 32.0°F"},
+		}
+	case templateUpdated:
+		return subscriptionUpdatedEmailData{
+			emailLayout:   emailLayout{Brand: brand, Subject: "Your weather subscription for Paris was changed"},
+			City:          "Paris",
+			Frequency:     "daily",
+			FrequencyText: "every day",
+			Paused:        true,
+			PausedUntil:   "1 July 2026 08:00 UTC",
+			ManageURL:     brand.URL + "/manage?token=sample-token",
+		}
+	case templateManageLink:
+		return manageLinkEmailData{
+			emailLayout: emailLayout{Brand: brand, Subject: "Manage your weather subscriptions"},
+			ManageURL:   brand.URL + "/manage?token=sample-token",
+		}
+	case templatePrivacyLink:
+		return privacyLinkEmailData{
+			emailLayout: emailLayout{Brand: brand, Subject: "Erase your weather subscription data"},
+			Erase:       true,
+			PrivacyURL:  brand.URL + "/privacy?action=erase&token=sample-token",
+		}
+	case templateUnsubscribe:
+		return unsubscribeEmailData{
+			emailLayout: emailLayout{Brand: brand, Subject: "You have unsubscribed from weather updates for London"},
+			City:        "London",
+		}
+	case templateWeatherUpdate:
+		return weatherUpdateEmailData{
+			emailLayout: emailLayout{Brand: brand, Subject: "Weather Update for London and New York", UnsubscribeURL: unsubscribeURL + "?scope=all"},
+			Cities: []digestCityView{
+				{
+					City: "London",
+					Weather: &models.WeatherResponse{
+						Units:         models.UnitsMetric,
+						Temperature:   15.0,
+						FeelsLike:     14.2,
+						Humidity:      76.0,
+						WindSpeed:     11.2,
+						WindDirection: "WSW",
+						Description:   "Partly cloudy",
+						AirQuality:    &models.AirQuality{PM25: 8.4, PM10: 12.1, O3: 52.3, NO2: 18.7, USEPAIndex: 1},
+					},
+					Labels:         labelsForUnits(models.UnitsMetric),
+					UnsubscribeURL: unsubscribeURL,
+				},
+				{
+					City: "New York",
+					Weather: &models.WeatherResponse{
+						Units:         models.UnitsImperial,
+						Temperature:   59.0,
+						FeelsLike:     57.6,
+						Humidity:      48.0,
+						WindSpeed:     6.9,
+						WindDirection: "NW",
+						Description:   "Sunny",
+					},
+					Labels:         labelsForUnits(models.UnitsImperial),
+					UnsubscribeURL: brand.URL + "/api/unsubscribe/sample-token-2",
+				},
+			},
+			ManageURL: brand.URL + "/manage",
+		}
+	case templateWeatherAlert:
+		return weatherAlertEmailData{
+			emailLayout: emailLayout{Brand: brand, Subject: "Weather alert for London: Wind warning", UnsubscribeURL: unsubscribeURL},
+			City:        "London",
+			Alert: &models.WeatherAlert{
+				ID:          "sample-alert",
+				Headline:    "Yellow wind warning",
+				Event:       "Wind warning",
+				Severity:    "Moderate",
+				Urgency:     "Expected",
+				Areas:       "London & South East England",
+				Description: "Strong winds may cause travel disruption.",
+				Instruction: "Secure loose objects.",
+				Effective:   &effective,
+				Expires:     &expires,
+			},
+		}
+	case templateRuleTriggered:
+		return ruleTriggeredEmailData{
+			emailLayout: emailLayout{Brand: brand, Subject: "Weather notification for London", UnsubscribeURL: unsubscribeURL},
+			City:        "London",
+			Rules: []triggeredRuleView{
+				{Description: "tomorrow's low temperature is below 0.0°C", Date: "2024-01-22", Value: "-2.0°C", Summary: "Light sleet"},
+				{Description: "tomorrow's chance of rain is above 70%", Date: "2024-01-22", Value: "85%", Summary: "Light sleet"},
+			},
+		}
+	}
+	return nil
+}
+
+// render executes both templates of the named email with data
+func (t *emailTemplates) render(name string, data emailData) (*renderedEmail, error) {
+	htmlTemplate, ok := t.html[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template: %s", name)
+	}
+
+	var htmlBody, textBody bytes.Buffer
+	if err := htmlTemplate.ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s.html: %w", name, err)
+	}
+	if err := t.text[name].ExecuteTemplate(&textBody, "layout", data); err != nil {
+		return nil, fmt.Errorf("failed to render %s.txt: %w", name, err)
+	}
+
+	return &renderedEmail{HTML: htmlBody.String(), Text: textBody.String()}, nil
+}
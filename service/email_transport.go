@@ -0,0 +1,353 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"weatherapi.app/config"
+)
+
+// EmailMessage is a composed email handed to an EmailTransport.
+// Raw holds the complete MIME message for transports that accept one;
+// the other fields are for providers that build the message themselves.
+type EmailMessage struct {
+	From    mail.Address
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // additional headers such as List-Unsubscribe
+	Raw     []byte
+}
+
+// NewEmailTransport creates the transport selected by EMAIL_TRANSPORT
+func NewEmailTransport(cfg *config.Config) (EmailTransport, error) {
+	emailConfig := cfg.Email
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+
+	switch emailConfig.Transport {
+	case config.EmailTransportSMTP, "":
+		return newSMTPTransport(emailConfig), nil
+	case config.EmailTransportSendGrid:
+		return &sendGridTransport{
+			apiKey:  emailConfig.APIKey,
+			baseURL: baseURLOrDefault(emailConfig.APIBaseURL, "https://api.sendgrid.com"),
+			client:  httpClient,
+		}, nil
+	case config.EmailTransportMailgun:
+		return &mailgunTransport{
+			apiKey:  emailConfig.APIKey,
+			domain:  emailConfig.MailgunDomain,
+			baseURL: baseURLOrDefault(emailConfig.APIBaseURL, "https://api.mailgun.net"),
+			client:  httpClient,
+		}, nil
+	case config.EmailTransportSES:
+		return &sesTransport{
+			region:    emailConfig.SESRegion,
+			accessKey: emailConfig.SESAccessKey,
+			secretKey: emailConfig.SESSecretKey,
+			baseURL:   baseURLOrDefault(emailConfig.APIBaseURL, fmt.Sprintf("https://email.%s.amazonaws.com", emailConfig.SESRegion)),
+			client:    httpClient,
+			now:       time.Now,
+		}, nil
+	case config.EmailTransportFile:
+		return &maildirTransport{dir: emailConfig.MaildirPath}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported email transport: %s", emailConfig.Transport)
+}
+
+func baseURLOrDefault(baseURL, defaultURL string) string {
+	if baseURL == "" {
+		return defaultURL
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+// checkAPIResponse turns a non-2xx provider response into an error
+func checkAPIResponse(provider string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s API returned status %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// sendGridTransport sends messages through the SendGrid v3 mail send API
+type sendGridTransport struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridRequest struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+// Close does nothing, since every message is its own HTTP request
+func (t *sendGridTransport) Close() error {
+	return nil
+}
+
+func (t *sendGridTransport) Send(message *EmailMessage) error {
+	payload := sendGridRequest{
+		Personalizations: []sendGridPersonalization{
+			{To: []sendGridAddress{{Email: message.To}}},
+		},
+		From:    sendGridAddress{Email: message.From.Address, Name: message.From.Name},
+		Subject: message.Subject,
+		Content: []sendGridContent{
+			{Type: "text/plain", Value: message.Text},
+			{Type: "text/html", Value: message.HTML},
+		},
+		Headers: message.Headers,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode SendGrid request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.baseURL+"/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create SendGrid request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	fmt.Printf("[DEBUG] Sending email via SendGrid: to=%s\n", message.To)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call SendGrid API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return checkAPIResponse("SendGrid", resp)
+}
+
+// mailgunTransport sends raw MIME messages through the Mailgun messages.mime API
+type mailgunTransport struct {
+	apiKey  string
+	domain  string
+	baseURL string
+	client  *http.Client
+}
+
+func (t *mailgunTransport) Close() error {
+	return nil
+}
+
+func (t *mailgunTransport) Send(message *EmailMessage) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField("to", message.To); err != nil {
+		return fmt.Errorf("failed to create Mailgun request: %w", err)
+	}
+	part, err := writer.CreateFormFile("message", "message.mime")
+	if err != nil {
+		return fmt.Errorf("failed to create Mailgun request: %w", err)
+	}
+	if _, err := part.Write(message.Raw); err != nil {
+		return fmt.Errorf("failed to create Mailgun request: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to create Mailgun request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v3/%s/messages.mime", t.baseURL, t.domain)
+	req, err := http.NewRequest(http.MethodPost, endpoint, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create Mailgun request: %w", err)
+	}
+	req.SetBasicAuth("api", t.apiKey)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	fmt.Printf("[DEBUG] Sending email via Mailgun: domain=%s, to=%s\n", t.domain, message.To)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Mailgun API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return checkAPIResponse("Mailgun", resp)
+}
+
+// sesTransport sends raw MIME messages through the Amazon SES v2 API, signed with AWS Signature Version 4
+type sesTransport struct {
+	region    string
+	accessKey string
+	secretKey string
+	baseURL   string
+	client    *http.Client
+	now       func() time.Time
+}
+
+type sesRequest struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Raw struct {
+			Data string `json:"Data"`
+		} `json:"Raw"`
+	} `json:"Content"`
+}
+
+func (t *sesTransport) Close() error {
+	return nil
+}
+
+func (t *sesTransport) Send(message *EmailMessage) error {
+	var payload sesRequest
+	payload.FromEmailAddress = message.From.Address
+	payload.Destination.ToAddresses = []string{message.To}
+	payload.Content.Raw.Data = base64.StdEncoding.EncodeToString(message.Raw)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode SES request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.baseURL+"/v2/email/outbound-emails", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create SES request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	t.sign(req, body)
+
+	fmt.Printf("[DEBUG] Sending email via SES: region=%s, to=%s\n", t.region, message.To)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call SES API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return checkAPIResponse("SES", resp)
+}
+
+// sign adds AWS Signature Version 4 headers for the "ses" service to req
+func (t *sesTransport) sign(req *http.Request, body []byte) {
+	now := t.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("content-type:%s\nhost:%s\nx-amz-date:%s\n",
+		req.Header.Get("Content-Type"), req.URL.Host, amzDate)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/ses/aws4_request", date, t.region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), date)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, "ses")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// maildirTransport writes messages to a local Maildir for development instead of sending them
+type maildirTransport struct {
+	dir string
+}
+
+// maildirSequence keeps file names unique within the process
+var maildirSequence uint64
+
+// Close does nothing, since every message is written to its own file
+func (t *maildirTransport) Close() error {
+	return nil
+}
+
+func (t *maildirTransport) Send(message *EmailMessage) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.dir, sub), 0755); err != nil {
+			return fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	hostname = strings.NewReplacer("/", "_", ":", "_").Replace(hostname)
+
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(),
+		atomic.AddUint64(&maildirSequence, 1), hostname)
+
+	// Messages are written to tmp and moved to new so readers never see partial files
+	tmpPath := filepath.Join(t.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, message.Raw, 0644); err != nil {
+		return fmt.Errorf("failed to write message to maildir: %w", err)
+	}
+	newPath := filepath.Join(t.dir, "new", name)
+	if err := os.Rename(tmpPath, newPath); err != nil {
+		return fmt.Errorf("failed to deliver message to maildir: %w", err)
+	}
+
+	fmt.Printf("[DEBUG] Email written to maildir: %s\n", newPath)
+	return nil
+}
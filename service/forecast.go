@@ -0,0 +1,60 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"weatherapi.app/models"
+)
+
+// GetForecast returns the daily forecast for the location, starting with today
+func (s *WeatherService) GetForecast(location models.LocationQuery, options models.WeatherOptions, days int) (*models.Forecast, error) {
+	fmt.Printf("[DEBUG] WeatherService.GetForecast called for %s location: %s, days: %d\n",
+		location.Type, location.Query(), days)
+
+	if err := location.Validate(); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("key", s.config.Weather.APIKey)
+	params.Set("q", location.Query())
+	params.Set("days", strconv.Itoa(days))
+	params.Set("aqi", "no")
+	params.Set("alerts", "no")
+	if options.Language != "" && options.Language != models.DefaultLanguage {
+		params.Set("lang", options.Language)
+	}
+	requestURL := fmt.Sprintf("%s/forecast.json?%s", s.config.Weather.BaseURL, params.Encode())
+
+	resp, err := s.client.Get(requestURL)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to get forecast: %v\n", err)
+		return nil, fmt.Errorf("failed to get forecast: %w", err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("[DEBUG] Weather API forecast response status: %d\n", resp.StatusCode)
+
+	if err := checkWeatherAPIStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var result weatherAPIForecastResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		fmt.Printf("[ERROR] Failed to decode forecast: %v\n", err)
+		return nil, fmt.Errorf("failed to decode forecast: %w", err)
+	}
+
+	if result.Forecast == nil {
+		fmt.Printf("[ERROR] Invalid forecast data format, 'forecast' field not found\n")
+		return nil, fmt.Errorf("invalid forecast data format")
+	}
+
+	forecast := result.toForecast(options.Units)
+
+	fmt.Printf("[DEBUG] Parsed %d forecast days\n", len(forecast.Days))
+	return forecast, nil
+}
@@ -8,7 +8,10 @@ import (
 
 // WeatherServiceInterface defines the interface for the weather service
 type WeatherServiceInterface interface {
-	GetWeather(city string) (*models.WeatherResponse, error)
+	GetWeather(location models.LocationQuery, options models.WeatherOptions) (*models.WeatherResponse, error)
+	SearchCities(query string) ([]models.CitySearchResult, error)
+	GetAlerts(location models.LocationQuery, options models.WeatherOptions) ([]models.WeatherAlert, error)
+	GetForecast(location models.LocationQuery, options models.WeatherOptions, days int) (*models.Forecast, error)
 }
 
 // Ensure WeatherService implements WeatherServiceInterface
@@ -16,10 +19,29 @@ var _ WeatherServiceInterface = (*WeatherService)(nil)
 
 // SubscriptionServiceInterface defines the interface for the subscription service
 type SubscriptionServiceInterface interface {
-	Subscribe(req *models.SubscriptionRequest) error
-	ConfirmSubscription(token string) error
-	Unsubscribe(token string) error
+	Subscribe(req *models.SubscriptionRequest, meta models.RequestMeta) error
+	ConfirmSubscription(token string, meta models.RequestMeta) error
+	ResendConfirmation(email, city, frequency string) error
+	Unsubscribe(token string, meta models.RequestMeta) error
+	UnsubscribeAll(token string, meta models.RequestMeta) error
+	PauseSubscription(token string, until *time.Time) (*models.Subscription, error)
+	ResumeSubscription(token string) (*models.Subscription, error)
 	SendWeatherUpdate(frequency string) error
+	CheckWeatherAlerts() error
+	EvaluateNotificationRules() error
+	ProcessBounceWebhook(provider string, body []byte) error
+	RequestManageLink(email string) error
+	ListManagedSubscriptions(token string) ([]models.Subscription, error)
+	UpdateManagedSubscription(token string, id uint, update *models.SubscriptionUpdate) (*models.Subscription, error)
+	DeleteManagedSubscription(token string, id uint, meta models.RequestMeta) error
+	ListSuppressions() ([]models.Suppression, error)
+	AddSuppression(email, reason string) (*models.Suppression, error)
+	RemoveSuppression(email string) error
+	RevokeTokens(subscriptionID uint) (int64, error)
+	ListConsentEvents(email string) ([]models.ConsentEvent, error)
+	RequestPrivacyLink(email, action string) error
+	ExportPersonalData(token string) (*models.PersonalData, error)
+	ErasePersonalData(token string) (*models.ErasureResult, error)
 }
 
 // Ensure SubscriptionService implements SubscriptionServiceInterface
@@ -30,20 +52,35 @@ type EmailServiceInterface interface {
 	SendConfirmationEmail(email, confirmURL, city string) error
 	SendWelcomeEmail(email, city, frequency, unsubscribeURL string) error
 	SendUnsubscribeConfirmationEmail(email, city string) error
-	SendWeatherUpdateEmail(email, city string, weather *models.WeatherResponse, unsubscribeURL string) error
+	SendManageLinkEmail(email, manageURL string) error
+	SendPrivacyLinkEmail(email, action, privacyURL string) error
+	SendChangeConfirmationEmail(email, confirmURL, city string, change *models.PreferenceChange) error
+	SendSubscriptionUpdatedEmail(email string, subscription *models.Subscription, manageURL string) error
+	SendWeatherUpdateEmail(email string, digest *models.WeatherDigest) error
+	SendWeatherAlertEmail(email, city string, alert *models.WeatherAlert, unsubscribeURL string) error
+	SendRuleTriggeredEmail(email, city string, triggered []models.TriggeredRule, units, unsubscribeURL string) error
 }
 
 // Ensure EmailService implements EmailServiceInterface
 var _ EmailServiceInterface = (*EmailService)(nil)
 
+// EmailTransport defines the interface for delivering composed email messages
+type EmailTransport interface {
+	Send(message *EmailMessage) error
+	Close() error // releases open connections; Send may still be called afterwards
+}
+
 // SubscriptionRepositoryInterface defines the interface for subscription repository
 type SubscriptionRepositoryInterface interface {
-	FindByEmail(email, city string) (*models.Subscription, error)
+	FindByEmail(email, city, frequency string) (*models.Subscription, error)
 	FindByID(id uint) (*models.Subscription, error)
+	FindAllByEmail(email string) ([]models.Subscription, error)
 	Create(subscription *models.Subscription) error
 	Update(subscription *models.Subscription) error
 	Delete(subscription *models.Subscription) error
 	GetSubscriptionsForUpdates(frequency string) ([]models.Subscription, error)
+	SuppressByEmail(email, reason string) (int64, error)
+	UnsuppressByEmail(email string) error
 }
 
 // Ensure repository.SubscriptionRepository implements SubscriptionRepositoryInterface
@@ -51,7 +88,50 @@ type SubscriptionRepositoryInterface interface {
 // TokenRepositoryInterface defines the interface for token repository
 type TokenRepositoryInterface interface {
 	CreateToken(subscriptionID uint, tokenType string, expiresIn time.Duration) (*models.Token, error)
+	CreateTokenWithPayload(subscriptionID uint, tokenType, payload string, expiresIn time.Duration) (*models.Token, error)
 	FindByToken(tokenStr string) (*models.Token, error)
+	FindLatest(subscriptionID uint, tokenType string) (*models.Token, error)
+	DeleteBySubscription(subscriptionID uint, tokenType string) error
+	RevokeAll(subscriptionID uint) (int64, error)
 	DeleteToken(token *models.Token) error
 	DeleteExpiredTokens() error
 }
+
+// AlertRepositoryInterface defines the interface for the repository of sent weather alerts
+type AlertRepositoryInterface interface {
+	HasBeenSent(subscriptionID uint, alertID string) (bool, error)
+	MarkSent(subscriptionID uint, alertID string) error
+	DeleteSentBefore(before time.Time) error
+}
+
+// LinkRepositoryInterface defines the interface for the repository of links sent on request
+type LinkRepositoryInterface interface {
+	Claim(email, purpose string, cooldown time.Duration) (bool, error)
+	DeleteSentBefore(before time.Time) error
+}
+
+// RuleRepositoryInterface defines the interface for the notification rule repository
+type RuleRepositoryInterface interface {
+	GetRulesForEvaluation() ([]models.NotificationRule, error)
+	MarkTriggered(ruleID uint, forecastDate string) error
+}
+
+// ConsentRepositoryInterface defines the interface for reading the consent audit trail;
+// events are written inside the transactions that change subscriptions
+type ConsentRepositoryInterface interface {
+	List(email string) ([]models.ConsentEvent, error)
+}
+
+// PrivacyRepositoryInterface defines the interface for exporting and erasing the data of an address
+type PrivacyRepositoryInterface interface {
+	Export(email string) (*models.PersonalData, error)
+	Erase(email string) (*models.ErasureResult, error)
+}
+
+// SuppressionRepositoryInterface defines the interface for the email suppression list
+type SuppressionRepositoryInterface interface {
+	IsSuppressed(email string) (bool, error)
+	Add(suppression *models.Suppression) (bool, error)
+	Remove(email string) error
+	List() ([]models.Suppression, error)
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"weatherapi.app/models"
+	"weatherapi.app/tokens"
+)
+
+// manageTokenTTL is how long a requested management link stays valid
+const manageTokenTTL = time.Hour
+
+// emailedManageTokenTTL is how long the management links in digests and notices stay valid,
+// as they are often read well after they were sent
+const emailedManageTokenTTL = 7 * 24 * time.Hour
+
+// manageURL is the management page; without a token it asks for an address to email a link to
+func (s *SubscriptionService) manageURL(token string) string {
+	if token == "" {
+		return fmt.Sprintf("%s/manage", s.config.AppBaseURL)
+	}
+	return fmt.Sprintf("%s/manage?token=%s", s.config.AppBaseURL, token)
+}
+
+// RequestManageLink emails a management link to an address, at most once per resend cooldown.
+// Unknown and suppressed addresses and requests within the cooldown are ignored without an
+// error, so the endpoint does not reveal who is subscribed.
+func (s *SubscriptionService) RequestManageLink(email string) error {
+	fmt.Printf("[DEBUG] RequestManageLink called for: %s\n", email)
+
+	subscriptions, err := s.subscriptionRepo.FindAllByEmail(email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding subscriptions of %s: %v\n", email, err)
+		return err
+	}
+	if len(subscriptions) == 0 {
+		fmt.Println("[DEBUG] No subscriptions found, not sending a manage link")
+		return nil
+	}
+
+	subscription := &subscriptions[0]
+	if s.isSuppressed(subscription) {
+		fmt.Println("[DEBUG] Address is suppressed, not sending a manage link")
+		return nil
+	}
+	if claimed, err := s.claimLinkSend(subscription.Email, tokens.PurposeManage); err != nil || !claimed {
+		return err
+	}
+
+	token, err := s.signManageToken(subscriptions, manageTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := s.emailService.SendManageLinkEmail(subscription.Email, s.manageURL(token)); err != nil {
+		fmt.Printf("[ERROR] Failed to send manage link email: %v\n", err)
+		return fmt.Errorf("failed to send manage link email: %w", err)
+	}
+
+	return nil
+}
+
+// signManageToken signs a manage token for the address of subscriptions with their newest
+// token version, so bumping the version of any of them revokes it
+func (s *SubscriptionService) signManageToken(subscriptions []models.Subscription, ttl time.Duration) (string, error) {
+	signed := subscriptions[0]
+	for _, other := range subscriptions {
+		if other.TokenVersion > signed.TokenVersion {
+			signed.TokenVersion = other.TokenVersion
+		}
+	}
+	return s.signToken(&signed, tokens.PurposeManage, ttl)
+}
+
+// addressManageURL returns a management link for an address to put in the emails it is sent
+func (s *SubscriptionService) addressManageURL(email string) (string, error) {
+	subscriptions, err := s.subscriptionRepo.FindAllByEmail(email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding subscriptions of %s: %v\n", email, err)
+		return "", err
+	}
+	if len(subscriptions) == 0 {
+		return "", fmt.Errorf("record not found")
+	}
+
+	token, err := s.signManageToken(subscriptions, emailedManageTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	return s.manageURL(token), nil
+}
+
+// managedSubscription returns subscription id when it belongs to the address of a manage token
+func (s *SubscriptionService) managedSubscription(tokenStr string, id uint) (*models.Subscription, error) {
+	owner, err := s.manageTokenOwner(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription, err := s.subscriptionRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(subscription.Email, owner) {
+		fmt.Printf("[ERROR] Subscription %d does not belong to %s\n", id, owner)
+		return nil, fmt.Errorf("record not found")
+	}
+
+	return subscription, nil
+}
+
+// ListManagedSubscriptions returns every subscription of the address of a manage token
+func (s *SubscriptionService) ListManagedSubscriptions(tokenStr string) ([]models.Subscription, error) {
+	fmt.Println("[DEBUG] ListManagedSubscriptions called")
+
+	owner, err := s.manageTokenOwner(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.subscriptionRepo.FindAllByEmail(owner)
+}
+
+// UpdateManagedSubscription changes the city, frequency or paused state of a subscription
+func (s *SubscriptionService) UpdateManagedSubscription(tokenStr string, id uint, update *models.SubscriptionUpdate) (*models.Subscription, error) {
+	fmt.Printf("[DEBUG] UpdateManagedSubscription called for subscription: %d\n", id)
+
+	subscription, err := s.managedSubscription(tokenStr, id)
+	if err != nil {
+		return nil, err
+	}
+
+	city, frequency := subscription.City, subscription.Frequency
+	if update.City != nil {
+		city = strings.TrimSpace(*update.City)
+		if city == "" {
+			return nil, fmt.Errorf("city is required")
+		}
+	}
+	if update.Frequency != nil {
+		frequency = *update.Frequency
+	}
+
+	// Moving to another city, or between updates and alerts, must not duplicate a subscription
+	cityChanged := !strings.EqualFold(city, subscription.City)
+	if cityChanged || (frequency == models.FrequencyAlerts) != (subscription.Frequency == models.FrequencyAlerts) {
+		existing, err := s.subscriptionRepo.FindByEmail(subscription.Email, city, frequency)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && existing.ID != subscription.ID {
+			return nil, fmt.Errorf("email already subscribed")
+		}
+	}
+	if cityChanged {
+		// A new city replaces the coordinates the subscription may have been created with
+		subscription.City = city
+		subscription.Latitude = nil
+		subscription.Longitude = nil
+	}
+	subscription.Frequency = frequency
+	if update.Paused != nil || update.PausedUntil != nil {
+		// An end time implies pausing
+		paused := update.PausedUntil != nil || *update.Paused
+		if err := setPaused(subscription, paused, update.PausedUntil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.subscriptionRepo.Update(subscription); err != nil {
+		fmt.Printf("[ERROR] Error updating subscription: %v\n", err)
+		return nil, err
+	}
+
+	// Tell the owner, in case the link reached someone else. The change is saved already, so
+	// a failed notice does not fail the update.
+	if err := s.sendUpdatedNotice(subscription); err != nil {
+		fmt.Printf("[WARNING] Error sending subscription updated email, but continuing anyway: %v\n", err)
+	}
+
+	return subscription, nil
+}
+
+// sendUpdatedNotice emails the owner of a subscription changed on the management page
+func (s *SubscriptionService) sendUpdatedNotice(subscription *models.Subscription) error {
+	if s.isSuppressed(subscription) {
+		fmt.Println("[DEBUG] Address is suppressed, not sending a subscription updated email")
+		return nil
+	}
+
+	manageURL, err := s.addressManageURL(subscription.Email)
+	if err != nil {
+		return err
+	}
+
+	return s.emailService.SendSubscriptionUpdatedEmail(subscription.Email, subscription, manageURL)
+}
+
+// DeleteManagedSubscription deletes a subscription of the address of a manage token. The
+// token is bound to the address, so the link keeps working for its other subscriptions.
+func (s *SubscriptionService) DeleteManagedSubscription(tokenStr string, id uint, meta models.RequestMeta) error {
+	fmt.Printf("[DEBUG] DeleteManagedSubscription called for subscription: %d\n", id)
+
+	subscription, err := s.managedSubscription(tokenStr, id)
+	if err != nil {
+		return err
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		fmt.Printf("[ERROR] Error beginning transaction: %v\n", tx.Error)
+		return tx.Error
+	}
+
+	if err := tx.Where("subscription_id = ?", subscription.ID).Delete(&models.Token{}).Error; err != nil {
+		fmt.Printf("[ERROR] Error deleting tokens: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(subscription).Error; err != nil {
+		fmt.Printf("[ERROR] Error deleting subscription: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	if err := recordConsent(tx, subscription, models.ConsentEventUnsubscribe, meta); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		fmt.Printf("[ERROR] Error committing transaction: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("[DEBUG] Deleted subscription %d of %s\n", subscription.ID, subscription.Email)
+	return nil
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"weatherapi.app/models"
+)
+
+// setPaused pauses or resumes a subscription. A paused subscription with an end time is
+// resumed by the repository once that time has passed.
+func setPaused(subscription *models.Subscription, paused bool, until *time.Time) error {
+	if paused && until != nil && !until.After(time.Now()) {
+		return fmt.Errorf("pause end must be in the future")
+	}
+
+	subscription.Paused = paused
+	subscription.PausedUntil = nil
+	if paused {
+		subscription.PausedUntil = until
+	}
+	return nil
+}
+
+// PauseSubscription stops all emails of a subscription, until a time when given
+func (s *SubscriptionService) PauseSubscription(tokenStr string, until *time.Time) (*models.Subscription, error) {
+	fmt.Printf("[DEBUG] PauseSubscription called with until: %v\n", until)
+
+	subscription, err := s.unsubscribeTokenSubscription(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setPaused(subscription, true, until); err != nil {
+		return nil, err
+	}
+
+	if err := s.subscriptionRepo.Update(subscription); err != nil {
+		fmt.Printf("[ERROR] Error pausing subscription: %v\n", err)
+		return nil, err
+	}
+
+	fmt.Printf("[DEBUG] Paused subscription %d\n", subscription.ID)
+	return subscription, nil
+}
+
+// ResumeSubscription resumes a paused subscription
+func (s *SubscriptionService) ResumeSubscription(tokenStr string) (*models.Subscription, error) {
+	fmt.Println("[DEBUG] ResumeSubscription called")
+
+	subscription, err := s.unsubscribeTokenSubscription(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setPaused(subscription, false, nil); err != nil {
+		return nil, err
+	}
+
+	if err := s.subscriptionRepo.Update(subscription); err != nil {
+		fmt.Printf("[ERROR] Error resuming subscription: %v\n", err)
+		return nil, err
+	}
+
+	fmt.Printf("[DEBUG] Resumed subscription %d\n", subscription.ID)
+	return subscription, nil
+}
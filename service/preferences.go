@@ -0,0 +1,145 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"weatherapi.app/models"
+)
+
+// changeTokenTTL is how long a preference change can be confirmed
+const changeTokenTTL = 24 * time.Hour
+
+// preferenceChange returns the preferences requested by a subscription request
+func preferenceChange(req *models.SubscriptionRequest, options models.WeatherOptions) *models.PreferenceChange {
+	return &models.PreferenceChange{
+		Frequency:  req.Frequency,
+		Units:      options.Units,
+		Language:   options.Language,
+		AirQuality: req.AirQuality,
+		Rules:      req.Rules,
+	}
+}
+
+// hasPreferences reports whether a subscription already has the preferences of a change
+func (s *SubscriptionService) hasPreferences(subscription *models.Subscription, change *models.PreferenceChange) (bool, error) {
+	if subscription.Frequency != change.Frequency || subscription.Units != change.Units ||
+		subscription.Language != change.Language || subscription.AirQuality != change.AirQuality {
+		return false, nil
+	}
+	if change.Rules == nil {
+		return true, nil
+	}
+
+	var rules []models.NotificationRule
+	if err := s.db.Where("subscription_id = ?", subscription.ID).Order("id").Find(&rules).Error; err != nil {
+		fmt.Printf("[ERROR] Error loading notification rules: %v\n", err)
+		return false, err
+	}
+
+	requested := rulesFromRequest(subscription.ID, change.Rules)
+	if len(rules) != len(requested) {
+		return false, nil
+	}
+	for i := range rules {
+		if rules[i].Metric != requested[i].Metric || rules[i].Operator != requested[i].Operator ||
+			rules[i].Threshold != requested[i].Threshold || rules[i].Day != requested[i].Day {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// requestPreferenceChange stores a change of a confirmed subscription in a change token and
+// emails the link that applies it, so nobody can change someone else's subscription. Like
+// confirmation links, another change can only be requested after the configured cooldown.
+func (s *SubscriptionService) requestPreferenceChange(subscription *models.Subscription, change *models.PreferenceChange) error {
+	fmt.Printf("[DEBUG] Requesting preference change for subscription %d: %+v\n", subscription.ID, change)
+
+	if err := s.checkResendCooldown(subscription, models.TokenTypeChange); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	token, err := s.tokenRepo.CreateTokenWithPayload(subscription.ID, models.TokenTypeChange, string(payload), changeTokenTTL)
+	if err != nil {
+		fmt.Printf("[ERROR] Error creating change token: %v\n", err)
+		return err
+	}
+
+	confirmURL := fmt.Sprintf("%s/api/confirm/%s", s.config.AppBaseURL, token.Plaintext)
+	if err := s.emailService.SendChangeConfirmationEmail(subscription.Email, confirmURL, subscription.City, change); err != nil {
+		fmt.Printf("[ERROR] Failed to send change confirmation email: %v\n", err)
+		return fmt.Errorf("failed to send confirmation email: %w", err)
+	}
+
+	return nil
+}
+
+// confirmPreferenceChange applies the preference change stored in a change token
+func (s *SubscriptionService) confirmPreferenceChange(token *models.Token) error {
+	var change models.PreferenceChange
+	if err := json.Unmarshal([]byte(token.Payload), &change); err != nil {
+		fmt.Printf("[ERROR] Invalid change token payload: %v\n", err)
+		return fmt.Errorf("invalid token type")
+	}
+
+	subscription, err := s.subscriptionRepo.FindByID(token.SubscriptionID)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding subscription: %v\n", err)
+		return err
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		fmt.Printf("[ERROR] Error beginning transaction: %v\n", tx.Error)
+		return tx.Error
+	}
+
+	err = tx.Model(subscription).Updates(map[string]interface{}{
+		"frequency":   change.Frequency,
+		"units":       change.Units,
+		"language":    change.Language,
+		"air_quality": change.AirQuality,
+	}).Error
+	if err != nil {
+		fmt.Printf("[ERROR] Error updating subscription preferences: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+
+	if change.Rules != nil {
+		if err := tx.Where("subscription_id = ?", subscription.ID).Delete(&models.NotificationRule{}).Error; err != nil {
+			fmt.Printf("[ERROR] Error deleting notification rules: %v\n", err)
+			tx.Rollback()
+			return err
+		}
+		if rules := rulesFromRequest(subscription.ID, change.Rules); len(rules) > 0 {
+			if err := tx.Create(&rules).Error; err != nil {
+				fmt.Printf("[ERROR] Error creating notification rules: %v\n", err)
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	// Older pending changes of the subscription would undo this one
+	if err := tx.Where("subscription_id = ? AND type = ?", subscription.ID, models.TokenTypeChange).Delete(&models.Token{}).Error; err != nil {
+		fmt.Printf("[ERROR] Error deleting change tokens: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		fmt.Printf("[ERROR] Error committing transaction: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("[DEBUG] Applied preference change to subscription %d\n", subscription.ID)
+	return nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"weatherapi.app/models"
+	"weatherapi.app/tokens"
+)
+
+// privacyTokenTTL is how long a data export or erasure link stays valid
+const privacyTokenTTL = time.Hour
+
+// privacyPurposes maps the actions of the privacy page to the purpose of their tokens
+var privacyPurposes = map[string]string{
+	models.PrivacyActionExport: tokens.PurposeExport,
+	models.PrivacyActionErase:  tokens.PurposeErase,
+}
+
+// privacyURL is the privacy page; without a token it asks for an address to email a link to
+func (s *SubscriptionService) privacyURL(action, token string) string {
+	if token == "" {
+		return fmt.Sprintf("%s/privacy", s.config.AppBaseURL)
+	}
+	return fmt.Sprintf("%s/privacy?action=%s&token=%s", s.config.AppBaseURL, action, token)
+}
+
+// RequestPrivacyLink emails a link to export or erase all data held about an address, at most
+// once per action and resend cooldown. It is only sent to addresses we hold data about, without
+// telling the caller, so the endpoint does not reveal who is subscribed. Addresses suppressed by
+// an administrator get the link too, as the suppression list stops weather emails rather than
+// answers to a data protection request; addresses that bounced or complained get nothing.
+func (s *SubscriptionService) RequestPrivacyLink(email, action string) error {
+	fmt.Printf("[DEBUG] RequestPrivacyLink called for: %s, action: %s\n", email, action)
+
+	purpose, ok := privacyPurposes[action]
+	if !ok {
+		return fmt.Errorf("invalid privacy action")
+	}
+
+	data, err := s.privacyRepo.Export(email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding data of %s: %v\n", email, err)
+		return err
+	}
+	if !data.HasData() {
+		fmt.Println("[DEBUG] No data found, not sending a privacy link")
+		return nil
+	}
+	if data.Suppression != nil && data.Suppression.Source != models.SuppressionSourceAdmin {
+		fmt.Printf("[DEBUG] Address is suppressed after a %s, not sending a privacy link\n", data.Suppression.Source)
+		return nil
+	}
+	if claimed, err := s.claimLinkSend(data.Email, purpose); err != nil || !claimed {
+		return err
+	}
+
+	// Signed like a management link, with the newest token version of the address
+	signed := models.Subscription{Email: data.Email}
+	for _, subscription := range data.Subscriptions {
+		if subscription.TokenVersion > signed.TokenVersion {
+			signed.TokenVersion = subscription.TokenVersion
+		}
+	}
+	token, err := s.signToken(&signed, purpose, privacyTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := s.emailService.SendPrivacyLinkEmail(data.Email, action, s.privacyURL(action, token)); err != nil {
+		fmt.Printf("[ERROR] Failed to send privacy link email: %v\n", err)
+		return fmt.Errorf("failed to send privacy link email: %w", err)
+	}
+
+	return nil
+}
+
+// privacyTokenData verifies a data export or erasure token and returns the data held about
+// its address. The token is rejected once the token version of any subscription of the
+// address has been bumped.
+func (s *SubscriptionService) privacyTokenData(tokenStr, purpose string) (*models.PersonalData, error) {
+	claims, err := s.verifyToken(tokenStr, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.privacyRepo.Export(claims.Email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error exporting data of %s: %v\n", claims.Email, err)
+		return nil, err
+	}
+	for _, subscription := range data.Subscriptions {
+		if subscription.TokenVersion > claims.Version {
+			fmt.Printf("[ERROR] Token no longer valid for subscription %d\n", subscription.ID)
+			return nil, fmt.Errorf("record not found")
+		}
+	}
+
+	return data, nil
+}
+
+// ExportPersonalData returns everything stored about the address of a data export token
+func (s *SubscriptionService) ExportPersonalData(tokenStr string) (*models.PersonalData, error) {
+	fmt.Println("[DEBUG] ExportPersonalData called")
+
+	return s.privacyTokenData(tokenStr, tokens.PurposeExport)
+}
+
+// ErasePersonalData erases everything stored about the address of an erasure token. Erasing
+// an address twice is not an error; the second time there is nothing left to erase.
+func (s *SubscriptionService) ErasePersonalData(tokenStr string) (*models.ErasureResult, error) {
+	fmt.Println("[DEBUG] ErasePersonalData called")
+
+	data, err := s.privacyTokenData(tokenStr, tokens.PurposeErase)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.privacyRepo.Erase(data.Email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error erasing data: %v\n", err)
+		return nil, err
+	}
+
+	return result, nil
+}
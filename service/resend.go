@@ -0,0 +1,86 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"weatherapi.app/models"
+)
+
+// ResendConfirmation replaces the confirmation link of an unconfirmed subscription and emails
+// the new one. Without a frequency it resends the link of a weather update subscription.
+// Another link can only be requested after the configured cooldown.
+func (s *SubscriptionService) ResendConfirmation(email, city, frequency string) error {
+	fmt.Printf("[DEBUG] ResendConfirmation called for: %s, city: %s, frequency: %s\n", email, city, frequency)
+
+	subscription, err := s.subscriptionRepo.FindByEmail(email, city, frequency)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding subscription: %v\n", err)
+		return err
+	}
+	if subscription == nil {
+		return fmt.Errorf("record not found")
+	}
+	if subscription.Confirmed {
+		return fmt.Errorf("email already subscribed")
+	}
+	if s.isSuppressed(subscription) {
+		return fmt.Errorf("email address is suppressed")
+	}
+
+	if err := s.checkResendCooldown(subscription, models.TokenTypeConfirmation); err != nil {
+		return err
+	}
+
+	// Only the newest link confirms the subscription
+	if err := s.tokenRepo.DeleteBySubscription(subscription.ID, models.TokenTypeConfirmation); err != nil {
+		fmt.Printf("[ERROR] Error deleting old confirmation tokens: %v\n", err)
+		return err
+	}
+
+	token, err := s.tokenRepo.CreateToken(subscription.ID, models.TokenTypeConfirmation, 24*time.Hour)
+	if err != nil {
+		fmt.Printf("[ERROR] Error creating token: %v\n", err)
+		return err
+	}
+
+	confirmURL := fmt.Sprintf("%s/api/confirm/%s", s.config.AppBaseURL, token.Plaintext)
+	if err := s.emailService.SendConfirmationEmail(subscription.Email, confirmURL, subscription.City); err != nil {
+		fmt.Printf("[ERROR] Failed to send confirmation email: %v\n", err)
+		return fmt.Errorf("failed to send confirmation email: %w", err)
+	}
+
+	fmt.Printf("[DEBUG] Resent confirmation for subscription %d\n", subscription.ID)
+	return nil
+}
+
+// checkResendCooldown returns an error when the latest confirmation or change link of a
+// subscription was sent within the configured cooldown
+func (s *SubscriptionService) checkResendCooldown(subscription *models.Subscription, tokenType string) error {
+	latest, err := s.tokenRepo.FindLatest(subscription.ID, tokenType)
+	if err != nil {
+		return err
+	}
+	cooldown := time.Duration(s.config.ResendCooldown) * time.Minute
+	if latest != nil && time.Since(latest.CreatedAt) < cooldown {
+		fmt.Printf("[DEBUG] %s link for subscription %d was sent at %v, not resending yet\n", tokenType, subscription.ID, latest.CreatedAt)
+		return fmt.Errorf("confirmation resent too recently")
+	}
+	return nil
+}
+
+// claimLinkSend reports whether a link of a purpose may be emailed to an address now, and
+// records the send when it may. Management and privacy links are sent to any address that
+// asks, so each address gets at most one of each per cooldown.
+func (s *SubscriptionService) claimLinkSend(email, purpose string) (bool, error) {
+	cooldown := time.Duration(s.config.ResendCooldown) * time.Minute
+	claimed, err := s.linkRepo.Claim(email, purpose, cooldown)
+	if err != nil {
+		fmt.Printf("[ERROR] Error recording %s link for %s: %v\n", purpose, email, err)
+		return false, err
+	}
+	if !claimed {
+		fmt.Printf("[DEBUG] A %s link was sent to %s recently, not sending another yet\n", purpose, email)
+	}
+	return claimed, nil
+}
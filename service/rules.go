@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+
+	"weatherapi.app/models"
+)
+
+// ruleForecastDays is how many forecast days are fetched to evaluate rules (today and the next two days)
+const ruleForecastDays = 3
+
+// ruleMetricValue returns the forecast value a rule metric refers to
+func ruleMetricValue(metric string, day models.ForecastDay) (float64, bool) {
+	switch metric {
+	case models.RuleMetricMinTemp:
+		return day.MinTemp, true
+	case models.RuleMetricMaxTemp:
+		return day.MaxTemp, true
+	case models.RuleMetricRainChance:
+		return float64(day.ChanceOfRain), true
+	case models.RuleMetricSnowChance:
+		return float64(day.ChanceOfSnow), true
+	case models.RuleMetricMaxWind:
+		return day.MaxWind, true
+	case models.RuleMetricTotalPrecip:
+		return day.TotalPrecip, true
+	case models.RuleMetricUV:
+		return day.UVIndex, true
+	default:
+		return 0, false
+	}
+}
+
+// evaluateRule reports whether the rule's condition holds for the forecast day,
+// along with the forecast value the threshold was compared against
+func evaluateRule(rule models.NotificationRule, day models.ForecastDay) (bool, float64) {
+	value, ok := ruleMetricValue(rule.Metric, day)
+	if !ok {
+		return false, 0
+	}
+
+	switch rule.Operator {
+	case models.RuleOperatorLessThan:
+		return value < rule.Threshold, value
+	case models.RuleOperatorLessOrEqual:
+		return value <= rule.Threshold, value
+	case models.RuleOperatorGreaterThan:
+		return value > rule.Threshold, value
+	case models.RuleOperatorGreaterOrEqual:
+		return value >= rule.Threshold, value
+	default:
+		return false, value
+	}
+}
+
+// formatRuleValue formats a value of a rule metric with its unit, e.g. "-2.0°C" or "70%"
+func formatRuleValue(metric string, value float64, units string) string {
+	labels := labelsForUnits(units)
+
+	switch metric {
+	case models.RuleMetricMinTemp, models.RuleMetricMaxTemp:
+		return fmt.Sprintf("%.1f%s", value, labels.Temperature)
+	case models.RuleMetricRainChance, models.RuleMetricSnowChance:
+		return fmt.Sprintf("%.0f%%", value)
+	case models.RuleMetricMaxWind:
+		return fmt.Sprintf("%.1f %s", value, labels.Speed)
+	case models.RuleMetricTotalPrecip:
+		return fmt.Sprintf("%.1f %s", value, labels.Precipitation)
+	default:
+		return fmt.Sprintf("%.1f", value)
+	}
+}
+
+// describeRule explains a rule in words, e.g. "tomorrow's low temperature is below 0.0°C"
+func describeRule(rule models.NotificationRule, units string) string {
+	day := "today's"
+	switch rule.Day {
+	case 1:
+		day = "tomorrow's"
+	case 2:
+		day = "the day after tomorrow's"
+	}
+
+	metric := map[string]string{
+		models.RuleMetricMinTemp:     "low temperature",
+		models.RuleMetricMaxTemp:     "high temperature",
+		models.RuleMetricRainChance:  "chance of rain",
+		models.RuleMetricSnowChance:  "chance of snow",
+		models.RuleMetricMaxWind:     "maximum wind speed",
+		models.RuleMetricTotalPrecip: "total precipitation",
+		models.RuleMetricUV:          "UV index",
+	}[rule.Metric]
+
+	operator := map[string]string{
+		models.RuleOperatorLessThan:       "below",
+		models.RuleOperatorLessOrEqual:    "at or below",
+		models.RuleOperatorGreaterThan:    "above",
+		models.RuleOperatorGreaterOrEqual: "at or above",
+	}[rule.Operator]
+
+	return fmt.Sprintf("%s %s is %s %s", day, metric, operator, formatRuleValue(rule.Metric, rule.Threshold, units))
+}
+
+// EvaluateNotificationRules checks the rules of confirmed subscriptions against the forecast
+// and emails subscribers whose rules fired. Each rule fires at most once per forecast day.
+func (s *SubscriptionService) EvaluateNotificationRules() error {
+	fmt.Println("[DEBUG] EvaluateNotificationRules called")
+
+	rules, err := s.ruleRepo.GetRulesForEvaluation()
+	if err != nil {
+		fmt.Printf("[ERROR] Error getting notification rules: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("[DEBUG] Found %d notification rules\n", len(rules))
+
+	var subscriptionIDs []uint
+	rulesBySubscription := make(map[uint][]models.NotificationRule)
+	for _, rule := range rules {
+		if _, ok := rulesBySubscription[rule.SubscriptionID]; !ok {
+			subscriptionIDs = append(subscriptionIDs, rule.SubscriptionID)
+		}
+		rulesBySubscription[rule.SubscriptionID] = append(rulesBySubscription[rule.SubscriptionID], rule)
+	}
+
+	forecasts := make(map[string]*models.Forecast)
+
+	for _, subscriptionID := range subscriptionIDs {
+		subscriptionRules := rulesBySubscription[subscriptionID]
+		subscription := subscriptionRules[0].Subscription
+
+		location := subscription.LocationQuery()
+		options := subscription.WeatherOptions()
+		cacheKey := location.Query() + "|" + options.Units + "|" + options.Language
+
+		forecast, ok := forecasts[cacheKey]
+		if !ok {
+			forecast, err = s.weatherService.GetForecast(location, options, ruleForecastDays)
+			if err != nil {
+				fmt.Printf("[ERROR] Error getting forecast for %s: %v\n", subscription.City, err)
+				continue
+			}
+			forecasts[cacheKey] = forecast
+		}
+
+		var triggered []models.TriggeredRule
+		for _, rule := range subscriptionRules {
+			if rule.Day >= len(forecast.Days) {
+				continue
+			}
+			day := forecast.Days[rule.Day]
+			if rule.LastTriggeredFor == day.Date {
+				continue
+			}
+			if fired, value := evaluateRule(rule, day); fired {
+				triggered = append(triggered, models.TriggeredRule{Rule: rule, Day: day, Value: value})
+			}
+		}
+
+		if len(triggered) == 0 {
+			continue
+		}
+
+		unsubscribeURL, err := s.unsubscribeURL(&subscription)
+		if err != nil {
+			continue
+		}
+
+		err = s.emailService.SendRuleTriggeredEmail(subscription.Email, subscription.City, triggered, forecast.Units, unsubscribeURL)
+		if err != nil {
+			// Not marked as triggered, so it is retried on the next evaluation
+			fmt.Printf("[WARNING] Error sending rule notification email, will retry: %v\n", err)
+			continue
+		}
+
+		for _, t := range triggered {
+			if err := s.ruleRepo.MarkTriggered(t.Rule.ID, t.Day.Date); err != nil {
+				fmt.Printf("[ERROR] Error marking rule %d as triggered: %v\n", t.Rule.ID, err)
+			}
+		}
+
+		fmt.Printf("[DEBUG] Sent %d rule notifications to: %s\n", len(triggered), subscription.Email)
+	}
+
+	fmt.Println("[DEBUG] EvaluateNotificationRules completed")
+	return nil
+}
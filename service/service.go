@@ -4,34 +4,64 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"gorm.io/gorm"
 	"weatherapi.app/config"
 	"weatherapi.app/models"
+	"weatherapi.app/tokens"
 )
 
+// maxCitySearchCacheEntries bounds the in-memory city search cache
+const maxCitySearchCacheEntries = 1000
+
+type citySearchCacheEntry struct {
+	results   []models.CitySearchResult
+	expiresAt time.Time
+}
+
 type WeatherService struct {
 	config    *config.Config
 	client    *http.Client
+
+	searchCacheMu sync.Mutex
+	searchCache   map[string]citySearchCacheEntry
 }
 
 func NewWeatherService(config *config.Config) *WeatherService {
 	return &WeatherService{
-		config: config,
-		client: &http.Client{Timeout: 10 * time.Second},
+		config:      config,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		searchCache: make(map[string]citySearchCacheEntry),
 	}
 }
 
-func (s *WeatherService) GetWeather(city string) (*models.WeatherResponse, error) {
-	fmt.Printf("[DEBUG] WeatherService.GetWeather called for city: %s\n", city)
+func (s *WeatherService) GetWeather(location models.LocationQuery, options models.WeatherOptions) (*models.WeatherResponse, error) {
+	fmt.Printf("[DEBUG] WeatherService.GetWeather called for %s location: %s, options: %+v\n",
+		location.Type, location.Query(), options)
 	
-	url := fmt.Sprintf("%s/current.json?key=%s&q=%s&aqi=no", 
-		s.config.Weather.BaseURL, s.config.Weather.APIKey, city)
+	if err := location.Validate(); err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("key", s.config.Weather.APIKey)
+	params.Set("q", location.Query())
+	params.Set("aqi", "no")
+	if options.AirQuality {
+		params.Set("aqi", "yes")
+	}
+	if options.Language != "" && options.Language != models.DefaultLanguage {
+		params.Set("lang", options.Language)
+	}
+	requestURL := fmt.Sprintf("%s/current.json?%s", s.config.Weather.BaseURL, params.Encode())
 	
-	fmt.Printf("[DEBUG] Making request to Weather API: %s\n", url)
+	fmt.Printf("[DEBUG] Making request to Weather API for q=%s\n", location.Query())
 
-	resp, err := s.client.Get(url)
+	resp, err := s.client.Get(requestURL)
 	if err != nil {
 		fmt.Printf("[ERROR] Failed to get weather data: %v\n", err)
 		return nil, fmt.Errorf("failed to get weather data: %w", err)
@@ -40,50 +70,124 @@ func (s *WeatherService) GetWeather(city string) (*models.WeatherResponse, error
 
 	fmt.Printf("[DEBUG] Weather API response status: %d\n", resp.StatusCode)
 	
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("city not found")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("weather API returned status code %d", resp.StatusCode)
+	if err := checkWeatherAPIStatus(resp); err != nil {
+		return nil, err
 	}
 
-	var result map[string]interface{}
+	var result weatherAPICurrentResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		fmt.Printf("[ERROR] Failed to decode weather data: %v\n", err)
 		return nil, fmt.Errorf("failed to decode weather data: %w", err)
 	}
 
-	fmt.Printf("[DEBUG] Weather API raw response: %+v\n", result)
-	
-	current, ok := result["current"].(map[string]interface{})
-	if !ok {
-		fmt.Printf("[ERROR] Invalid weather data format, 'current' field not found or wrong type\n")
+	if result.Current == nil {
+		fmt.Printf("[ERROR] Invalid weather data format, 'current' field not found\n")
 		return nil, fmt.Errorf("invalid weather data format")
 	}
 
-	weatherCondition, ok := current["condition"].(map[string]interface{})
+	weather := result.toWeatherResponse(options.Units)
+
+	fmt.Printf("[DEBUG] Parsed weather data: %+v\n", weather)
+	return weather, nil
+}
+
+// SearchCities looks up locations matching the query using the provider's search API.
+// Results are cached in memory for the configured TTL.
+func (s *WeatherService) SearchCities(query string) ([]models.CitySearchResult, error) {
+	fmt.Printf("[DEBUG] WeatherService.SearchCities called with query: %s\n", query)
+
+	key := strings.ToLower(strings.TrimSpace(query))
+	if cached, ok := s.getCachedSearch(key); ok {
+		fmt.Printf("[DEBUG] City search cache hit for query: %s\n", key)
+		return cached, nil
+	}
+
+	params := url.Values{}
+	params.Set("key", s.config.Weather.APIKey)
+	params.Set("q", key)
+	requestURL := fmt.Sprintf("%s/search.json?%s", s.config.Weather.BaseURL, params.Encode())
+
+	resp, err := s.client.Get(requestURL)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to search cities: %v\n", err)
+		return nil, fmt.Errorf("failed to search cities: %w", err)
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("[DEBUG] Weather API search response status: %d\n", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("weather API returned status code %d", resp.StatusCode)
+	}
+
+	results := []models.CitySearchResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		fmt.Printf("[ERROR] Failed to decode city search results: %v\n", err)
+		return nil, fmt.Errorf("failed to decode city search results: %w", err)
+	}
+
+	s.setCachedSearch(key, results)
+
+	fmt.Printf("[DEBUG] Found %d cities for query: %s\n", len(results), key)
+	return results, nil
+}
+
+func (s *WeatherService) getCachedSearch(key string) ([]models.CitySearchResult, bool) {
+	s.searchCacheMu.Lock()
+	defer s.searchCacheMu.Unlock()
+
+	entry, ok := s.searchCache[key]
 	if !ok {
-		fmt.Printf("[ERROR] Invalid weather data format, 'condition' field not found or wrong type\n")
-		return nil, fmt.Errorf("invalid weather data format")
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(s.searchCache, key)
+		return nil, false
 	}
+	return entry.results, true
+}
 
-	weather := &models.WeatherResponse{
-		Temperature: current["temp_c"].(float64),
-		Humidity:    current["humidity"].(float64),
-		Description: weatherCondition["text"].(string),
+func (s *WeatherService) setCachedSearch(key string, results []models.CitySearchResult) {
+	ttl := time.Duration(s.config.Weather.SearchCacheTTL) * time.Minute
+	if ttl <= 0 {
+		return
 	}
 
-	fmt.Printf("[DEBUG] Parsed weather data: %+v\n", weather)
-	return weather, nil
+	s.searchCacheMu.Lock()
+	defer s.searchCacheMu.Unlock()
+
+	if len(s.searchCache) >= maxCitySearchCacheEntries {
+		now := time.Now()
+		for k, entry := range s.searchCache {
+			if now.After(entry.expiresAt) {
+				delete(s.searchCache, k)
+			}
+		}
+		// Still full of live entries: start over rather than grow without bound
+		if len(s.searchCache) >= maxCitySearchCacheEntries {
+			s.searchCache = make(map[string]citySearchCacheEntry)
+		}
+	}
+
+	s.searchCache[key] = citySearchCacheEntry{
+		results:   results,
+		expiresAt: time.Now().Add(ttl),
+	}
 }
 
 type SubscriptionService struct {
 	db               *gorm.DB
 	subscriptionRepo SubscriptionRepositoryInterface
 	tokenRepo        TokenRepositoryInterface
+	alertRepo        AlertRepositoryInterface
+	linkRepo         LinkRepositoryInterface
+	ruleRepo         RuleRepositoryInterface
+	suppressionRepo  SuppressionRepositoryInterface
+	consentRepo      ConsentRepositoryInterface
+	privacyRepo      PrivacyRepositoryInterface
 	emailService     EmailServiceInterface
 	weatherService   WeatherServiceInterface
+	signer           *tokens.Signer
 	config           *config.Config
 }
 
@@ -91,6 +195,12 @@ func NewSubscriptionService(
 	db *gorm.DB,
 	subscriptionRepo SubscriptionRepositoryInterface,
 	tokenRepo TokenRepositoryInterface,
+	alertRepo AlertRepositoryInterface,
+	linkRepo LinkRepositoryInterface,
+	ruleRepo RuleRepositoryInterface,
+	suppressionRepo SuppressionRepositoryInterface,
+	consentRepo ConsentRepositoryInterface,
+	privacyRepo PrivacyRepositoryInterface,
 	emailService EmailServiceInterface,
 	weatherService WeatherServiceInterface,
 	config *config.Config,
@@ -99,16 +209,44 @@ func NewSubscriptionService(
 		db:               db,
 		subscriptionRepo: subscriptionRepo,
 		tokenRepo:        tokenRepo,
+		alertRepo:        alertRepo,
+		linkRepo:         linkRepo,
+		ruleRepo:         ruleRepo,
+		suppressionRepo:  suppressionRepo,
+		consentRepo:      consentRepo,
+		privacyRepo:      privacyRepo,
 		emailService:     emailService,
 		weatherService:   weatherService,
+		signer:           newTokenSigner(config),
 		config:           config,
 	}
 }
 
-func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
+func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest, meta models.RequestMeta) error {
 	fmt.Printf("[DEBUG] SubscriptionService.Subscribe called with: %+v\n", req)
 	
-	existing, err := s.subscriptionRepo.FindByEmail(req.Email, req.City)
+	// Subscriptions created by coordinates are labelled with the coordinates themselves
+	city := req.City
+	if city == "" && req.Latitude != nil && req.Longitude != nil {
+		city = models.NewCoordinatesLocation(*req.Latitude, *req.Longitude).Query()
+	}
+	
+	options := models.NewWeatherOptions(req.Units, req.Language)
+	if !models.IsSupportedLanguage(options.Language) {
+		return fmt.Errorf("unsupported language")
+	}
+	
+	suppressed, err := s.suppressionRepo.IsSuppressed(req.Email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error checking suppression list: %v\n", err)
+		return err
+	}
+	if suppressed {
+		fmt.Printf("[DEBUG] Refusing to subscribe suppressed email: %s\n", req.Email)
+		return fmt.Errorf("email address is suppressed")
+	}
+	
+	existing, err := s.subscriptionRepo.FindByEmail(req.Email, city, req.Frequency)
 	if err != nil {
 		fmt.Printf("[ERROR] Error checking existing subscription: %v\n", err)
 		return err
@@ -119,7 +257,21 @@ func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
 			existing, existing.Confirmed)
 		
 		if existing.Confirmed {
-			return fmt.Errorf("email already subscribed")
+			// A confirmed subscription can only be changed through an emailed confirmation link
+			change := preferenceChange(req, options)
+			same, err := s.hasPreferences(existing, change)
+			if err != nil {
+				return err
+			}
+			if same {
+				return fmt.Errorf("email already subscribed")
+			}
+			return s.requestPreferenceChange(existing, change)
+		}
+
+		// Subscribing again sends a new confirmation link, so it shares the resend cooldown
+		if err := s.checkResendCooldown(existing, models.TokenTypeConfirmation); err != nil {
+			return err
 		}
 	}
 
@@ -145,6 +297,9 @@ func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
 	if existing != nil {
 		subscription = existing
 		subscription.Frequency = req.Frequency
+		subscription.Units = options.Units
+		subscription.Language = options.Language
+		subscription.AirQuality = req.AirQuality
 		fmt.Printf("[DEBUG] Updating existing subscription to frequency: %s\n", req.Frequency)
 		
 		if err := tx1.Save(subscription).Error; err != nil {
@@ -152,12 +307,32 @@ func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
 			tx1.Rollback()
 			return err
 		}
+
+		// Replace the rules of the unconfirmed subscription with the requested ones
+		if err := tx1.Where("subscription_id = ?", subscription.ID).Delete(&models.NotificationRule{}).Error; err != nil {
+			fmt.Printf("[ERROR] Error deleting notification rules: %v\n", err)
+			tx1.Rollback()
+			return err
+		}
+		if rules := rulesFromRequest(subscription.ID, req.Rules); len(rules) > 0 {
+			if err := tx1.Create(&rules).Error; err != nil {
+				fmt.Printf("[ERROR] Error creating notification rules: %v\n", err)
+				tx1.Rollback()
+				return err
+			}
+		}
 	} else {
 		subscription = &models.Subscription{
-			Email:     req.Email,
-			City:      req.City,
-			Frequency: req.Frequency,
-			Confirmed: false,
+			Email:      req.Email,
+			City:       city,
+			Latitude:   req.Latitude,
+			Longitude:  req.Longitude,
+			Frequency:  req.Frequency,
+			Units:      options.Units,
+			Language:   options.Language,
+			AirQuality: req.AirQuality,
+			Confirmed:  false,
+			Rules:      rulesFromRequest(0, req.Rules),
 		}
 		fmt.Printf("[DEBUG] Creating new subscription: %+v\n", subscription)
 		
@@ -167,6 +342,11 @@ func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
 			return err
 		}
 	}
+
+	if err := recordConsent(tx1, subscription, models.ConsentEventSubscribe, meta); err != nil {
+		tx1.Rollback()
+		return err
+	}
 	
 	// Important: Commit first transaction to ensure subscription is saved
 	fmt.Println("[DEBUG] Committing transaction 1")
@@ -204,14 +384,23 @@ func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
 	fmt.Printf("[DEBUG] Refreshed subscription: %+v\n", refreshedSubscription)
 	fmt.Printf("[DEBUG] Creating confirmation token for subscription ID: %d\n", refreshedSubscription.ID)
 	
-	token, err := s.tokenRepo.CreateToken(refreshedSubscription.ID, "confirmation", 24*time.Hour)
+	// Only the newest link confirms the subscription
+	if existing != nil {
+		if err := s.tokenRepo.DeleteBySubscription(refreshedSubscription.ID, models.TokenTypeConfirmation); err != nil {
+			fmt.Printf("[ERROR] Error deleting old confirmation tokens: %v\n", err)
+			tx2.Rollback()
+			return err
+		}
+	}
+
+	token, err := s.tokenRepo.CreateToken(refreshedSubscription.ID, models.TokenTypeConfirmation, 24*time.Hour)
 	if err != nil {
 		fmt.Printf("[ERROR] Error creating token: %v\n", err)
 		tx2.Rollback()
 		return err
 	}
 	
-	fmt.Printf("[DEBUG] Created token: %s, expires: %v\n", token.Token, token.ExpiresAt)
+	fmt.Printf("[DEBUG] Created token ID: %d, expires: %v\n", token.ID, token.ExpiresAt)
 
 	fmt.Println("[DEBUG] Committing transaction 2")
 	if err := tx2.Commit().Error; err != nil {
@@ -219,8 +408,8 @@ func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
 		return err
 	}
 
-	confirmURL := fmt.Sprintf("%s/api/confirm/%s", s.config.AppBaseURL, token.Token)
-	fmt.Printf("[DEBUG] Would send confirmation email to: %s with URL: %s\n", refreshedSubscription.Email, confirmURL)
+	confirmURL := fmt.Sprintf("%s/api/confirm/%s", s.config.AppBaseURL, token.Plaintext)
+	fmt.Printf("[DEBUG] Sending confirmation email to: %s\n", refreshedSubscription.Email)
 	
 	// Attempt to send confirmation email and return error if it fails
 	err = s.emailService.SendConfirmationEmail(refreshedSubscription.Email, confirmURL, refreshedSubscription.City)
@@ -233,8 +422,26 @@ func (s *SubscriptionService) Subscribe(req *models.SubscriptionRequest) error {
 	return nil
 }
 
-func (s *SubscriptionService) ConfirmSubscription(tokenStr string) error {
-	fmt.Printf("[DEBUG] ConfirmSubscription called with token: %s\n", tokenStr)
+// rulesFromRequest converts requested notification rules into rules of the subscription
+func rulesFromRequest(subscriptionID uint, requested []models.RuleRequest) []models.NotificationRule {
+	var rules []models.NotificationRule
+	for _, r := range requested {
+		rule := models.NotificationRule{
+			SubscriptionID: subscriptionID,
+			Metric:         r.Metric,
+			Operator:       r.Operator,
+			Day:            r.Day,
+		}
+		if r.Threshold != nil {
+			rule.Threshold = *r.Threshold
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (s *SubscriptionService) ConfirmSubscription(tokenStr string, meta models.RequestMeta) error {
+	fmt.Println("[DEBUG] ConfirmSubscription called")
 	
 	token, err := s.tokenRepo.FindByToken(tokenStr)
 	if err != nil {
@@ -242,7 +449,11 @@ func (s *SubscriptionService) ConfirmSubscription(tokenStr string) error {
 		return err
 	}
 	
-	fmt.Printf("[DEBUG] Found token: %+v\n", token)
+	fmt.Printf("[DEBUG] Found token ID: %d, type: %s\n", token.ID, token.Type)
+
+	if token.Type == models.TokenTypeChange {
+		return s.confirmPreferenceChange(token)
+	}
 
 	if token.Type != "confirmation" {
 		fmt.Printf("[ERROR] Invalid token type: %s\n", token.Type)
@@ -273,6 +484,19 @@ func (s *SubscriptionService) ConfirmSubscription(tokenStr string) error {
 	
 	fmt.Printf("[DEBUG] Found subscription: %+v\n", subscription)
 
+	// The address may have been suppressed after the confirmation email was sent
+	suppressed, err := s.suppressionRepo.IsSuppressed(subscription.Email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error checking suppression list: %v\n", err)
+		tx.Rollback()
+		return err
+	}
+	if suppressed || subscription.Suppressed {
+		fmt.Printf("[DEBUG] Refusing to confirm suppressed email: %s\n", subscription.Email)
+		tx.Rollback()
+		return fmt.Errorf("email address is suppressed")
+	}
+
 	subscription.Confirmed = true
 	fmt.Println("[DEBUG] Setting subscription to confirmed")
 	
@@ -282,22 +506,17 @@ func (s *SubscriptionService) ConfirmSubscription(tokenStr string) error {
 		return err
 	}
 
-	fmt.Println("[DEBUG] Deleting confirmation token")
-	if err := tx.Delete(token).Error; err != nil {
-		fmt.Printf("[ERROR] Error deleting token: %v\n", err)
+	if err := recordConsent(tx, subscription, models.ConsentEventConfirm, meta); err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	fmt.Println("[DEBUG] Creating unsubscribe token")
-	unsubscribeToken, err := s.tokenRepo.CreateToken(subscription.ID, "unsubscribe", 365*24*time.Hour)
-	if err != nil {
-		fmt.Printf("[ERROR] Error creating unsubscribe token: %v\n", err)
+	fmt.Println("[DEBUG] Deleting confirmation token")
+	if err := tx.Delete(token).Error; err != nil {
+		fmt.Printf("[ERROR] Error deleting token: %v\n", err)
 		tx.Rollback()
 		return err
 	}
-	
-	fmt.Printf("[DEBUG] Created unsubscribe token: %s\n", unsubscribeToken.Token)
 
 	fmt.Println("[DEBUG] Committing transaction")
 	if err := tx.Commit().Error; err != nil {
@@ -305,9 +524,13 @@ func (s *SubscriptionService) ConfirmSubscription(tokenStr string) error {
 		return err
 	}
 
-	unsubscribeURL := fmt.Sprintf("%s/api/unsubscribe/%s", s.config.AppBaseURL, unsubscribeToken.Token)
-	fmt.Printf("[DEBUG] Would send welcome email to: %s with unsubscribe URL: %s\n", 
-		subscription.Email, unsubscribeURL)
+	// The subscription is confirmed, so a welcome email that cannot be sent is not an error
+	unsubscribeURL, err := s.unsubscribeURL(subscription)
+	if err != nil {
+		fmt.Printf("[WARNING] Not sending welcome email without an unsubscribe link: %v\n", err)
+		return nil
+	}
+	fmt.Printf("[DEBUG] Sending welcome email to: %s\n", subscription.Email)
 	
 	// Try to send email but don't fail if it doesn't work
 	err = s.emailService.SendWelcomeEmail(subscription.Email, subscription.City, subscription.Frequency, unsubscribeURL)
@@ -320,21 +543,15 @@ func (s *SubscriptionService) ConfirmSubscription(tokenStr string) error {
 	return nil
 }
 
-func (s *SubscriptionService) Unsubscribe(tokenStr string) error {
-	fmt.Printf("[DEBUG] Unsubscribe called with token: %s\n", tokenStr)
+func (s *SubscriptionService) Unsubscribe(tokenStr string, meta models.RequestMeta) error {
+	fmt.Println("[DEBUG] Unsubscribe called")
 	
-	token, err := s.tokenRepo.FindByToken(tokenStr)
+	subscription, err := s.unsubscribeTokenSubscription(tokenStr)
 	if err != nil {
-		fmt.Printf("[ERROR] Error finding token: %v\n", err)
 		return err
 	}
 	
-	fmt.Printf("[DEBUG] Found token: %+v\n", token)
-
-	if token.Type != "unsubscribe" {
-		fmt.Printf("[ERROR] Invalid token type: %s\n", token.Type)
-		return fmt.Errorf("invalid token type")
-	}
+	fmt.Printf("[DEBUG] Found subscription: %+v\n", subscription)
 
 	tx := s.db.Begin()
 	if tx.Error != nil {
@@ -351,25 +568,21 @@ func (s *SubscriptionService) Unsubscribe(tokenStr string) error {
 		}
 	}()
 
-	subscription, err := s.subscriptionRepo.FindByID(token.SubscriptionID)
-	if err != nil {
-		fmt.Printf("[ERROR] Error finding subscription: %v\n", err)
+	fmt.Println("[DEBUG] Deleting subscription")
+	if err := tx.Delete(subscription).Error; err != nil {
+		fmt.Printf("[ERROR] Error deleting subscription: %v\n", err)
 		tx.Rollback()
 		return err
 	}
-	
-	fmt.Printf("[DEBUG] Found subscription: %+v\n", subscription)
 
-	fmt.Println("[DEBUG] Deleting subscription")
-	if err := tx.Delete(subscription).Error; err != nil {
-		fmt.Printf("[ERROR] Error deleting subscription: %v\n", err)
+	if err := recordConsent(tx, subscription, models.ConsentEventUnsubscribe, meta); err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	fmt.Println("[DEBUG] Deleting token")
-	if err := tx.Delete(token).Error; err != nil {
-		fmt.Printf("[ERROR] Error deleting token: %v\n", err)
+	fmt.Println("[DEBUG] Deleting tokens")
+	if err := tx.Where("subscription_id = ?", subscription.ID).Delete(&models.Token{}).Error; err != nil {
+		fmt.Printf("[ERROR] Error deleting tokens: %v\n", err)
 		tx.Rollback()
 		return err
 	}
@@ -380,18 +593,94 @@ func (s *SubscriptionService) Unsubscribe(tokenStr string) error {
 		return err
 	}
 
-	fmt.Printf("[DEBUG] Would send unsubscribe confirmation email to: %s\n", subscription.Email)
-	// Try to send email but don't fail if it doesn't work
-	err = s.emailService.SendUnsubscribeConfirmationEmail(subscription.Email, subscription.City)
-	if err != nil {
-		fmt.Printf("[WARNING] Error sending unsubscribe confirmation email, but continuing anyway: %v\n", err)
-		// Don't return the error
+	if s.isSuppressed(subscription) {
+		fmt.Printf("[DEBUG] Not sending unsubscribe confirmation email to suppressed address: %s\n", subscription.Email)
+	} else {
+		fmt.Printf("[DEBUG] Would send unsubscribe confirmation email to: %s\n", subscription.Email)
+		// Try to send email but don't fail if it doesn't work
+		err = s.emailService.SendUnsubscribeConfirmationEmail(subscription.Email, subscription.City)
+		if err != nil {
+			fmt.Printf("[WARNING] Error sending unsubscribe confirmation email, but continuing anyway: %v\n", err)
+			// Don't return the error
+		}
 	}
 	
 	fmt.Println("[DEBUG] Unsubscribe process completed successfully")
 	return nil
 }
 
+// UnsubscribeAll unsubscribes the address of an unsubscribe token from every city
+func (s *SubscriptionService) UnsubscribeAll(tokenStr string, meta models.RequestMeta) error {
+	fmt.Println("[DEBUG] UnsubscribeAll called")
+
+	subscription, err := s.unsubscribeTokenSubscription(tokenStr)
+	if err != nil {
+		return err
+	}
+
+	subscriptions, err := s.subscriptionRepo.FindAllByEmail(subscription.Email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding subscriptions of %s: %v\n", subscription.Email, err)
+		return err
+	}
+
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		fmt.Printf("[ERROR] Error beginning transaction: %v\n", tx.Error)
+		return tx.Error
+	}
+
+	var cities []string
+	for i := range subscriptions {
+		if err := tx.Where("subscription_id = ?", subscriptions[i].ID).Delete(&models.Token{}).Error; err != nil {
+			fmt.Printf("[ERROR] Error deleting tokens: %v\n", err)
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Delete(&subscriptions[i]).Error; err != nil {
+			fmt.Printf("[ERROR] Error deleting subscription: %v\n", err)
+			tx.Rollback()
+			return err
+		}
+		if err := recordConsent(tx, &subscriptions[i], models.ConsentEventUnsubscribe, meta); err != nil {
+			tx.Rollback()
+			return err
+		}
+		cities = append(cities, subscriptions[i].City)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		fmt.Printf("[ERROR] Error committing transaction: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("[DEBUG] Unsubscribed %s from %d cities\n", subscription.Email, len(subscriptions))
+
+	if !s.isSuppressed(subscription) {
+		// Try to send email but don't fail if it doesn't work
+		if err := s.emailService.SendUnsubscribeConfirmationEmail(subscription.Email, joinCities(cities)); err != nil {
+			fmt.Printf("[WARNING] Error sending unsubscribe confirmation email, but continuing anyway: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+// isSuppressed reports whether no email may be sent to the subscription. When the suppression
+// list cannot be checked the address is treated as suppressed.
+func (s *SubscriptionService) isSuppressed(subscription *models.Subscription) bool {
+	if subscription.Suppressed {
+		return true
+	}
+	suppressed, err := s.suppressionRepo.IsSuppressed(subscription.Email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error checking suppression list for %s: %v\n", subscription.Email, err)
+		return true
+	}
+	return suppressed
+}
+
+// SendWeatherUpdate emails every subscriber of a frequency one digest covering all of their cities
 func (s *SubscriptionService) SendWeatherUpdate(frequency string) error {
 	fmt.Printf("[DEBUG] SendWeatherUpdate called for frequency: %s\n", frequency)
 	
@@ -403,45 +692,75 @@ func (s *SubscriptionService) SendWeatherUpdate(frequency string) error {
 	
 	fmt.Printf("[DEBUG] Found %d subscriptions for frequency: %s\n", len(subscriptions), frequency)
 
+	// Group by address, keeping the order subscriptions were returned in
+	var addresses []string
+	byAddress := make(map[string][]models.Subscription)
 	for _, subscription := range subscriptions {
-		fmt.Printf("[DEBUG] Processing subscription: %+v\n", subscription)
-		
-		weather, err := s.weatherService.GetWeather(subscription.City)
-		if err != nil {
-			fmt.Printf("[ERROR] Error getting weather for %s: %v\n", subscription.City, err)
-			continue
+		address := strings.ToLower(subscription.Email)
+		if _, ok := byAddress[address]; !ok {
+			addresses = append(addresses, address)
 		}
-		
-		fmt.Printf("[DEBUG] Got weather data: %+v\n", weather)
+		byAddress[address] = append(byAddress[address], subscription)
+	}
 
-		token, err := s.tokenRepo.FindByToken(fmt.Sprintf("%d", subscription.ID))
-		if err != nil {
-			fmt.Printf("[DEBUG] No existing token found, creating new one: %v\n", err)
-			token, err = s.tokenRepo.CreateToken(subscription.ID, "unsubscribe", 365*24*time.Hour)
+	weatherByLocation := make(map[string]*models.WeatherResponse)
+
+	for _, address := range addresses {
+		group := byAddress[address]
+		digest := &models.WeatherDigest{Frequency: frequency}
+
+		for i := range group {
+			subscription := &group[i]
+			fmt.Printf("[DEBUG] Processing subscription: %+v\n", subscription)
+
+			location := subscription.LocationQuery()
+			options := subscription.WeatherOptions()
+			cacheKey := fmt.Sprintf("%s|%s|%s|%t", location.Query(), options.Units, options.Language, options.AirQuality)
+
+			weather, ok := weatherByLocation[cacheKey]
+			if !ok {
+				weather, err = s.weatherService.GetWeather(location, options)
+				if err != nil {
+					fmt.Printf("[ERROR] Error getting weather for %s: %v\n", subscription.City, err)
+					continue
+				}
+				weatherByLocation[cacheKey] = weather
+			}
+
+			unsubscribeURL, err := s.unsubscribeURL(subscription)
 			if err != nil {
-				fmt.Printf("[ERROR] Error creating unsubscribe token for subscription %d: %v\n", subscription.ID, err)
 				continue
 			}
-			fmt.Printf("[DEBUG] Created new token: %s\n", token.Token)
+
+			digest.Cities = append(digest.Cities, models.DigestCity{
+				City:           subscription.City,
+				Weather:        weather,
+				UnsubscribeURL: unsubscribeURL,
+			})
+		}
+
+		if len(digest.Cities) == 0 {
+			continue
+		}
+		digest.UnsubscribeAllURL = digest.Cities[0].UnsubscribeURL + "?scope=all"
+
+		// Signed for the whole address, so the link manages its other subscriptions too
+		digest.ManageURL, err = s.addressManageURL(group[0].Email)
+		if err != nil {
+			fmt.Printf("[WARNING] Error signing manage link, linking the manage page instead: %v\n", err)
+			digest.ManageURL = s.manageURL("")
 		}
 
-		unsubscribeURL := fmt.Sprintf("%s/api/unsubscribe/%s", s.config.AppBaseURL, token.Token)
-		fmt.Printf("[DEBUG] Would send weather update to: %s with unsubscribe URL: %s\n", 
-			subscription.Email, unsubscribeURL)
+		fmt.Printf("[DEBUG] Would send weather update to: %s for %d cities\n", group[0].Email, len(digest.Cities))
 		
 		// Try to send email but don't fail if it doesn't work
-		err = s.emailService.SendWeatherUpdateEmail(
-			subscription.Email,
-			subscription.City,
-			weather,
-			unsubscribeURL,
-		)
+		err = s.emailService.SendWeatherUpdateEmail(group[0].Email, digest)
 		if err != nil {
 			fmt.Printf("[WARNING] Error sending weather update email, but continuing anyway: %v\n", err)
 			continue
 		}
 		
-		fmt.Printf("[DEBUG] Successfully sent weather update to: %s\n", subscription.Email)
+		fmt.Printf("[DEBUG] Successfully sent weather update to: %s\n", group[0].Email)
 	}
 	
 	fmt.Println("[DEBUG] SendWeatherUpdate completed")
@@ -1,17 +1,43 @@
 package service
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/emersion/go-msgauth/dkim"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"weatherapi.app/config"
 	"weatherapi.app/models"
+	"weatherapi.app/tokens"
 )
 
 // Simple test for the WeatherService
@@ -54,7 +80,7 @@ func TestWeatherService_GetWeather(t *testing.T) {
 
 	// Create the service and call GetWeather
 	weatherService := NewWeatherService(cfg)
-	weather, err := weatherService.GetWeather("London")
+	weather, err := weatherService.GetWeather(models.NewCityLocation("London"), models.NewWeatherOptions("", ""))
 
 	// Assert the results
 	assert.NoError(t, err)
@@ -82,7 +108,7 @@ func TestWeatherService_GetWeather_CityNotFound(t *testing.T) {
 
 	// Create the service and call GetWeather with a non-existent city
 	weatherService := NewWeatherService(cfg)
-	weather, err := weatherService.GetWeather("NonExistentCity")
+	weather, err := weatherService.GetWeather(models.NewCityLocation("NonExistentCity"), models.NewWeatherOptions("", ""))
 
 	// Assert the error
 	assert.Error(t, err)
@@ -90,189 +116,3121 @@ func TestWeatherService_GetWeather_CityNotFound(t *testing.T) {
 	assert.Equal(t, "city not found", err.Error())
 }
 
+// Test that the full provider payload is mapped onto the weather response
+func TestWeatherService_GetWeather_FullPayload(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`
+			{
+				"location": {
+					"name": "London",
+					"region": "City of London, Greater London",
+					"country": "United Kingdom",
+					"lat": 51.52,
+					"lon": -0.11,
+					"tz_id": "Europe/London",
+					"localtime_epoch": 1715428800,
+					"localtime": "2024-05-11 13:00"
+				},
+				"current": {
+					"last_updated_epoch": 1715428800,
+					"last_updated": "2024-05-11 13:00",
+					"temp_c": 21.0,
+					"temp_f": 69.8,
+					"is_day": 1,
+					"condition": {
+						"text": "Sunny",
+						"icon": "//cdn.weatherapi.com/weather/64x64/day/113.png",
+						"code": 1000
+					},
+					"wind_mph": 8.1,
+					"wind_kph": 13.0,
+					"wind_degree": 250,
+					"wind_dir": "WSW",
+					"pressure_mb": 1016.0,
+					"pressure_in": 30.0,
+					"precip_mm": 0.1,
+					"precip_in": 0.0,
+					"humidity": 53,
+					"cloud": 25,
+					"feelslike_c": 20.5,
+					"feelslike_f": 68.9,
+					"vis_km": 10.0,
+					"vis_miles": 6.0,
+					"uv": 5.0,
+					"gust_mph": 11.2,
+					"gust_kph": 18.0
+				}
+			}
+		`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:  "test-api-key",
+			BaseURL: mockServer.URL,
+		},
+	}
+
+	weather, err := NewWeatherService(cfg).GetWeather(models.NewCityLocation("London"), models.NewWeatherOptions("", ""))
+	assert.NoError(t, err)
+
+	observedAt := time.Unix(1715428800, 0).UTC()
+	assert.Equal(t, &models.WeatherResponse{
+		Units:         "metric",
+		Temperature:   21.0,
+		FeelsLike:     20.5,
+		Humidity:      53,
+		Description:   "Sunny",
+		ConditionCode: 1000,
+		ConditionIcon: "//cdn.weatherapi.com/weather/64x64/day/113.png",
+		IsDay:         true,
+		WindSpeed:     13.0,
+		WindDegree:    250,
+		WindDirection: "WSW",
+		WindGust:      18.0,
+		Pressure:      1016.0,
+		Precipitation: 0.1,
+		Visibility:    10.0,
+		CloudCover:    25,
+		UVIndex:       5.0,
+		ObservedAt:    &observedAt,
+		Location: models.WeatherLocation{
+			Name:      "London",
+			Region:    "City of London, Greater London",
+			Country:   "United Kingdom",
+			Latitude:  51.52,
+			Longitude: -0.11,
+			Timezone:  "Europe/London",
+			LocalTime: "2024-05-11 13:00",
+		},
+	}, weather)
+}
+
+// Test that imperial units and the requested language are used
+func TestWeatherService_GetWeather_ImperialUnits(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "fr", r.URL.Query().Get("lang"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`
+			{
+				"current": {
+					"temp_c": 21.0,
+					"temp_f": 69.8,
+					"condition": {"text": "Ensoleillé"},
+					"wind_kph": 13.0,
+					"wind_mph": 8.1,
+					"gust_kph": 18.0,
+					"gust_mph": 11.2,
+					"pressure_mb": 1016.0,
+					"pressure_in": 30.0,
+					"precip_mm": 2.5,
+					"precip_in": 0.1,
+					"feelslike_c": 20.5,
+					"feelslike_f": 68.9,
+					"vis_km": 10.0,
+					"vis_miles": 6.0
+				}
+			}
+		`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:  "test-api-key",
+			BaseURL: mockServer.URL,
+		},
+	}
+
+	weather, err := NewWeatherService(cfg).GetWeather(models.NewCityLocation("Paris"), models.NewWeatherOptions("imperial", "fr"))
+	assert.NoError(t, err)
+	assert.Equal(t, "imperial", weather.Units)
+	assert.Equal(t, 69.8, weather.Temperature)
+	assert.Equal(t, 68.9, weather.FeelsLike)
+	assert.Equal(t, 8.1, weather.WindSpeed)
+	assert.Equal(t, 11.2, weather.WindGust)
+	assert.Equal(t, 30.0, weather.Pressure)
+	assert.Equal(t, 0.1, weather.Precipitation)
+	assert.Equal(t, 6.0, weather.Visibility)
+	assert.Equal(t, "Ensoleillé", weather.Description)
+}
+
+// Test that air quality data is requested and parsed when opted in
+func TestWeatherService_GetWeather_AirQuality(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "yes", r.URL.Query().Get("aqi"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`
+			{
+				"current": {
+					"temp_c": 21.0,
+					"condition": {"text": "Sunny"},
+					"air_quality": {
+						"co": 223.6,
+						"no2": 14.9,
+						"o3": 81.5,
+						"so2": 3.2,
+						"pm2_5": 6.4,
+						"pm10": 9.1,
+						"us-epa-index": 2,
+						"gb-defra-index": 1
+					}
+				}
+			}
+		`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:  "test-api-key",
+			BaseURL: mockServer.URL,
+		},
+	}
+
+	options := models.NewWeatherOptions("", "")
+	options.AirQuality = true
+	weather, err := NewWeatherService(cfg).GetWeather(models.NewCityLocation("London"), options)
+	assert.NoError(t, err)
+	assert.Equal(t, &models.AirQuality{
+		CO:           223.6,
+		NO2:          14.9,
+		O3:           81.5,
+		SO2:          3.2,
+		PM25:         6.4,
+		PM10:         9.1,
+		USEPAIndex:   2,
+		GBDefraIndex: 1,
+	}, weather.AirQuality)
+	assert.Equal(t, "Moderate", weather.AirQuality.USEPACategory())
+}
+
+// Test that alerts are parsed from the forecast payload with stable IDs
+func TestWeatherService_GetAlerts(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/forecast.json", r.URL.Path)
+		assert.Equal(t, "yes", r.URL.Query().Get("alerts"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`
+			{
+				"alerts": {
+					"alert": [
+						{
+							"headline": "Yellow wind warning issued",
+							"msgtype": "Alert",
+							"severity": "Moderate",
+							"urgency": "Expected",
+							"areas": "London & South East England",
+							"category": "Met",
+							"certainty": "Likely",
+							"event": "Yellow wind warning",
+							"note": "",
+							"effective": "2024-01-21T08:00:00+00:00",
+							"expires": "2024-01-22T00:00:00+00:00",
+							"desc": "Strong winds may cause some disruption.",
+							"instruction": "Secure loose objects."
+						}
+					]
+				}
+			}
+		`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:  "test-api-key",
+			BaseURL: mockServer.URL,
+		},
+	}
+	weatherService := NewWeatherService(cfg)
+
+	alerts, err := weatherService.GetAlerts(models.NewCityLocation("London"), models.NewWeatherOptions("", ""))
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	assert.NotEmpty(t, alerts[0].ID)
+	assert.Equal(t, "Yellow wind warning", alerts[0].Event)
+	assert.Equal(t, "Moderate", alerts[0].Severity)
+	assert.Equal(t, "Strong winds may cause some disruption.", alerts[0].Description)
+	assert.Equal(t, time.Date(2024, 1, 21, 8, 0, 0, 0, time.UTC), alerts[0].Effective.UTC())
+
+	// The same alert fetched again keeps its ID
+	again, err := weatherService.GetAlerts(models.NewCityLocation("London"), models.NewWeatherOptions("", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, alerts[0].ID, again[0].ID)
+}
+
+// Test that daily forecasts are parsed in the requested units
+func TestWeatherService_GetForecast(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/forecast.json", r.URL.Path)
+		assert.Equal(t, "3", r.URL.Query().Get("days"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`
+			{
+				"location": {"name": "Oslo", "country": "Norway"},
+				"forecast": {
+					"forecastday": [
+						{
+							"date": "2024-01-21",
+							"day": {
+								"maxtemp_c": 2.0, "maxtemp_f": 35.6,
+								"mintemp_c": -4.0, "mintemp_f": 24.8,
+								"avgtemp_c": -1.0, "avgtemp_f": 30.2,
+								"maxwind_mph": 12.3, "maxwind_kph": 19.8,
+								"totalprecip_mm": 1.2, "totalprecip_in": 0.05,
+								"daily_chance_of_rain": 10,
+								"daily_chance_of_snow": 75,
+								"uv": 1.0,
+								"condition": {"text": "Light snow"}
+							}
+						}
+					]
+				}
+			}
+		`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:  "test-api-key",
+			BaseURL: mockServer.URL,
+		},
+	}
+	weatherService := NewWeatherService(cfg)
+
+	forecast, err := weatherService.GetForecast(models.NewCityLocation("Oslo"), models.NewWeatherOptions("", ""), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "Oslo", forecast.Location.Name)
+	assert.Equal(t, []models.ForecastDay{
+		{
+			Date:         "2024-01-21",
+			MaxTemp:      2.0,
+			MinTemp:      -4.0,
+			AvgTemp:      -1.0,
+			MaxWind:      19.8,
+			TotalPrecip:  1.2,
+			ChanceOfRain: 10,
+			ChanceOfSnow: 75,
+			UVIndex:      1.0,
+			Description:  "Light snow",
+		},
+	}, forecast.Days)
+
+	forecast, err = weatherService.GetForecast(models.NewCityLocation("Oslo"), models.NewWeatherOptions("imperial", ""), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "imperial", forecast.Units)
+	assert.Equal(t, 24.8, forecast.Days[0].MinTemp)
+	assert.Equal(t, 12.3, forecast.Days[0].MaxWind)
+	assert.Equal(t, 0.05, forecast.Days[0].TotalPrecip)
+}
+
+// Test that missing or malformed fields in the provider payload do not panic
+func TestWeatherService_GetWeather_PartialPayload(t *testing.T) {
+	payload := `{"current": {"temp_c": 15.0}}`
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(payload))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:  "test-api-key",
+			BaseURL: mockServer.URL,
+		},
+	}
+	weatherService := NewWeatherService(cfg)
+
+	weather, err := weatherService.GetWeather(models.NewCityLocation("London"), models.NewWeatherOptions("", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, 15.0, weather.Temperature)
+	assert.Equal(t, "", weather.Description)
+	assert.Nil(t, weather.ObservedAt)
+
+	payload = `{"location": {"name": "London"}}`
+	weather, err = weatherService.GetWeather(models.NewCityLocation("London"), models.NewWeatherOptions("", ""))
+	assert.Error(t, err)
+	assert.Nil(t, weather)
+	assert.Equal(t, "invalid weather data format", err.Error())
+
+	payload = `{"current": {"temp_c": "warm"}}`
+	weather, err = weatherService.GetWeather(models.NewCityLocation("London"), models.NewWeatherOptions("", ""))
+	assert.Error(t, err)
+	assert.Nil(t, weather)
+}
+
+// Test that the provider's "no matching location" error is reported as city not found
+func TestWeatherService_GetWeather_NoMatchingLocation(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"code": 1006, "message": "No matching location found."}}`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:  "test-api-key",
+			BaseURL: mockServer.URL,
+		},
+	}
+
+	weather, err := NewWeatherService(cfg).GetWeather(models.NewCityLocation("Atlantis"), models.NewWeatherOptions("", ""))
+	assert.Error(t, err)
+	assert.Nil(t, weather)
+	assert.Equal(t, "city not found", err.Error())
+}
+
+// Test that coordinate and IP locations are passed to the provider in its "q" format
+func TestWeatherService_GetWeather_LocationQueries(t *testing.T) {
+	var queries []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("q"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"current": {"temp_c": 15.0, "humidity": 76, "condition": {"text": "Sunny"}}}`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:  "test-api-key",
+			BaseURL: mockServer.URL,
+		},
+	}
+	weatherService := NewWeatherService(cfg)
+
+	locations := []models.LocationQuery{
+		models.NewCoordinatesLocation(51.52, -0.11),
+		models.NewPostalCodeLocation("SW1A 1AA"),
+		models.NewIPLocation("81.2.69.160"),
+	}
+	for _, location := range locations {
+		_, err := weatherService.GetWeather(location, models.NewWeatherOptions("", ""))
+		assert.NoError(t, err)
+	}
+
+	// Without an address the provider would locate the server itself, so nothing is requested
+	_, err := weatherService.GetWeather(models.NewIPLocation(""), models.NewWeatherOptions("", ""))
+	assert.EqualError(t, err, "ip address is required")
+	_, err = weatherService.GetForecast(models.NewIPLocation(""), models.NewWeatherOptions("", ""), 1)
+	assert.EqualError(t, err, "ip address is required")
+
+	assert.Equal(t, []string{"51.5200,-0.1100", "SW1A 1AA", "81.2.69.160"}, queries)
+}
+
+// Test that city search results are parsed and served from cache on repeated queries
+func TestWeatherService_SearchCities(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/search.json", r.URL.Path)
+		assert.Equal(t, "lond", r.URL.Query().Get("q"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`
+			[
+				{
+					"id": 2801268,
+					"name": "London",
+					"region": "City of London, Greater London",
+					"country": "United Kingdom",
+					"lat": 51.52,
+					"lon": -0.11,
+					"url": "london-city-of-london-greater-london-united-kingdom"
+				}
+			]
+		`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		Weather: config.WeatherConfig{
+			APIKey:         "test-api-key",
+			BaseURL:        mockServer.URL,
+			SearchCacheTTL: 60,
+		},
+	}
+
+	weatherService := NewWeatherService(cfg)
+
+	cities, err := weatherService.SearchCities("Lond")
+	assert.NoError(t, err)
+	assert.Len(t, cities, 1)
+	assert.Equal(t, "London", cities[0].Name)
+	assert.Equal(t, "United Kingdom", cities[0].Country)
+	assert.Equal(t, 51.52, cities[0].Lat)
+
+	// Same query with different casing and whitespace should be served from cache
+	cities, err = weatherService.SearchCities("  LOND ")
+	assert.NoError(t, err)
+	assert.Len(t, cities, 1)
+	assert.Equal(t, 1, requests)
+}
+
 // mockWeatherService for testing
 type mockWeatherService struct{}
 
 // Ensure mockWeatherService implements WeatherServiceInterface
 var _ WeatherServiceInterface = (*mockWeatherService)(nil)
 
-func (m *mockWeatherService) GetWeather(city string) (*models.WeatherResponse, error) {
-	return &models.WeatherResponse{
-		Temperature: 15.0,
-		Humidity:    76.0,
-		Description: "Partly cloudy",
+func (m *mockWeatherService) GetWeather(location models.LocationQuery, options models.WeatherOptions) (*models.WeatherResponse, error) {
+	return &models.WeatherResponse{
+		Temperature: 15.0,
+		Humidity:    76.0,
+		Description: "Partly cloudy",
+	}, nil
+}
+
+func (m *mockWeatherService) SearchCities(query string) ([]models.CitySearchResult, error) {
+	return []models.CitySearchResult{}, nil
+}
+
+func (m *mockWeatherService) GetForecast(location models.LocationQuery, options models.WeatherOptions, days int) (*models.Forecast, error) {
+	return &models.Forecast{
+		Units: options.Units,
+		Days: []models.ForecastDay{
+			{Date: "2024-01-21", MinTemp: 3.0, MaxTemp: 8.0, ChanceOfRain: 40, Description: "Cloudy"},
+			{Date: "2024-01-22", MinTemp: -2.0, MaxTemp: 4.0, ChanceOfRain: 85, Description: "Light sleet"},
+			{Date: "2024-01-23", MinTemp: 1.0, MaxTemp: 6.0, ChanceOfRain: 10, Description: "Sunny"},
+		},
+	}, nil
+}
+
+func (m *mockWeatherService) GetAlerts(location models.LocationQuery, options models.WeatherOptions) ([]models.WeatherAlert, error) {
+	return []models.WeatherAlert{
+		{
+			ID:       "alert-1",
+			Headline: "Yellow wind warning issued",
+			Event:    "Yellow wind warning",
+			Severity: "Moderate",
+			Urgency:  "Expected",
+			Areas:    "London & South East England",
+		},
+	}, nil
+}
+
+// MockEmailService for testing
+type mockEmailService struct{}
+
+// Ensure mockEmailService implements EmailServiceInterface
+var _ EmailServiceInterface = (*mockEmailService)(nil)
+
+func (m *mockEmailService) SendConfirmationEmail(email, confirmURL, city string) error {
+	return nil
+}
+
+func (m *mockEmailService) SendWelcomeEmail(email, city, frequency, unsubscribeURL string) error {
+	return nil
+}
+
+func (m *mockEmailService) SendUnsubscribeConfirmationEmail(email, city string) error {
+	return nil
+}
+
+func (m *mockEmailService) SendWeatherUpdateEmail(email string, digest *models.WeatherDigest) error {
+	return nil
+}
+
+func (m *mockEmailService) SendWeatherAlertEmail(email, city string, alert *models.WeatherAlert, unsubscribeURL string) error {
+	return nil
+}
+
+func (m *mockEmailService) SendRuleTriggeredEmail(email, city string, triggered []models.TriggeredRule, units, unsubscribeURL string) error {
+	return nil
+}
+
+func (m *mockEmailService) SendManageLinkEmail(email, manageURL string) error {
+	return nil
+}
+
+func (m *mockEmailService) SendPrivacyLinkEmail(email, action, privacyURL string) error {
+	return nil
+}
+
+func (m *mockEmailService) SendChangeConfirmationEmail(email, confirmURL, city string, change *models.PreferenceChange) error {
+	return nil
+}
+
+func (m *mockEmailService) SendSubscriptionUpdatedEmail(email string, subscription *models.Subscription, manageURL string) error {
+	return nil
+}
+
+// recordingEmailService records the emails it is asked to send
+type recordingEmailService struct {
+	mockEmailService
+	alerts       []string
+	rules        []string
+	digests      map[string]*models.WeatherDigest
+	unsubscribed []string
+	manageLinks  []string
+	privacyLinks []string
+	changes      []string
+	updates      []string
+}
+
+func (m *recordingEmailService) SendWeatherUpdateEmail(email string, digest *models.WeatherDigest) error {
+	if m.digests == nil {
+		m.digests = make(map[string]*models.WeatherDigest)
+	}
+	m.digests[email] = digest
+	return nil
+}
+
+func (m *recordingEmailService) SendUnsubscribeConfirmationEmail(email, city string) error {
+	m.unsubscribed = append(m.unsubscribed, email+":"+city)
+	return nil
+}
+
+func (m *recordingEmailService) SendManageLinkEmail(email, manageURL string) error {
+	m.manageLinks = append(m.manageLinks, email+":"+manageURL)
+	return nil
+}
+
+func (m *recordingEmailService) SendPrivacyLinkEmail(email, action, privacyURL string) error {
+	m.privacyLinks = append(m.privacyLinks, email+":"+privacyURL)
+	return nil
+}
+
+func (m *recordingEmailService) SendChangeConfirmationEmail(email, confirmURL, city string, change *models.PreferenceChange) error {
+	m.changes = append(m.changes, fmt.Sprintf("%s:%s:%s:%s", email, city, change.Frequency, confirmURL))
+	return nil
+}
+
+func (m *recordingEmailService) SendSubscriptionUpdatedEmail(email string, subscription *models.Subscription, manageURL string) error {
+	m.updates = append(m.updates, fmt.Sprintf("%s:%s:%s:%s", email, subscription.City, subscription.Frequency, manageURL))
+	return nil
+}
+
+func (m *recordingEmailService) SendWeatherAlertEmail(email, city string, alert *models.WeatherAlert, unsubscribeURL string) error {
+	m.alerts = append(m.alerts, email+":"+alert.ID)
+	return nil
+}
+
+func (m *recordingEmailService) SendRuleTriggeredEmail(email, city string, triggered []models.TriggeredRule, units, unsubscribeURL string) error {
+	for _, t := range triggered {
+		m.rules = append(m.rules, fmt.Sprintf("%s:%d:%s", email, t.Rule.ID, t.Day.Date))
+	}
+	return nil
+}
+
+// mockAlertRepository keeps sent alerts in memory
+type mockAlertRepository struct {
+	sent map[string]time.Time
+}
+
+// Ensure mockAlertRepository implements AlertRepositoryInterface
+var _ AlertRepositoryInterface = (*mockAlertRepository)(nil)
+
+func (m *mockAlertRepository) HasBeenSent(subscriptionID uint, alertID string) (bool, error) {
+	_, ok := m.sent[fmt.Sprintf("%d:%s", subscriptionID, alertID)]
+	return ok, nil
+}
+
+func (m *mockAlertRepository) MarkSent(subscriptionID uint, alertID string) error {
+	m.sent[fmt.Sprintf("%d:%s", subscriptionID, alertID)] = time.Now()
+	return nil
+}
+
+func (m *mockAlertRepository) DeleteSentBefore(before time.Time) error {
+	return nil
+}
+
+// mockRuleRepository keeps notification rules in memory
+type mockRuleRepository struct {
+	rules []models.NotificationRule
+}
+
+// Ensure mockRuleRepository implements RuleRepositoryInterface
+var _ RuleRepositoryInterface = (*mockRuleRepository)(nil)
+
+func (m *mockRuleRepository) GetRulesForEvaluation() ([]models.NotificationRule, error) {
+	return m.rules, nil
+}
+
+func (m *mockRuleRepository) MarkTriggered(ruleID uint, forecastDate string) error {
+	for i := range m.rules {
+		if m.rules[i].ID == ruleID {
+			m.rules[i].LastTriggeredFor = forecastDate
+		}
+	}
+	return nil
+}
+
+// MockTokenRepository for testing
+type mockTokenRepository struct{}
+
+// Ensure mockTokenRepository implements TokenRepositoryInterface
+var _ TokenRepositoryInterface = (*mockTokenRepository)(nil)
+
+func (m *mockTokenRepository) CreateToken(subscriptionID uint, tokenType string, expiresIn time.Duration) (*models.Token, error) {
+	return &models.Token{
+		ID:             1,
+		TokenHash:      models.HashToken("test-token"),
+		Plaintext:      "test-token",
+		SubscriptionID: subscriptionID,
+		Type:           tokenType,
+		ExpiresAt:      time.Now().Add(expiresIn),
+		CreatedAt:      time.Now(),
+	}, nil
+}
+
+func (m *mockTokenRepository) CreateTokenWithPayload(subscriptionID uint, tokenType, payload string, expiresIn time.Duration) (*models.Token, error) {
+	token, err := m.CreateToken(subscriptionID, tokenType, expiresIn)
+	if err != nil {
+		return nil, err
+	}
+	token.Payload = payload
+	return token, nil
+}
+
+func (m *mockTokenRepository) FindByToken(tokenStr string) (*models.Token, error) {
+	if tokenStr == "valid-token" {
+		return &models.Token{
+			ID:             1,
+			TokenHash:      models.HashToken(tokenStr),
+			SubscriptionID: 1,
+			Type:           "confirmation",
+			ExpiresAt:      time.Now().Add(24 * time.Hour),
+			CreatedAt:      time.Now(),
+		}, nil
+	}
+	return nil, fmt.Errorf("record not found")
+}
+
+func (m *mockTokenRepository) FindLatest(subscriptionID uint, tokenType string) (*models.Token, error) {
+	return nil, nil
+}
+
+func (m *mockTokenRepository) DeleteBySubscription(subscriptionID uint, tokenType string) error {
+	return nil
+}
+
+func (m *mockTokenRepository) RevokeAll(subscriptionID uint) (int64, error) {
+	if subscriptionID != 1 {
+		return 0, fmt.Errorf("record not found")
+	}
+	return 2, nil
+}
+
+func (m *mockTokenRepository) DeleteToken(token *models.Token) error {
+	return nil
+}
+
+func (m *mockTokenRepository) DeleteExpiredTokens() error {
+	return nil
+}
+
+// MockSubscriptionRepository for testing
+type mockSubscriptionRepository struct{}
+
+// Ensure mockSubscriptionRepository implements SubscriptionRepositoryInterface
+var _ SubscriptionRepositoryInterface = (*mockSubscriptionRepository)(nil)
+
+func (m *mockSubscriptionRepository) FindByEmail(email, city, frequency string) (*models.Subscription, error) {
+	if email == "existing@example.com" && city == "London" && frequency != models.FrequencyAlerts {
+		return &models.Subscription{
+			ID:        1,
+			Email:     email,
+			City:      city,
+			Frequency: "daily",
+			Units:     "metric",
+			Language:  "en",
+			Confirmed: true,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}, nil
+	}
+	return nil, nil
+}
+
+func (m *mockSubscriptionRepository) FindByID(id uint) (*models.Subscription, error) {
+	if id == 1 {
+		return &models.Subscription{
+			ID:        id,
+			Email:     "test@example.com",
+			City:      "London",
+			Frequency: "daily",
+			Confirmed: false,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}, nil
+	}
+	return nil, fmt.Errorf("record not found")
+}
+
+func (m *mockSubscriptionRepository) FindAllByEmail(email string) ([]models.Subscription, error) {
+	return []models.Subscription{
+		{ID: 1, Email: email, City: "London", Frequency: "daily", Confirmed: true},
+		{ID: 2, Email: email, City: "Paris", Frequency: "daily", Confirmed: true},
+	}, nil
+}
+
+func (m *mockSubscriptionRepository) Create(subscription *models.Subscription) error {
+	subscription.ID = 1
+	return nil
+}
+
+func (m *mockSubscriptionRepository) Update(subscription *models.Subscription) error {
+	return nil
+}
+
+func (m *mockSubscriptionRepository) Delete(subscription *models.Subscription) error {
+	return nil
+}
+
+func (m *mockSubscriptionRepository) GetSubscriptionsForUpdates(frequency string) ([]models.Subscription, error) {
+	return []models.Subscription{
+		{
+			ID:        1,
+			Email:     "test@example.com",
+			City:      "London",
+			Frequency: frequency,
+			Confirmed: true,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+	}, nil
+}
+
+func (m *mockSubscriptionRepository) SuppressByEmail(email, reason string) (int64, error) {
+	return 0, nil
+}
+
+func (m *mockSubscriptionRepository) UnsuppressByEmail(email string) error {
+	return nil
+}
+
+// suppressingSubscriptionRepository records the suppressed email addresses
+type suppressingSubscriptionRepository struct {
+	mockSubscriptionRepository
+	suppressed map[string]string
+}
+
+func (m *suppressingSubscriptionRepository) SuppressByEmail(email, reason string) (int64, error) {
+	m.suppressed[email] = reason
+	return 1, nil
+}
+
+// mockSuppressionRepository keeps the suppression list in memory
+// mockPrivacyRepository keeps the data of each address in memory
+type mockPrivacyRepository struct {
+	data   map[string]*models.PersonalData
+	erased []string
+}
+
+// Ensure mockPrivacyRepository implements PrivacyRepositoryInterface
+var _ PrivacyRepositoryInterface = (*mockPrivacyRepository)(nil)
+
+func (m *mockPrivacyRepository) Export(email string) (*models.PersonalData, error) {
+	if data, ok := m.data[strings.ToLower(email)]; ok {
+		return data, nil
+	}
+	return &models.PersonalData{Email: strings.ToLower(email)}, nil
+}
+
+func (m *mockPrivacyRepository) Erase(email string) (*models.ErasureResult, error) {
+	data, err := m.Export(email)
+	if err != nil {
+		return nil, err
+	}
+	delete(m.data, data.Email)
+	m.erased = append(m.erased, data.Email)
+	return &models.ErasureResult{Subscriptions: int64(len(data.Subscriptions)), ConsentEvents: int64(len(data.ConsentEvents))}, nil
+}
+
+// mockLinkRepository lets each link be claimed once per address and purpose
+type mockLinkRepository struct {
+	sent map[string]bool
+}
+
+// Ensure mockLinkRepository implements LinkRepositoryInterface
+var _ LinkRepositoryInterface = (*mockLinkRepository)(nil)
+
+func (m *mockLinkRepository) Claim(email, purpose string, cooldown time.Duration) (bool, error) {
+	key := strings.ToLower(email) + ":" + purpose
+	if m.sent[key] {
+		return false, nil
+	}
+	if m.sent == nil {
+		m.sent = make(map[string]bool)
+	}
+	m.sent[key] = true
+	return true, nil
+}
+
+func (m *mockLinkRepository) DeleteSentBefore(before time.Time) error {
+	m.sent = nil
+	return nil
+}
+
+type mockSuppressionRepository struct {
+	suppressions map[string]models.Suppression
+}
+
+// Ensure mockSuppressionRepository implements SuppressionRepositoryInterface
+var _ SuppressionRepositoryInterface = (*mockSuppressionRepository)(nil)
+
+func (m *mockSuppressionRepository) IsSuppressed(email string) (bool, error) {
+	_, ok := m.suppressions[strings.ToLower(email)]
+	return ok, nil
+}
+
+func (m *mockSuppressionRepository) Add(suppression *models.Suppression) (bool, error) {
+	suppression.Email = strings.ToLower(suppression.Email)
+	if _, ok := m.suppressions[suppression.Email]; ok {
+		return false, nil
+	}
+	m.suppressions[suppression.Email] = *suppression
+	return true, nil
+}
+
+func (m *mockSuppressionRepository) Remove(email string) error {
+	if _, ok := m.suppressions[strings.ToLower(email)]; !ok {
+		return fmt.Errorf("record not found")
+	}
+	delete(m.suppressions, strings.ToLower(email))
+	return nil
+}
+
+func (m *mockSuppressionRepository) List() ([]models.Suppression, error) {
+	var suppressions []models.Suppression
+	for _, suppression := range m.suppressions {
+		suppressions = append(suppressions, suppression)
+	}
+	return suppressions, nil
+}
+
+// TestSubscriptionService_Subscribe tests the Subscribe method
+func TestSubscriptionService_Subscribe(t *testing.T) {
+	// Set up a proper in-memory database with migrations
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+
+	// Run migrations to create tables
+	err = db.AutoMigrate(&models.Subscription{}, &models.Token{}, &models.NotificationRule{}, &models.ConsentEvent{})
+	assert.NoError(t, err)
+
+	// Create necessary mocks
+	mockRepo := &mockSubscriptionRepository{}
+	mockTokenRepo := &mockTokenRepository{}
+	mockSuppressionRepo := &mockSuppressionRepository{
+		suppressions: map[string]models.Suppression{"blocked@example.com": {Email: "blocked@example.com", Source: "admin"}},
+	}
+	mockEmailService := &recordingEmailService{}
+	mockWeatherService := &mockWeatherService{}
+
+	// Configure the service with a real DB connection
+	config := &config.Config{AppBaseURL: "http://localhost:8080"}
+	service := &SubscriptionService{
+		db:               db,
+		subscriptionRepo: mockRepo,
+		tokenRepo:        mockTokenRepo,
+		suppressionRepo:  mockSuppressionRepo,
+		emailService:     mockEmailService,
+		weatherService:   mockWeatherService,
+		config:           config,
+	}
+
+	// Test case: New subscription
+	req := &models.SubscriptionRequest{
+		Email:     "new@example.com",
+		City:      "Paris",
+		Frequency: "daily",
+	}
+
+	err = service.Subscribe(req, models.RequestMeta{IP: "192.0.2.1", UserAgent: "test-agent"})
+	assert.NoError(t, err)
+
+	// The request is recorded as the start of the consent of the address
+	var consent models.ConsentEvent
+	assert.NoError(t, db.Where("email = ?", "new@example.com").First(&consent).Error)
+	assert.Equal(t, models.ConsentEventSubscribe, consent.Event)
+	assert.Equal(t, "Paris", consent.City)
+	assert.Equal(t, "192.0.2.1", consent.IP)
+	assert.Equal(t, "test-agent", consent.UserAgent)
+
+	// Test case: Already confirmed subscription with the same preferences
+	req = &models.SubscriptionRequest{
+		Email:     "existing@example.com",
+		City:      "London",
+		Frequency: "daily",
+	}
+
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.Error(t, err)
+	assert.Equal(t, "email already subscribed", err.Error())
+
+	// Test case: Already confirmed subscription with other preferences asks to confirm the change
+	req.Frequency = "hourly"
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"existing@example.com:London:hourly:http://localhost:8080/api/confirm/test-token"}, mockEmailService.changes)
+
+	// Test case: Alerts for a city with confirmed updates are a new subscription
+	req = &models.SubscriptionRequest{
+		Email:     "existing@example.com",
+		City:      "London",
+		Frequency: models.FrequencyAlerts,
+	}
+
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.NoError(t, err)
+	assert.Len(t, mockEmailService.changes, 1)
+	var alerts models.Subscription
+	assert.NoError(t, db.Where("email = ? AND frequency = ?", "existing@example.com", models.FrequencyAlerts).First(&alerts).Error)
+	assert.Equal(t, "London", alerts.City)
+	assert.False(t, alerts.Confirmed)
+
+	// Test case: Subscription by coordinates is labelled with the coordinates
+	lat, lon := 48.8567, 2.3508
+	req = &models.SubscriptionRequest{
+		Email:     "coords@example.com",
+		Latitude:  &lat,
+		Longitude: &lon,
+		Frequency: "daily",
+	}
+
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.NoError(t, err)
+
+	var created models.Subscription
+	err = db.Where("email = ?", "coords@example.com").First(&created).Error
+	assert.NoError(t, err)
+	assert.Equal(t, "48.8567,2.3508", created.City)
+	assert.Equal(t, models.NewCoordinatesLocation(lat, lon), created.LocationQuery())
+	assert.Equal(t, models.NewWeatherOptions("metric", "en"), created.WeatherOptions())
+
+	// Test case: Unit and language preferences are stored on the subscription
+	req = &models.SubscriptionRequest{
+		Email:     "imperial@example.com",
+		City:      "Boston",
+		Frequency: "daily",
+		Units:     "imperial",
+		Language:  "es",
+	}
+
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.NoError(t, err)
+
+	var stored models.Subscription
+	err = db.Where("email = ?", "imperial@example.com").First(&stored).Error
+	assert.NoError(t, err)
+	assert.Equal(t, "imperial", stored.Units)
+	assert.Equal(t, "es", stored.Language)
+	assert.False(t, stored.AirQuality)
+
+	// Test case: Air quality opt-in is stored on the subscription
+	req = &models.SubscriptionRequest{
+		Email:      "aqi@example.com",
+		City:       "Delhi",
+		Frequency:  "daily",
+		AirQuality: true,
+	}
+
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.NoError(t, err)
+
+	stored = models.Subscription{}
+	err = db.Where("email = ?", "aqi@example.com").First(&stored).Error
+	assert.NoError(t, err)
+	assert.True(t, stored.AirQuality)
+	assert.True(t, stored.WeatherOptions().AirQuality)
+
+	// Test case: Notification rules are attached to the subscription
+	threshold := 0.0
+	req = &models.SubscriptionRequest{
+		Email:     "rules@example.com",
+		City:      "Oslo",
+		Frequency: "daily",
+		Rules: []models.RuleRequest{
+			{Metric: "min_temp", Operator: "lt", Threshold: &threshold, Day: 1},
+		},
+	}
+
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.NoError(t, err)
+
+	stored = models.Subscription{}
+	err = db.Preload("Rules").Where("email = ?", "rules@example.com").First(&stored).Error
+	assert.NoError(t, err)
+	assert.Len(t, stored.Rules, 1)
+	assert.Equal(t, "min_temp", stored.Rules[0].Metric)
+	assert.Equal(t, "lt", stored.Rules[0].Operator)
+	assert.Equal(t, 1, stored.Rules[0].Day)
+
+	// Test case: Unsupported language is rejected
+	req.Email = "klingon@example.com"
+	req.Language = "tlh"
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.Error(t, err)
+	assert.Equal(t, "unsupported language", err.Error())
+
+	// Test case: Suppressed addresses cannot subscribe, whatever their case
+	req = &models.SubscriptionRequest{
+		Email:     "Blocked@Example.com",
+		City:      "Paris",
+		Frequency: "daily",
+	}
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.Error(t, err)
+	assert.Equal(t, "email address is suppressed", err.Error())
+}
+
+// TestSubscriptionService_CheckWeatherAlerts tests that each alert is emailed only once
+func TestSubscriptionService_CheckWeatherAlerts(t *testing.T) {
+	emailService := &recordingEmailService{}
+	service := &SubscriptionService{
+		subscriptionRepo: &mockSubscriptionRepository{},
+		tokenRepo:        &mockTokenRepository{},
+		alertRepo:        &mockAlertRepository{sent: map[string]time.Time{}},
+		emailService:     emailService,
+		weatherService:   &mockWeatherService{},
+		signer:           newTestSigner(t),
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
+
+	err := service.CheckWeatherAlerts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test@example.com:alert-1"}, emailService.alerts)
+
+	// The alert is still active on the next check but must not be sent again
+	err = service.CheckWeatherAlerts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test@example.com:alert-1"}, emailService.alerts)
+}
+
+// TestEvaluateRule tests each rule operator against a forecast day
+func TestEvaluateRule(t *testing.T) {
+	day := models.ForecastDay{MinTemp: -2.0, MaxTemp: 4.0, ChanceOfRain: 70, MaxWind: 35.0, UVIndex: 3.0}
+
+	tests := []struct {
+		rule     models.NotificationRule
+		expected bool
+		value    float64
+	}{
+		{models.NotificationRule{Metric: "min_temp", Operator: "lt", Threshold: 0}, true, -2.0},
+		{models.NotificationRule{Metric: "min_temp", Operator: "lt", Threshold: -2}, false, -2.0},
+		{models.NotificationRule{Metric: "min_temp", Operator: "lte", Threshold: -2}, true, -2.0},
+		{models.NotificationRule{Metric: "rain_chance", Operator: "gt", Threshold: 70}, false, 70},
+		{models.NotificationRule{Metric: "rain_chance", Operator: "gte", Threshold: 70}, true, 70},
+		{models.NotificationRule{Metric: "max_wind", Operator: "gt", Threshold: 30}, true, 35.0},
+		{models.NotificationRule{Metric: "uv", Operator: "gte", Threshold: 6}, false, 3.0},
+		{models.NotificationRule{Metric: "humidity", Operator: "gt", Threshold: 0}, false, 0},
+	}
+
+	for _, tt := range tests {
+		fired, value := evaluateRule(tt.rule, day)
+		assert.Equal(t, tt.expected, fired, "%+v", tt.rule)
+		assert.Equal(t, tt.value, value, "%+v", tt.rule)
+	}
+}
+
+// TestDescribeRule tests the explanation of rules in notification emails
+func TestDescribeRule(t *testing.T) {
+	rule := models.NotificationRule{Metric: "min_temp", Operator: "lt", Threshold: 0, Day: 1}
+	assert.Equal(t, "tomorrow's low temperature is below 0.0°C", describeRule(rule, "metric"))
+	assert.Equal(t, "tomorrow's low temperature is below 0.0°F", describeRule(rule, "imperial"))
+
+	rule = models.NotificationRule{Metric: "rain_chance", Operator: "gt", Threshold: 70}
+	assert.Equal(t, "today's chance of rain is above 70%", describeRule(rule, "metric"))
+}
+
+// TestSubscriptionService_EvaluateNotificationRules tests that fired rules are emailed once per forecast day
+func TestSubscriptionService_EvaluateNotificationRules(t *testing.T) {
+	subscription := models.Subscription{ID: 1, Email: "test@example.com", City: "London", Frequency: "daily", Confirmed: true}
+	ruleRepo := &mockRuleRepository{
+		rules: []models.NotificationRule{
+			{ID: 1, SubscriptionID: 1, Subscription: subscription, Metric: "min_temp", Operator: "lt", Threshold: 0, Day: 1},
+			{ID: 2, SubscriptionID: 1, Subscription: subscription, Metric: "rain_chance", Operator: "gt", Threshold: 70, Day: 0},
+			{ID: 3, SubscriptionID: 1, Subscription: subscription, Metric: "rain_chance", Operator: "gt", Threshold: 70, Day: 1},
+		},
+	}
+	emailService := &recordingEmailService{}
+	service := &SubscriptionService{
+		subscriptionRepo: &mockSubscriptionRepository{},
+		tokenRepo:        &mockTokenRepository{},
+		ruleRepo:         ruleRepo,
+		emailService:     emailService,
+		weatherService:   &mockWeatherService{},
+		signer:           newTestSigner(t),
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
+
+	err := service.EvaluateNotificationRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test@example.com:1:2024-01-22", "test@example.com:3:2024-01-22"}, emailService.rules)
+
+	// Rules already fired for the same forecast day are not sent again
+	err = service.EvaluateNotificationRules()
+	assert.NoError(t, err)
+	assert.Len(t, emailService.rules, 2)
+}
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata/golden")
+
+// assertGolden compares rendered output with testdata/golden/<name>, rewriting it with -update
+func assertGolden(t *testing.T, name, actual string) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "golden", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatalf("failed to update golden file %s: %v", path, err)
+		}
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file %s (run go test with -update to create it): %v", path, err)
+	}
+	assert.Equal(t, string(expected), actual, "rendered output differs from %s", path)
+}
+
+// TestEmailTemplates_Golden renders every email and compares both parts with golden files
+func TestEmailTemplates_Golden(t *testing.T) {
+	brand := emailBrand{Name: "Weather API", URL: "http://localhost:8080"}
+
+	for _, name := range emailTemplateNames {
+		t.Run(name, func(t *testing.T) {
+			email, err := defaultEmailTemplates.render(name, sampleEmailData(name, brand))
+			assert.NoError(t, err)
+			assertGolden(t, name+".html", email.HTML)
+			assertGolden(t, name+".txt", email.Text)
+		})
+	}
+}
+
+// TestLoadEmailTemplates_Overrides tests that files in the template directory replace the embedded ones
+func TestLoadEmailTemplates_Overrides(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"layout.html":  `{{define "layout"}}<div class="{{.Brand.Name}}">{{template "content" .}}</div>{{end}}`,
+		"welcome.html": `{{define "content"}}<p>Hello from {{.Brand.Name}}! {{.City}} updates arrive {{.FrequencyText}}.</p>{{end}}`,
+		"notes.md":     "not a template",
+	}
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	templates, err := loadEmailTemplates(dir)
+	assert.NoError(t, err)
+
+	brand := emailBrand{Name: "Acme Weather", URL: "https://weather.example.com"}
+	assert.NoError(t, templates.validate(brand))
+
+	email, err := templates.render(templateWelcome, sampleEmailData(templateWelcome, brand))
+	assert.NoError(t, err)
+	assert.Equal(t, `<div class="Acme Weather"><p>Hello from Acme Weather! London updates arrive every day.</p></div>`, email.HTML)
+
+	// The plain-text part was not overridden and still uses the embedded template
+	expected, err := defaultEmailTemplates.render(templateWelcome, sampleEmailData(templateWelcome, brand))
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Text, email.Text)
+
+	// Emails without an override keep their embedded content inside the custom layout
+	email, err = templates.render(templateConfirmation, sampleEmailData(templateConfirmation, brand))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(email.HTML, `<div class="Acme Weather"><p>Please confirm your subscription`))
+}
+
+// TestValidateEmailTemplates tests the startup validation of template overrides
+func TestValidateEmailTemplates(t *testing.T) {
+	cfg := &config.Config{AppBaseURL: "http://localhost:8080", Email: config.EmailConfig{FromName: "Weather API"}}
+	assert.NoError(t, ValidateEmailTemplates(cfg))
+
+	cfg.Email.TemplateDir = filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, ValidateEmailTemplates(cfg))
+
+	// A template that does not parse
+	cfg.Email.TemplateDir = t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(cfg.Email.TemplateDir, "confirmation.html"), []byte(`{{define "content"}}{{if .City}}{{end}}`), 0644))
+	err := ValidateEmailTemplates(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "confirmation.html")
+
+	// A template that parses but references a field the email does not have
+	cfg.Email.TemplateDir = t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(cfg.Email.TemplateDir, "weather_update.txt"), []byte(`{{define "content"}}{{.Weather.Pressure}} {{.Forecast}}{{end}}`), 0644))
+	err = ValidateEmailTemplates(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "weather_update.txt")
+}
+
+// TestEmailTemplates_EscapesUserInput tests that user supplied values cannot inject HTML
+func TestEmailTemplates_EscapesUserInput(t *testing.T) {
+	email, err := defaultEmailTemplates.render(templateUnsubscribe, unsubscribeEmailData{
+		emailLayout: emailLayout{Brand: emailBrand{Name: "<i>Brand</i>"}, Subject: "<b>Subject</b>"},
+		City:        `<script>alert("x")</script>`,
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, email.HTML, "<script>")
+	assert.NotContains(t, email.HTML, "<b>Subject</b>")
+	assert.NotContains(t, email.HTML, "<i>Brand</i>")
+	assert.Contains(t, email.HTML, "&lt;script&gt;")
+
+	// The plain-text part is not HTML and keeps the value as is
+	assert.Contains(t, email.Text, `<script>alert("x")</script>`)
+}
+
+// TestEmailService_ComposeMessage tests the multipart/alternative structure and header encoding
+func TestEmailService_ComposeMessage(t *testing.T) {
+	emailService := NewEmailService(&config.Config{
+		Email: config.EmailConfig{
+			FromName:    "Wetter Dienst",
+			FromAddress: "weather@example.com",
+		},
+	})
+
+	rendered := &renderedEmail{
+		HTML: "<p>Wetter für Zürich</p>",
+		Text: "Wetter für Zürich",
+	}
+
+	headers := listUnsubscribeHeaders("http://localhost:8080/api/unsubscribe/abc\r\nCc: evil@example.com")
+	message, err := emailService.composeMessage("test@example.com", "Weather Update for Zürich\r\nBcc: evil@example.com", headers, rendered, "test-boundary")
+	assert.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(message)))
+	assert.NoError(t, err)
+	assert.Empty(t, parsed.Header.Get("Bcc"))
+	assert.Empty(t, parsed.Header.Get("Cc"))
+	assert.Equal(t, "<http://localhost:8080/api/unsubscribe/abcCc: evil@example.com>", parsed.Header.Get("List-Unsubscribe"))
+	assert.Equal(t, "List-Unsubscribe=One-Click", parsed.Header.Get("List-Unsubscribe-Post"))
+	assert.Equal(t, "1.0", parsed.Header.Get("MIME-Version"))
+
+	// Non-ASCII subjects are sent as RFC 2047 encoded words
+	rawSubject := parsed.Header.Get("Subject")
+	assert.True(t, strings.HasPrefix(rawSubject, "=?UTF-8?q?"), rawSubject)
+	subject, err := new(mime.WordDecoder).DecodeHeader(rawSubject)
+	assert.NoError(t, err)
+	assert.Equal(t, "Weather Update for ZürichBcc: evil@example.com", subject)
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	assert.Equal(t, "test-boundary", params["boundary"])
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var contentTypes, bodies []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		body, err := io.ReadAll(part)
+		assert.NoError(t, err)
+		contentTypes = append(contentTypes, part.Header.Get("Content-Type"))
+		bodies = append(bodies, string(body))
+	}
+
+	// The plain-text part comes first so clients prefer the HTML part
+	assert.Equal(t, []string{"text/plain; charset=UTF-8", "text/html; charset=UTF-8"}, contentTypes)
+	assert.Equal(t, []string{rendered.Text, rendered.HTML}, bodies)
+}
+
+// recordingTransport keeps sent messages in memory
+type recordingTransport struct {
+	messages []*EmailMessage
+	closed   bool
+}
+
+func (t *recordingTransport) Send(message *EmailMessage) error {
+	t.messages = append(t.messages, message)
+	return nil
+}
+
+func (t *recordingTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func testEmailMessage() *EmailMessage {
+	return &EmailMessage{
+		From:    mail.Address{Name: "Weather API", Address: "weather@example.com"},
+		To:      "test@example.com",
+		Subject: "Weather Update for London",
+		Text:    "Partly cloudy",
+		HTML:    "<p>Partly cloudy</p>",
+		Headers: map[string]string{"List-Unsubscribe": "<http://localhost:8080/api/unsubscribe/abc>"},
+		Raw:     []byte("Subject: Weather Update for London\r\n\r\nPartly cloudy"),
+	}
+}
+
+// TestEmailService_SendsThroughTransport tests that rendered emails are handed to the configured transport
+func TestEmailService_SendsThroughTransport(t *testing.T) {
+	transport := &recordingTransport{}
+	emailService := NewEmailServiceWithTransport(&config.Config{
+		AppBaseURL: "http://localhost:8080",
+		Email:      config.EmailConfig{FromName: "Weather API", FromAddress: "weather@example.com"},
+	}, transport)
+
+	err := emailService.SendConfirmationEmail("test@example.com", "http://localhost:8080/api/confirm/abc", "London")
+	assert.NoError(t, err)
+	assert.Len(t, transport.messages, 1)
+
+	message := transport.messages[0]
+	assert.Equal(t, "weather@example.com", message.From.Address)
+	assert.Equal(t, "test@example.com", message.To)
+	assert.Equal(t, "Confirm your weather subscription for London", message.Subject)
+	assert.Contains(t, message.Text, "http://localhost:8080/api/confirm/abc")
+	assert.Contains(t, message.HTML, `<a href="http://localhost:8080/api/confirm/abc">`)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(message.Raw)))
+	assert.NoError(t, err)
+	assert.Equal(t, "Confirm your weather subscription for London", parsed.Header.Get("Subject"))
+
+	// The confirmation email has nothing to unsubscribe from
+	assert.Empty(t, message.Headers)
+	assert.Empty(t, parsed.Header.Get("List-Unsubscribe"))
+
+	assert.NoError(t, emailService.Close())
+	assert.True(t, transport.closed)
+}
+
+// TestEmailService_ListUnsubscribeHeaders tests the one-click unsubscribe headers of emails with an unsubscribe link
+func TestEmailService_ListUnsubscribeHeaders(t *testing.T) {
+	transport := &recordingTransport{}
+	emailService := NewEmailServiceWithTransport(&config.Config{
+		AppBaseURL: "http://localhost:8080",
+		Email:      config.EmailConfig{FromName: "Weather API", FromAddress: "weather@example.com"},
+	}, transport)
+
+	unsubscribeURL := "http://localhost:8080/api/unsubscribe/unsubscribe-token"
+	weather := &models.WeatherResponse{Units: "metric", Temperature: 15.0, Description: "Partly cloudy"}
+	digest := &models.WeatherDigest{
+		Cities:            []models.DigestCity{{City: "London", Weather: weather, UnsubscribeURL: unsubscribeURL + "-london"}},
+		UnsubscribeAllURL: unsubscribeURL,
+	}
+	alert := &models.WeatherAlert{ID: "alert-1", Headline: "Wind warning", Event: "Wind"}
+
+	assert.NoError(t, emailService.SendWelcomeEmail("test@example.com", "London", "daily", unsubscribeURL))
+	assert.NoError(t, emailService.SendWeatherUpdateEmail("test@example.com", digest))
+	assert.NoError(t, emailService.SendWeatherAlertEmail("test@example.com", "London", alert, unsubscribeURL))
+	assert.NoError(t, emailService.SendRuleTriggeredEmail("test@example.com", "London", nil, "metric", unsubscribeURL))
+	assert.Len(t, transport.messages, 4)
+
+	for _, message := range transport.messages {
+		assert.Equal(t, map[string]string{
+			"List-Unsubscribe":      "<http://localhost:8080/api/unsubscribe/unsubscribe-token>",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}, message.Headers, message.Subject)
+
+		parsed, err := mail.ReadMessage(strings.NewReader(string(message.Raw)))
+		assert.NoError(t, err)
+		assert.Equal(t, "<http://localhost:8080/api/unsubscribe/unsubscribe-token>", parsed.Header.Get("List-Unsubscribe"))
+		assert.Equal(t, "List-Unsubscribe=One-Click", parsed.Header.Get("List-Unsubscribe-Post"))
+	}
+}
+
+// TestNewEmailTransport tests that the transport is selected through config
+func TestNewEmailTransport(t *testing.T) {
+	tests := map[string]EmailTransport{
+		"":         &smtpTransport{},
+		"smtp":     &smtpTransport{},
+		"sendgrid": &sendGridTransport{},
+		"mailgun":  &mailgunTransport{},
+		"ses":      &sesTransport{},
+		"file":     &maildirTransport{},
+	}
+	for name, expected := range tests {
+		transport, err := NewEmailTransport(&config.Config{Email: config.EmailConfig{Transport: name}})
+		assert.NoError(t, err, name)
+		assert.IsType(t, expected, transport, name)
+	}
+
+	_, err := NewEmailTransport(&config.Config{Email: config.EmailConfig{Transport: "pigeon"}})
+	assert.Error(t, err)
+}
+
+// TestSendGridTransport tests the SendGrid mail send request
+func TestSendGridTransport(t *testing.T) {
+	var received map[string]interface{}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/mail/send", r.URL.Path)
+		assert.Equal(t, "Bearer sendgrid-key", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer mockServer.Close()
+
+	transport, err := NewEmailTransport(&config.Config{Email: config.EmailConfig{
+		Transport:  "sendgrid",
+		APIKey:     "sendgrid-key",
+		APIBaseURL: mockServer.URL,
+	}})
+	assert.NoError(t, err)
+
+	err = transport.Send(testEmailMessage())
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Weather Update for London", received["subject"])
+	assert.Equal(t, map[string]interface{}{"email": "weather@example.com", "name": "Weather API"}, received["from"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"to": []interface{}{map[string]interface{}{"email": "test@example.com"}}},
+	}, received["personalizations"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"type": "text/plain", "value": "Partly cloudy"},
+		map[string]interface{}{"type": "text/html", "value": "<p>Partly cloudy</p>"},
+	}, received["content"])
+	assert.Equal(t, map[string]interface{}{"List-Unsubscribe": "<http://localhost:8080/api/unsubscribe/abc>"}, received["headers"])
+}
+
+// TestSendGridTransport_Error tests that provider errors are reported
+func TestSendGridTransport_Error(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errors":[{"message":"The provided authorization grant is invalid"}]}`))
+	}))
+	defer mockServer.Close()
+
+	transport := &sendGridTransport{apiKey: "bad-key", baseURL: mockServer.URL, client: http.DefaultClient}
+
+	err := transport.Send(testEmailMessage())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "status 401")
+	assert.Contains(t, err.Error(), "authorization grant is invalid")
+}
+
+// TestMailgunTransport tests the Mailgun raw MIME request
+func TestMailgunTransport(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/mg.example.com/messages.mime", r.URL.Path)
+
+		user, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "api", user)
+		assert.Equal(t, "mailgun-key", password)
+
+		assert.NoError(t, r.ParseMultipartForm(1<<20))
+		assert.Equal(t, "test@example.com", r.FormValue("to"))
+
+		file, _, err := r.FormFile("message")
+		assert.NoError(t, err)
+		raw, _ := io.ReadAll(file)
+		assert.Equal(t, string(testEmailMessage().Raw), string(raw))
+
+		w.Write([]byte(`{"id":"<1@mg.example.com>","message":"Queued. Thank you."}`))
+	}))
+	defer mockServer.Close()
+
+	transport, err := NewEmailTransport(&config.Config{Email: config.EmailConfig{
+		Transport:     "mailgun",
+		APIKey:        "mailgun-key",
+		MailgunDomain: "mg.example.com",
+		APIBaseURL:    mockServer.URL,
+	}})
+	assert.NoError(t, err)
+
+	err = transport.Send(testEmailMessage())
+	assert.NoError(t, err)
+}
+
+// TestSESTransport tests the SES v2 raw email request
+func TestSESTransport(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/email/outbound-emails", r.URL.Path)
+		assert.Equal(t, "20240121T120000Z", r.Header.Get("X-Amz-Date"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240121/eu-west-1/ses/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature="))
+
+		var received sesRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		assert.Equal(t, "weather@example.com", received.FromEmailAddress)
+		assert.Equal(t, []string{"test@example.com"}, received.Destination.ToAddresses)
+
+		raw, err := base64.StdEncoding.DecodeString(received.Content.Raw.Data)
+		assert.NoError(t, err)
+		assert.Equal(t, string(testEmailMessage().Raw), string(raw))
+
+		w.Write([]byte(`{"MessageId":"0100018d"}`))
+	}))
+	defer mockServer.Close()
+
+	transport := &sesTransport{
+		region:    "eu-west-1",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		baseURL:   mockServer.URL,
+		client:    http.DefaultClient,
+		now:       func() time.Time { return time.Date(2024, 1, 21, 12, 0, 0, 0, time.UTC) },
+	}
+
+	err := transport.Send(testEmailMessage())
+	assert.NoError(t, err)
+}
+
+// TestSESTransport_Signature tests the Signature Version 4 calculation against a precomputed value
+func TestSESTransport_Signature(t *testing.T) {
+	transport := &sesTransport{
+		region:    "eu-west-1",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		now:       func() time.Time { return time.Date(2024, 1, 21, 12, 0, 0, 0, time.UTC) },
+	}
+
+	body := []byte(`{"FromEmailAddress":"weather@example.com"}`)
+	req := httptest.NewRequest(http.MethodPost, "https://email.eu-west-1.amazonaws.com/v2/email/outbound-emails", nil)
+	req.Header.Set("Content-Type", "application/json")
+	transport.sign(req, body)
+
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240121/eu-west-1/ses/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=903b32d94f48fc19e65b2e0bae759a774620e7f447321b3d22309a78000adb24", req.Header.Get("Authorization"))
+}
+
+// TestMaildirTransport tests that messages are delivered into the new directory of a maildir
+func TestMaildirTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "maildir")
+	transport, err := NewEmailTransport(&config.Config{Email: config.EmailConfig{Transport: "file", MaildirPath: dir}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, transport.Send(testEmailMessage()))
+	assert.NoError(t, transport.Send(testEmailMessage()))
+
+	delivered, err := os.ReadDir(filepath.Join(dir, "new"))
+	assert.NoError(t, err)
+	assert.Len(t, delivered, 2)
+
+	content, err := os.ReadFile(filepath.Join(dir, "new", delivered[0].Name()))
+	assert.NoError(t, err)
+	assert.Equal(t, string(testEmailMessage().Raw), string(content))
+
+	pending, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+// fakeSMTPServer is an in-process SMTP server for testing the SMTP transport
+type fakeSMTPServer struct {
+	listener          net.Listener
+	tlsConfig         *tls.Config
+	implicitTLS       bool
+	startTLS          bool
+	authMechanisms    []string
+	username          string
+	password          string
+	silent            bool // accept connections but never greet
+	closeAfterMessage bool
+
+	mu          sync.Mutex
+	connections int
+	helos       []string
+	quits       int
+	messages    []fakeSMTPMessage
+	auths       []fakeSMTPAuth
+}
+
+type fakeSMTPMessage struct {
+	From string
+	To   []string
+	Data string
+	TLS  bool
+}
+
+type fakeSMTPAuth struct {
+	Mechanism string
+	Username  string
+	TLS       bool
+}
+
+// testTLSConfigs returns a server certificate for 127.0.0.1 and a client config trusting it
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsServer.Certificate())
+
+	return &tls.Config{Certificates: tlsServer.TLS.Certificates}, &tls.Config{ServerName: "127.0.0.1", RootCAs: roots}
+}
+
+func startFakeSMTPServer(t *testing.T, server *fakeSMTPServer) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start fake SMTP server: %v", err)
+	}
+	server.listener = listener
+	if server.username == "" {
+		server.username, server.password = "user", "secret"
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.connections++
+			server.mu.Unlock()
+			go server.serve(conn)
+		}
+	}()
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) stats() (int, []fakeSMTPMessage, []fakeSMTPAuth) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections, append([]fakeSMTPMessage(nil), s.messages...), append([]fakeSMTPAuth(nil), s.auths...)
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	if s.silent {
+		io.Copy(io.Discard, conn)
+		return
+	}
+
+	isTLS := s.implicitTLS
+	if isTLS {
+		conn = tls.Server(conn, s.tlsConfig)
+	}
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err
+	}
+
+	reply("220 fake.example.com ESMTP")
+
+	var message fakeSMTPMessage
+	for {
+		line, err := readLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			s.mu.Lock()
+			s.helos = append(s.helos, arg)
+			s.mu.Unlock()
+			lines := []string{"fake.example.com"}
+			if s.startTLS && !isTLS {
+				lines = append(lines, "STARTTLS")
+			}
+			if len(s.authMechanisms) > 0 {
+				lines = append(lines, "AUTH "+strings.Join(s.authMechanisms, " "))
+			}
+			for i, l := range lines {
+				separator := "-"
+				if i == len(lines)-1 {
+					separator = " "
+				}
+				reply("250%s%s", separator, l)
+			}
+		case "STARTTLS":
+			reply("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			reader = bufio.NewReader(conn)
+			isTLS = true
+		case "AUTH":
+			if s.authenticate(arg, isTLS, reply, readLine) {
+				reply("235 Authentication successful")
+			} else {
+				reply("535 Authentication failed")
+			}
+		case "MAIL":
+			message = fakeSMTPMessage{From: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>"), TLS: isTLS}
+			reply("250 OK")
+		case "RCPT":
+			message.To = append(message.To, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data []string
+			for {
+				dataLine, err := readLine()
+				if err != nil {
+					return
+				}
+				if dataLine == "." {
+					break
+				}
+				data = append(data, strings.TrimPrefix(dataLine, "."))
+			}
+			message.Data = strings.Join(data, "\r\n")
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			reply("250 OK queued")
+			if s.closeAfterMessage {
+				return
+			}
+		case "RSET":
+			message = fakeSMTPMessage{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			s.mu.Lock()
+			s.quits++
+			s.mu.Unlock()
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// authenticate runs one AUTH exchange and reports whether the credentials matched
+func (s *fakeSMTPServer) authenticate(arg string, isTLS bool, reply func(string, ...interface{}), readLine func() (string, error)) bool {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	decode := func(value string) string {
+		decoded, _ := base64.StdEncoding.DecodeString(value)
+		return string(decoded)
+	}
+	challenge := func(value string) string {
+		reply("334 %s", base64.StdEncoding.EncodeToString([]byte(value)))
+		line, _ := readLine()
+		return decode(line)
+	}
+
+	var username string
+	var ok bool
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		parts := strings.Split(decode(initial), "\x00")
+		ok = len(parts) == 3 && parts[1] == s.username && parts[2] == s.password
+		username = parts[len(parts)-2]
+	case "LOGIN":
+		username = challenge("Username:")
+		ok = username == s.username && challenge("Password:") == s.password
+	case "CRAM-MD5":
+		nonce := "<1896.697170952@fake.example.com>"
+		user, digest, _ := strings.Cut(challenge(nonce), " ")
+		mac := hmac.New(md5.New, []byte(s.password))
+		mac.Write([]byte(nonce))
+		username = user
+		ok = user == s.username && digest == hex.EncodeToString(mac.Sum(nil))
+	case "XOAUTH2":
+		expected := "user=" + s.username + "\x01auth=Bearer " + s.password + "\x01\x01"
+		username = s.username
+		ok = decode(initial) == expected
+		if !ok {
+			challenge(`{"status":"401","schemes":"bearer","scope":"https://mail.google.com/"}`)
+		}
+	default:
+		return false
+	}
+
+	if ok {
+		s.mu.Lock()
+		s.auths = append(s.auths, fakeSMTPAuth{Mechanism: strings.ToUpper(mechanism), Username: username, TLS: isTLS})
+		s.mu.Unlock()
+	}
+	return ok
+}
+
+func newTestSMTPTransport(server *fakeSMTPServer, clientTLS *tls.Config, tlsMode, auth string) *smtpTransport {
+	transport := newSMTPTransport(config.EmailConfig{
+		SMTPHost:           "127.0.0.1",
+		SMTPPort:           server.port(),
+		SMTPUsername:       "user",
+		SMTPPassword:       "secret",
+		SMTPTLSMode:        tlsMode,
+		SMTPAuth:           auth,
+		SMTPDialTimeout:    2,
+		SMTPCommandTimeout: 2,
+		SMTPIdleTimeout:    60,
+	})
+	if clientTLS != nil {
+		transport.tlsConfig = clientTLS
+	}
+	return transport
+}
+
+func assertDelivered(t *testing.T, message fakeSMTPMessage, expectTLS bool) {
+	t.Helper()
+	assert.Equal(t, "weather@example.com", message.From)
+	assert.Equal(t, []string{"test@example.com"}, message.To)
+	assert.Equal(t, string(testEmailMessage().Raw), message.Data)
+	assert.Equal(t, expectTLS, message.TLS)
+}
+
+// TestSMTPTransport_StartTLS tests opportunistic STARTTLS followed by PLAIN authentication
+func TestSMTPTransport_StartTLS(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	server := &fakeSMTPServer{tlsConfig: serverTLS, startTLS: true, authMechanisms: []string{"PLAIN", "LOGIN"}}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, clientTLS, config.SMTPTLSOpportunistic, config.SMTPAuthPlain)
+	defer transport.Close()
+
+	err := transport.Send(testEmailMessage())
+	assert.NoError(t, err)
+
+	_, messages, auths := server.stats()
+	assert.Len(t, messages, 1)
+	assertDelivered(t, messages[0], true)
+	assert.Equal(t, []fakeSMTPAuth{{Mechanism: "PLAIN", Username: "user", TLS: true}}, auths)
+}
+
+// TestSMTPTransport_OpportunisticWithoutStartTLS tests falling back to plain text when STARTTLS is not offered
+func TestSMTPTransport_OpportunisticWithoutStartTLS(t *testing.T) {
+	server := &fakeSMTPServer{}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, nil, config.SMTPTLSOpportunistic, config.SMTPAuthNone)
+	defer transport.Close()
+
+	err := transport.Send(testEmailMessage())
+	assert.NoError(t, err)
+
+	_, messages, _ := server.stats()
+	assert.Len(t, messages, 1)
+	assertDelivered(t, messages[0], false)
+}
+
+// TestSMTPTransport_RequiredStartTLS tests that nothing is sent when required STARTTLS is unavailable
+func TestSMTPTransport_RequiredStartTLS(t *testing.T) {
+	server := &fakeSMTPServer{authMechanisms: []string{"PLAIN"}}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, nil, config.SMTPTLSStartTLS, config.SMTPAuthPlain)
+	defer transport.Close()
+
+	err := transport.Send(testEmailMessage())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "STARTTLS")
+
+	_, messages, auths := server.stats()
+	assert.Empty(t, messages)
+	assert.Empty(t, auths)
+}
+
+// TestSMTPTransport_ImplicitTLS tests SMTPS with LOGIN authentication
+func TestSMTPTransport_ImplicitTLS(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	server := &fakeSMTPServer{tlsConfig: serverTLS, implicitTLS: true, authMechanisms: []string{"LOGIN"}}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, clientTLS, config.SMTPTLSImplicit, config.SMTPAuthLogin)
+	defer transport.Close()
+
+	err := transport.Send(testEmailMessage())
+	assert.NoError(t, err)
+
+	_, messages, auths := server.stats()
+	assert.Len(t, messages, 1)
+	assertDelivered(t, messages[0], true)
+	assert.Equal(t, []fakeSMTPAuth{{Mechanism: "LOGIN", Username: "user", TLS: true}}, auths)
+}
+
+// TestSMTPTransport_ImplicitTLSUntrusted tests that the server certificate is verified
+func TestSMTPTransport_ImplicitTLSUntrusted(t *testing.T) {
+	serverTLS, _ := testTLSConfigs(t)
+	server := &fakeSMTPServer{tlsConfig: serverTLS, implicitTLS: true}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, nil, config.SMTPTLSImplicit, config.SMTPAuthNone)
+	defer transport.Close()
+
+	err := transport.Send(testEmailMessage())
+	assert.Error(t, err)
+}
+
+// TestSMTPTransport_CRAMMD5 tests CRAM-MD5 authentication, which never sends the password
+func TestSMTPTransport_CRAMMD5(t *testing.T) {
+	server := &fakeSMTPServer{authMechanisms: []string{"CRAM-MD5"}}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, nil, config.SMTPTLSNone, config.SMTPAuthCRAMMD5)
+	defer transport.Close()
+
+	err := transport.Send(testEmailMessage())
+	assert.NoError(t, err)
+
+	_, messages, auths := server.stats()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, []fakeSMTPAuth{{Mechanism: "CRAM-MD5", Username: "user", TLS: false}}, auths)
+}
+
+// TestSMTPTransport_XOAUTH2 tests XOAUTH2 with a valid and a rejected access token
+func TestSMTPTransport_XOAUTH2(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	server := &fakeSMTPServer{tlsConfig: serverTLS, startTLS: true, authMechanisms: []string{"XOAUTH2"}}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, clientTLS, config.SMTPTLSStartTLS, config.SMTPAuthXOAUTH2)
+	defer transport.Close()
+
+	err := transport.Send(testEmailMessage())
+	assert.NoError(t, err)
+
+	_, messages, auths := server.stats()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, []fakeSMTPAuth{{Mechanism: "XOAUTH2", Username: "user", TLS: true}}, auths)
+
+	expired := newTestSMTPTransport(server, clientTLS, config.SMTPTLSStartTLS, config.SMTPAuthXOAUTH2)
+	expired.password = "expired-token"
+	defer expired.Close()
+
+	err = expired.Send(testEmailMessage())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "authentication failed")
+}
+
+// TestSMTPAuth_RefusesUnencrypted tests that LOGIN and XOAUTH2 never send secrets in clear text to remote servers
+func TestSMTPAuth_RefusesUnencrypted(t *testing.T) {
+	remote := &smtp.ServerInfo{Name: "smtp.example.com", TLS: false, Auth: []string{"LOGIN", "XOAUTH2"}}
+
+	_, _, err := (&loginAuth{username: "user", password: "secret", host: "smtp.example.com"}).Start(remote)
+	assert.Equal(t, errUnencryptedAuth, err)
+
+	_, _, err = (&xoauth2Auth{username: "user", token: "token", host: "smtp.example.com"}).Start(remote)
+	assert.Equal(t, errUnencryptedAuth, err)
+}
+
+// TestSMTPTransport_ReusesConnection tests the pooled connection and its idle timeout
+func TestSMTPTransport_ReusesConnection(t *testing.T) {
+	server := &fakeSMTPServer{authMechanisms: []string{"PLAIN"}}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, nil, config.SMTPTLSOpportunistic, config.SMTPAuthPlain)
+	defer transport.Close()
+
+	assert.NoError(t, transport.Send(testEmailMessage()))
+	assert.NoError(t, transport.Send(testEmailMessage()))
+
+	connections, messages, auths := server.stats()
+	assert.Equal(t, 1, connections)
+	assert.Len(t, messages, 2)
+	assert.Len(t, auths, 1)
+
+	// A connection idle for longer than the idle timeout is replaced
+	transport.mu.Lock()
+	transport.lastUsed = time.Now().Add(-2 * time.Minute)
+	transport.mu.Unlock()
+
+	assert.NoError(t, transport.Send(testEmailMessage()))
+
+	connections, messages, _ = server.stats()
+	assert.Equal(t, 2, connections)
+	assert.Len(t, messages, 3)
+
+	// Without an idle timeout every message uses its own connection
+	transport.idleTimeout = 0
+	assert.NoError(t, transport.Send(testEmailMessage()))
+	assert.NoError(t, transport.Send(testEmailMessage()))
+
+	connections, _, _ = server.stats()
+	assert.Equal(t, 4, connections)
+}
+
+// TestSMTPTransport_HeloAndClose tests the EHLO name and that closing the transport ends the
+// pooled connection with QUIT
+func TestSMTPTransport_HeloAndClose(t *testing.T) {
+	server := &fakeSMTPServer{}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, nil, config.SMTPTLSNone, config.SMTPAuthNone)
+	hostname, _ := os.Hostname()
+	assert.Equal(t, heloName(""), transport.helo)
+	if hostname != "" {
+		assert.Equal(t, hostname, transport.helo)
+	}
+	transport.helo = "mail.example.com"
+
+	assert.NoError(t, transport.Send(testEmailMessage()))
+	assert.NoError(t, transport.Close())
+
+	// The server handles QUIT asynchronously
+	assert.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return server.quits == 1
+	}, time.Second, 10*time.Millisecond)
+	server.mu.Lock()
+	assert.Equal(t, []string{"mail.example.com"}, server.helos)
+	server.mu.Unlock()
+
+	assert.Equal(t, "relay.example.com", heloName("relay.example.com"))
+}
+
+// TestSMTPTransport_Reconnects tests that a connection closed by the server is replaced transparently
+func TestSMTPTransport_Reconnects(t *testing.T) {
+	server := &fakeSMTPServer{closeAfterMessage: true}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, nil, config.SMTPTLSNone, config.SMTPAuthNone)
+	defer transport.Close()
+
+	assert.NoError(t, transport.Send(testEmailMessage()))
+	assert.NoError(t, transport.Send(testEmailMessage()))
+
+	connections, messages, _ := server.stats()
+	assert.Equal(t, 2, connections)
+	assert.Len(t, messages, 2)
+}
+
+// TestSMTPTransport_CommandTimeout tests that an unresponsive server does not block sending forever
+func TestSMTPTransport_CommandTimeout(t *testing.T) {
+	server := &fakeSMTPServer{silent: true}
+	startFakeSMTPServer(t, server)
+
+	transport := newTestSMTPTransport(server, nil, config.SMTPTLSNone, config.SMTPAuthNone)
+	transport.commandTimeout = 200 * time.Millisecond
+	defer transport.Close()
+
+	start := time.Now()
+	err := transport.Send(testEmailMessage())
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+// TestEmailService_DateAndMessageID tests the Date and Message-ID headers of composed messages
+func TestEmailService_DateAndMessageID(t *testing.T) {
+	emailService := NewEmailServiceWithTransport(&config.Config{
+		Email: config.EmailConfig{FromName: "Weather API", FromAddress: "weather@example.com"},
+	}, &recordingTransport{})
+	emailService.now = func() time.Time { return time.Date(2024, 1, 21, 12, 30, 0, 0, time.UTC) }
+
+	rendered := &renderedEmail{HTML: "<p>Sunny</p>", Text: "Sunny"}
+
+	var messageIDs []string
+	for i := 0; i < 2; i++ {
+		message, err := emailService.composeMessage("test@example.com", "Weather Update for London", nil, rendered, "")
+		assert.NoError(t, err)
+
+		parsed, err := mail.ReadMessage(strings.NewReader(string(message)))
+		assert.NoError(t, err)
+
+		date, err := parsed.Header.Date()
+		assert.NoError(t, err)
+		assert.True(t, date.Equal(time.Date(2024, 1, 21, 12, 30, 0, 0, time.UTC)))
+
+		messageID := parsed.Header.Get("Message-ID")
+		assert.Regexp(t, `^<[0-9a-f-]{36}@example\.com>$`, messageID)
+		messageIDs = append(messageIDs, messageID)
+	}
+	assert.NotEqual(t, messageIDs[0], messageIDs[1])
+}
+
+// dkimTestKeys returns a PEM encoded private key and the DNS TXT record publishing its public key
+func dkimTestKeys(t *testing.T, keyType string) (string, string) {
+	var private interface{}
+	var publicRecord string
+	switch keyType {
+	case "rsa":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("failed to generate RSA key: %v", err)
+		}
+		public, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		private = key
+		publicRecord = "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(public)
+	case "ed25519":
+		public, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate Ed25519 key: %v", err)
+		}
+		private = key
+		publicRecord = "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("failed to encode private key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), publicRecord
+}
+
+// TestEmailService_DKIMSigning tests that signed messages verify against the published public key
+func TestEmailService_DKIMSigning(t *testing.T) {
+	for _, keyType := range []string{"rsa", "ed25519"} {
+		t.Run(keyType, func(t *testing.T) {
+			privateKey, publicRecord := dkimTestKeys(t, keyType)
+
+			transport := &recordingTransport{}
+			emailService := NewEmailServiceWithTransport(&config.Config{
+				AppBaseURL: "http://localhost:8080",
+				Email: config.EmailConfig{
+					FromName:       "Weather API",
+					FromAddress:    "weather@example.com",
+					DKIMSelector:   "weather",
+					DKIMPrivateKey: privateKey,
+				},
+			}, transport)
+
+			weather := &models.WeatherResponse{Units: "metric", Temperature: 15.0, Description: "Partly cloudy"}
+			err := emailService.SendWeatherUpdateEmail("test@example.com", &models.WeatherDigest{
+				Cities:            []models.DigestCity{{City: "Zürich", Weather: weather}},
+				UnsubscribeAllURL: "http://localhost:8080/api/unsubscribe/abc?scope=all",
+			})
+			assert.NoError(t, err)
+			assert.Len(t, transport.messages, 1)
+
+			lookupTXT := func(domain string) ([]string, error) {
+				if domain != "weather._domainkey.example.com" {
+					return nil, fmt.Errorf("unexpected DKIM lookup: %s", domain)
+				}
+				return []string{publicRecord}, nil
+			}
+
+			raw := transport.messages[0].Raw
+			verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), &dkim.VerifyOptions{LookupTXT: lookupTXT})
+			assert.NoError(t, err)
+			assert.Len(t, verifications, 1)
+			assert.NoError(t, verifications[0].Err)
+			assert.Equal(t, "example.com", verifications[0].Domain)
+			assert.Contains(t, verifications[0].HeaderKeys, "List-Unsubscribe")
+			assert.Contains(t, verifications[0].HeaderKeys, "Message-Id")
+
+			// Any change to a signed header breaks the signature
+			tampered := bytes.Replace(raw, []byte("To: test@example.com"), []byte("To: other@example.com"), 1)
+			verifications, err = dkim.VerifyWithOptions(bytes.NewReader(tampered), &dkim.VerifyOptions{LookupTXT: lookupTXT})
+			assert.NoError(t, err)
+			assert.Len(t, verifications, 1)
+			assert.Error(t, verifications[0].Err)
+		})
+	}
+}
+
+// TestNewDKIMSigner tests the DKIM configuration checks
+func TestNewDKIMSigner(t *testing.T) {
+	privateKey, _ := dkimTestKeys(t, "rsa")
+
+	signer, err := newDKIMSigner(config.EmailConfig{FromAddress: "weather@example.com"})
+	assert.NoError(t, err)
+	assert.Nil(t, signer)
+
+	signer, err = newDKIMSigner(config.EmailConfig{
+		FromAddress:    "weather@example.com",
+		DKIMDomain:     "mail.example.com",
+		DKIMSelector:   "weather",
+		DKIMPrivateKey: strings.ReplaceAll(privateKey, "\n", `\n`),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "mail.example.com", signer.domain)
+
+	keyFile := filepath.Join(t.TempDir(), "dkim.pem")
+	assert.NoError(t, os.WriteFile(keyFile, []byte(privateKey), 0600))
+	signer, err = newDKIMSigner(config.EmailConfig{FromAddress: "weather@example.com", DKIMSelector: "weather", DKIMPrivateKeyFile: keyFile})
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", signer.domain)
+
+	invalid := []config.EmailConfig{
+		{FromAddress: "weather@example.com", DKIMPrivateKey: privateKey},
+		{FromAddress: "weather@example.com", DKIMSelector: "weather"},
+		{FromAddress: "weather@example.com", DKIMSelector: "weather", DKIMPrivateKey: "not a key"},
+		{FromAddress: "weather@example.com", DKIMSelector: "weather", DKIMPrivateKeyFile: filepath.Join(t.TempDir(), "missing.pem")},
+	}
+	for _, cfg := range invalid {
+		_, err := newDKIMSigner(cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+
+	err = ValidateEmailConfig(&config.Config{Email: config.EmailConfig{FromAddress: "weather@example.com", DKIMSelector: "weather"}})
+	assert.Error(t, err)
+}
+
+// TestParseSESNotification tests parsing SNS delivered SES bounce and complaint events
+func TestParseSESNotification(t *testing.T) {
+	envelope := func(message string) []byte {
+		body, _ := json.Marshal(map[string]string{"Type": "Notification", "Message": message})
+		return body
+	}
+
+	events, subscribeURL, err := parseSESNotification(envelope(`{"notificationType":"Bounce","bounce":{"bounceType":"Permanent","bounceSubType":"General",` +
+		`"bouncedRecipients":[{"emailAddress":"gone@example.com","diagnosticCode":"smtp; 550 5.1.1 user unknown"},{"emailAddress":"other@example.com"}]}}`))
+	assert.NoError(t, err)
+	assert.Empty(t, subscribeURL)
+	assert.Equal(t, []models.BounceEvent{
+		{Email: "gone@example.com", Type: models.BounceTypeBounce, Reason: "smtp; 550 5.1.1 user unknown"},
+		{Email: "other@example.com", Type: models.BounceTypeBounce, Reason: "General"},
+	}, events)
+
+	// Transient bounces are retried by SES and do not suppress anything
+	events, _, err = parseSESNotification(envelope(`{"notificationType":"Bounce","bounce":{"bounceType":"Transient",` +
+		`"bouncedRecipients":[{"emailAddress":"full@example.com"}]}}`))
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	events, _, err = parseSESNotification(envelope(`{"eventType":"Complaint","complaint":{"complaintFeedbackType":"abuse",` +
+		`"complainedRecipients":[{"emailAddress":"angry@example.com"}]}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []models.BounceEvent{{Email: "angry@example.com", Type: models.BounceTypeComplaint, Reason: "abuse"}}, events)
+
+	_, subscribeURL, err = parseSESNotification([]byte(`{"Type":"SubscriptionConfirmation","SubscribeURL":"https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription", subscribeURL)
+
+	for _, body := range []string{"not json", `{"Type":"Unknown"}`, `{"Type":"Notification","Message":"not json"}`} {
+		_, _, err := parseSESNotification([]byte(body))
+		assert.EqualError(t, err, "invalid notification", body)
+	}
+}
+
+// TestConfirmSNSSubscription_RejectsForeignHosts tests that only SNS endpoints are visited
+func TestConfirmSNSSubscription_RejectsForeignHosts(t *testing.T) {
+	for _, subscribeURL := range []string{
+		"http://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription",
+		"https://evil.example.com/?Action=ConfirmSubscription",
+		"https://sns.us-east-1.amazonaws.com.evil.example.com/",
+	} {
+		assert.EqualError(t, confirmSNSSubscription(subscribeURL), "invalid notification", subscribeURL)
+	}
+}
+
+// TestParseSendGridEvents tests parsing SendGrid event webhook batches
+func TestParseSendGridEvents(t *testing.T) {
+	events, err := parseSendGridEvents([]byte(`[
+		{"email":"gone@example.com","event":"bounce","type":"bounce","reason":"550 5.1.1 user unknown"},
+		{"email":"blocked@example.com","event":"bounce","type":"blocked","reason":"421 try again later"},
+		{"email":"angry@example.com","event":"spamreport"},
+		{"email":"reader@example.com","event":"open"}
+	]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []models.BounceEvent{
+		{Email: "gone@example.com", Type: models.BounceTypeBounce, Reason: "550 5.1.1 user unknown"},
+		{Email: "angry@example.com", Type: models.BounceTypeComplaint, Reason: "spamreport"},
+	}, events)
+
+	_, err = parseSendGridEvents([]byte(`{"email":"gone@example.com"}`))
+	assert.EqualError(t, err, "invalid notification")
+}
+
+// TestSubscriptionService_ProcessBounceWebhook tests that bounced recipients are suppressed
+func TestSubscriptionService_ProcessBounceWebhook(t *testing.T) {
+	repo := &suppressingSubscriptionRepository{suppressed: map[string]string{}}
+	suppressionRepo := &mockSuppressionRepository{suppressions: map[string]models.Suppression{}}
+	service := &SubscriptionService{subscriptionRepo: repo, suppressionRepo: suppressionRepo}
+
+	err := service.ProcessBounceWebhook(BounceProviderSendGrid, []byte(`[{"email":"gone@example.com","event":"bounce","reason":"user unknown"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"gone@example.com": "bounce: user unknown"}, repo.suppressed)
+	assert.Equal(t, models.Suppression{Email: "gone@example.com", Reason: "user unknown", Source: models.BounceTypeBounce}, suppressionRepo.suppressions["gone@example.com"])
+
+	err = service.ProcessBounceWebhook("postmark", []byte(`[]`))
+	assert.EqualError(t, err, "unsupported provider")
+}
+
+// testDSN is a delivery status notification for two recipients, one of which was only delayed
+const testDSN = "From: Mail Delivery System <MAILER-DAEMON@mx.example.com>\r\n" +
+	"To: no-reply@weatherapi.app\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"dsn\"\r\n" +
+	"\r\n" +
+	"--dsn\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Your message could not be delivered.\r\n" +
+	"--dsn\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; gone@example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 user unknown\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; slow@example.com\r\n" +
+	"Action: delayed\r\n" +
+	"Status: 4.4.1\r\n" +
+	"--dsn--\r\n"
+
+// TestParseDSN tests extracting failed recipients from a delivery status notification
+func TestParseDSN(t *testing.T) {
+	events, err := parseDSN(strings.NewReader(testDSN))
+	assert.NoError(t, err)
+	assert.Equal(t, []models.BounceEvent{{Email: "gone@example.com", Type: models.BounceTypeBounce, Reason: "550 5.1.1 user unknown"}}, events)
+
+	_, err = parseDSN(strings.NewReader("From: someone@example.com\r\nContent-Type: text/plain\r\n\r\nHello\r\n"))
+	assert.Error(t, err)
+}
+
+// TestSubscriptionService_ProcessBounceMaildir tests that processed messages are moved to cur
+func TestSubscriptionService_ProcessBounceMaildir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "new"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "new", "1.bounce"), []byte(testDSN), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "new", "2.other"), []byte("Subject: Out of office\r\n\r\nBack soon\r\n"), 0644))
+
+	repo := &suppressingSubscriptionRepository{suppressed: map[string]string{}}
+	suppressionRepo := &mockSuppressionRepository{suppressions: map[string]models.Suppression{}}
+	service := &SubscriptionService{subscriptionRepo: repo, suppressionRepo: suppressionRepo}
+
+	err := service.ProcessBounceMaildir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"gone@example.com": "bounce: 550 5.1.1 user unknown"}, repo.suppressed)
+
+	remaining, _ := os.ReadDir(filepath.Join(dir, "new"))
+	assert.Empty(t, remaining)
+	processed, _ := os.ReadDir(filepath.Join(dir, "cur"))
+	assert.Len(t, processed, 2)
+	assert.Equal(t, "1.bounce:2,S", processed[0].Name())
+}
+
+// TestSubscriptionService_Suppressions tests managing the suppression list
+func TestSubscriptionService_Suppressions(t *testing.T) {
+	suppressionRepo := &mockSuppressionRepository{suppressions: map[string]models.Suppression{}}
+	service := &SubscriptionService{subscriptionRepo: &mockSubscriptionRepository{}, suppressionRepo: suppressionRepo}
+
+	suppression, err := service.AddSuppression("Spam@Example.com", "subscribed by a third party")
+	assert.NoError(t, err)
+	assert.Equal(t, "spam@example.com", suppression.Email)
+	assert.Equal(t, models.SuppressionSourceAdmin, suppression.Source)
+
+	_, err = service.AddSuppression("spam@example.com", "")
+	assert.EqualError(t, err, "email already suppressed")
+
+	suppressions, err := service.ListSuppressions()
+	assert.NoError(t, err)
+	assert.Len(t, suppressions, 1)
+
+	assert.NoError(t, service.RemoveSuppression("spam@example.com"))
+	assert.EqualError(t, service.RemoveSuppression("spam@example.com"), "record not found")
+}
+
+// digestSubscriptionRepository returns several subscriptions of the same addresses for updates
+type digestSubscriptionRepository struct {
+	mockSubscriptionRepository
+}
+
+func (m *digestSubscriptionRepository) GetSubscriptionsForUpdates(frequency string) ([]models.Subscription, error) {
+	return []models.Subscription{
+		{ID: 1, Email: "multi@example.com", City: "London", Frequency: frequency, Confirmed: true},
+		{ID: 2, Email: "single@example.com", City: "London", Frequency: frequency, Confirmed: true},
+		{ID: 3, Email: "Multi@example.com", City: "Paris", Frequency: frequency, Confirmed: true},
+		{ID: 4, Email: "multi@example.com", City: "Rome", Frequency: frequency, Confirmed: true, Units: models.UnitsImperial},
+	}, nil
+}
+
+func (m *digestSubscriptionRepository) FindAllByEmail(email string) ([]models.Subscription, error) {
+	if !strings.EqualFold(email, "multi@example.com") {
+		return m.mockSubscriptionRepository.FindAllByEmail(email)
+	}
+	// Besides the digest, the address has alerts whose links were revoked once
+	return []models.Subscription{
+		{ID: 1, Email: "multi@example.com", City: "London", Frequency: "hourly", Confirmed: true},
+		{ID: 3, Email: "Multi@example.com", City: "Paris", Frequency: "hourly", Confirmed: true},
+		{ID: 4, Email: "multi@example.com", City: "Rome", Frequency: "hourly", Confirmed: true},
+		{ID: 5, Email: "multi@example.com", City: "Rome", Frequency: models.FrequencyAlerts, Confirmed: true, TokenVersion: 1},
 	}, nil
 }
 
-// MockEmailService for testing
-type mockEmailService struct{}
+// TestSubscriptionService_SendWeatherUpdate tests that each address gets one digest for all of its cities
+func TestSubscriptionService_SendWeatherUpdate(t *testing.T) {
+	emailService := &recordingEmailService{}
+	signer := newTestSigner(t)
+	service := &SubscriptionService{
+		subscriptionRepo: &digestSubscriptionRepository{},
+		tokenRepo:        &mockTokenRepository{},
+		emailService:     emailService,
+		weatherService:   &mockWeatherService{},
+		signer:           signer,
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
 
-// Ensure mockEmailService implements EmailServiceInterface
-var _ EmailServiceInterface = (*mockEmailService)(nil)
+	err := service.SendWeatherUpdate("hourly")
+	assert.NoError(t, err)
+	assert.Len(t, emailService.digests, 2)
 
-func (m *mockEmailService) SendConfirmationEmail(email, confirmURL, city string) error {
-	return nil
+	digest := emailService.digests["multi@example.com"]
+	if assert.NotNil(t, digest) {
+		var cities []string
+		var subscriptionIDs []uint
+		for _, city := range digest.Cities {
+			cities = append(cities, city.City)
+			claims := verifyTestURL(t, signer, city.UnsubscribeURL, "http://localhost:8080/api/unsubscribe/")
+			assert.Equal(t, tokens.PurposeUnsubscribe, claims.Purpose)
+			subscriptionIDs = append(subscriptionIDs, claims.SubscriptionID)
+		}
+		assert.Equal(t, []string{"London", "Paris", "Rome"}, cities)
+		assert.Equal(t, []uint{1, 3, 4}, subscriptionIDs)
+		assert.Equal(t, "hourly", digest.Frequency)
+		assert.True(t, strings.HasSuffix(digest.UnsubscribeAllURL, "?scope=all"))
+
+		// The manage link is signed for the address with its newest token version, so it
+		// opens the management page without requesting another email
+		claims := verifyTestURL(t, signer, digest.ManageURL, "http://localhost:8080/manage?token=")
+		assert.Equal(t, tokens.PurposeManage, claims.Purpose)
+		assert.Equal(t, "multi@example.com", claims.Email)
+		assert.Equal(t, 1, claims.Version)
+		managed, err := service.ListManagedSubscriptions(strings.TrimPrefix(digest.ManageURL, "http://localhost:8080/manage?token="))
+		assert.NoError(t, err)
+		assert.Len(t, managed, 4)
+	}
+	assert.Len(t, emailService.digests["single@example.com"].Cities, 1)
 }
 
-func (m *mockEmailService) SendWelcomeEmail(email, city, frequency, unsubscribeURL string) error {
-	return nil
+// TestSubscriptionService_UnsubscribeAll tests unsubscribing an address from every city
+func TestSubscriptionService_UnsubscribeAll(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Subscription{}, &models.Token{}, &models.ConsentEvent{}))
+
+	emailService := &recordingEmailService{}
+	service := &SubscriptionService{
+		db:               db,
+		subscriptionRepo: &mockSubscriptionRepository{},
+		tokenRepo:        &unsubscribeTokenRepository{},
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     emailService,
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
+
+	err = service.UnsubscribeAll("valid-token", models.RequestMeta{IP: "192.0.2.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test@example.com:London and Paris"}, emailService.unsubscribed)
+
+	var unsubscribed int64
+	db.Model(&models.ConsentEvent{}).Where("email = ? AND event = ? AND ip = ?", "test@example.com", models.ConsentEventUnsubscribe, "192.0.2.1").Count(&unsubscribed)
+	assert.Equal(t, int64(2), unsubscribed)
+
+	err = service.UnsubscribeAll("unknown-token", models.RequestMeta{})
+	assert.EqualError(t, err, "record not found")
 }
 
-func (m *mockEmailService) SendUnsubscribeConfirmationEmail(email, city string) error {
-	return nil
+// unsubscribeTokenRepository returns valid-token as an unsubscribe token
+type unsubscribeTokenRepository struct {
+	mockTokenRepository
 }
 
-func (m *mockEmailService) SendWeatherUpdateEmail(email, city string, weather *models.WeatherResponse, unsubscribeURL string) error {
-	return nil
+func (m *unsubscribeTokenRepository) FindByToken(tokenStr string) (*models.Token, error) {
+	token, err := m.mockTokenRepository.FindByToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	token.Type = "unsubscribe"
+	return token, nil
 }
 
-// MockTokenRepository for testing
-type mockTokenRepository struct{}
+// testTokenSigningKeys signs the tokens of email links in tests
+const testTokenSigningKeys = "test:0123456789abcdef0123456789abcdef"
 
-// Ensure mockTokenRepository implements TokenRepositoryInterface
-var _ TokenRepositoryInterface = (*mockTokenRepository)(nil)
+func newTestSigner(t *testing.T) *tokens.Signer {
+	signer, err := tokens.NewSigner(testTokenSigningKeys)
+	assert.NoError(t, err)
+	return signer
+}
 
-func (m *mockTokenRepository) CreateToken(subscriptionID uint, tokenType string, expiresIn time.Duration) (*models.Token, error) {
-	return &models.Token{
-		ID:             1,
-		Token:          "test-token",
-		SubscriptionID: subscriptionID,
-		Type:           tokenType,
-		ExpiresAt:      time.Now().Add(expiresIn),
-		CreatedAt:      time.Now(),
-	}, nil
+// signTestToken signs a token for purpose bound to a subscription
+func signTestToken(t *testing.T, signer *tokens.Signer, purpose string, subscription models.Subscription) string {
+	token, err := signer.Sign(tokens.Claims{
+		Purpose:        purpose,
+		SubscriptionID: subscription.ID,
+		Email:          subscription.Email,
+		Version:        subscription.TokenVersion,
+		Expiry:         time.Now().Add(time.Hour),
+	})
+	assert.NoError(t, err)
+	return token
 }
 
-func (m *mockTokenRepository) FindByToken(tokenStr string) (*models.Token, error) {
-	if tokenStr == "valid-token" {
-		return &models.Token{
-			ID:             1,
-			Token:          tokenStr,
-			SubscriptionID: 1,
-			Type:           "confirmation",
-			ExpiresAt:      time.Now().Add(24 * time.Hour),
-			CreatedAt:      time.Now(),
-		}, nil
+// verifyTestURL verifies the signed token at the end of a link starting with prefix
+func verifyTestURL(t *testing.T, signer *tokens.Signer, link, prefix string) *tokens.Claims {
+	if !assert.True(t, strings.HasPrefix(link, prefix), link) {
+		return &tokens.Claims{}
 	}
-	return nil, fmt.Errorf("record not found")
+	token := strings.SplitN(strings.TrimPrefix(link, prefix), "?", 2)[0]
+	claims, err := signer.Verify(token)
+	if !assert.NoError(t, err) {
+		return &tokens.Claims{}
+	}
+	return claims
 }
 
-func (m *mockTokenRepository) DeleteToken(token *models.Token) error {
-	return nil
+// TestSubscriptionService_RevokeTokens tests that revoking tokens invalidates signed links
+func TestSubscriptionService_RevokeTokens(t *testing.T) {
+	service := &SubscriptionService{tokenRepo: &mockTokenRepository{}}
+
+	revoked, err := service.RevokeTokens(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), revoked)
+
+	_, err = service.RevokeTokens(99)
+	assert.EqualError(t, err, "record not found")
 }
 
-func (m *mockTokenRepository) DeleteExpiredTokens() error {
-	return nil
+// TestJoinCities tests the city list of weather update subjects
+func TestJoinCities(t *testing.T) {
+	assert.Equal(t, "", joinCities(nil))
+	assert.Equal(t, "London", joinCities([]string{"London"}))
+	assert.Equal(t, "London and Paris", joinCities([]string{"London", "Paris"}))
+	assert.Equal(t, "London, Paris and Rome", joinCities([]string{"London", "Paris", "Rome"}))
 }
 
-// MockSubscriptionRepository for testing
-type mockSubscriptionRepository struct{}
+// managedSubscriptionRepository holds London and Paris for test@example.com and Rome for other@example.com
+type managedSubscriptionRepository struct {
+	mockSubscriptionRepository
+	updated      []models.Subscription
+	tokenVersion int // token version of the subscriptions of test@example.com
+}
 
-// Ensure mockSubscriptionRepository implements SubscriptionRepositoryInterface
-var _ SubscriptionRepositoryInterface = (*mockSubscriptionRepository)(nil)
+func (m *managedSubscriptionRepository) FindByID(id uint) (*models.Subscription, error) {
+	subscription, err := m.findByID(id)
+	if err == nil && subscription.Email == "test@example.com" {
+		subscription.TokenVersion = m.tokenVersion
+	}
+	return subscription, err
+}
 
-func (m *mockSubscriptionRepository) FindByEmail(email, city string) (*models.Subscription, error) {
-	if email == "existing@example.com" && city == "London" {
-		return &models.Subscription{
-			ID:        1,
-			Email:     email,
-			City:      city,
-			Frequency: "daily",
-			Confirmed: true,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}, nil
+func (m *managedSubscriptionRepository) findByID(id uint) (*models.Subscription, error) {
+	switch id {
+	case 1:
+		return &models.Subscription{ID: 1, Email: "test@example.com", City: "London", Frequency: "daily", Confirmed: true}, nil
+	case 2:
+		return &models.Subscription{ID: 2, Email: "test@example.com", City: "Paris", Frequency: "daily", Confirmed: true}, nil
+	case 3:
+		return &models.Subscription{ID: 3, Email: "other@example.com", City: "Rome", Frequency: "daily", Confirmed: true}, nil
+	}
+	return nil, fmt.Errorf("record not found")
+}
+
+func (m *managedSubscriptionRepository) FindByEmail(email, city, frequency string) (*models.Subscription, error) {
+	if email == "test@example.com" && city == "Paris" && frequency != models.FrequencyAlerts {
+		return m.FindByID(2)
 	}
 	return nil, nil
 }
 
-func (m *mockSubscriptionRepository) FindByID(id uint) (*models.Subscription, error) {
-	if id == 1 {
-		return &models.Subscription{
-			ID:        id,
-			Email:     "test@example.com",
-			City:      "London",
-			Frequency: "daily",
-			Confirmed: false,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}, nil
+func (m *managedSubscriptionRepository) FindAllByEmail(email string) ([]models.Subscription, error) {
+	if email != "test@example.com" {
+		return nil, nil
 	}
-	return nil, fmt.Errorf("record not found")
+	subscriptions, err := m.mockSubscriptionRepository.FindAllByEmail(email)
+	for i := range subscriptions {
+		subscriptions[i].TokenVersion = m.tokenVersion
+	}
+	return subscriptions, err
 }
 
-func (m *mockSubscriptionRepository) Create(subscription *models.Subscription) error {
-	subscription.ID = 1
+func (m *managedSubscriptionRepository) Update(subscription *models.Subscription) error {
+	m.updated = append(m.updated, *subscription)
 	return nil
 }
 
-func (m *mockSubscriptionRepository) Update(subscription *models.Subscription) error {
-	return nil
+// TestSubscriptionService_RequestManageLink tests that links are only sent to subscribed addresses
+func TestSubscriptionService_RequestManageLink(t *testing.T) {
+	emailService := &recordingEmailService{}
+	signer := newTestSigner(t)
+	service := &SubscriptionService{
+		subscriptionRepo: &managedSubscriptionRepository{tokenVersion: 2},
+		tokenRepo:        &mockTokenRepository{},
+		linkRepo:         &mockLinkRepository{},
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     emailService,
+		signer:           signer,
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
+
+	assert.NoError(t, service.RequestManageLink("unknown@example.com"))
+	assert.Empty(t, emailService.manageLinks)
+
+	assert.NoError(t, service.RequestManageLink("test@example.com"))
+	if assert.Len(t, emailService.manageLinks, 1) {
+		claims := verifyTestURL(t, signer, emailService.manageLinks[0], "test@example.com:http://localhost:8080/manage?token=")
+		assert.Equal(t, tokens.PurposeManage, claims.Purpose)
+		assert.Equal(t, "test@example.com", claims.Email)
+		assert.Equal(t, 2, claims.Version)
+	}
+
+	// Asking again within the cooldown succeeds without sending another link
+	assert.NoError(t, service.RequestManageLink("Test@example.com"))
+	assert.Len(t, emailService.manageLinks, 1)
 }
 
-func (m *mockSubscriptionRepository) Delete(subscription *models.Subscription) error {
-	return nil
+// TestSubscriptionService_ManagedSubscriptions tests listing and changing subscriptions through a manage token
+func TestSubscriptionService_ManagedSubscriptions(t *testing.T) {
+	subscriptionRepo := &managedSubscriptionRepository{}
+	emailService := &recordingEmailService{}
+	signer := newTestSigner(t)
+	service := &SubscriptionService{
+		subscriptionRepo: subscriptionRepo,
+		tokenRepo:        &mockTokenRepository{},
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     emailService,
+		signer:           signer,
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
+	london, _ := subscriptionRepo.FindByID(1)
+	token := signTestToken(t, signer, tokens.PurposeManage, *london)
+
+	subscriptions, err := service.ListManagedSubscriptions(token)
+	assert.NoError(t, err)
+	assert.Len(t, subscriptions, 2)
+
+	// Unsubscribe tokens, stored tokens and tampered tokens cannot be used to manage subscriptions
+	_, err = service.ListManagedSubscriptions(signTestToken(t, signer, tokens.PurposeUnsubscribe, *london))
+	assert.EqualError(t, err, "invalid token type")
+	_, err = service.ListManagedSubscriptions("valid-token")
+	assert.EqualError(t, err, "record not found")
+	_, err = service.ListManagedSubscriptions(token + "x")
+	assert.EqualError(t, err, "record not found")
+
+	// Bumping the token version of the address revokes the token
+	subscriptionRepo.tokenVersion = 1
+	_, err = service.ListManagedSubscriptions(token)
+	assert.EqualError(t, err, "record not found")
+	subscriptionRepo.tokenVersion = 0
+
+	frequency := "hourly"
+	paused := true
+	subscription, err := service.UpdateManagedSubscription(token, 1, &models.SubscriptionUpdate{Frequency: &frequency, Paused: &paused})
+	assert.NoError(t, err)
+	assert.Equal(t, "hourly", subscription.Frequency)
+	assert.True(t, subscription.Paused)
+
+	city := " Berlin "
+	subscription, err = service.UpdateManagedSubscription(token, 1, &models.SubscriptionUpdate{City: &city})
+	assert.NoError(t, err)
+	assert.Equal(t, "Berlin", subscription.City)
+	assert.Len(t, subscriptionRepo.updated, 2)
+
+	// Every saved change is followed by a notice to the owner with a fresh management link
+	if assert.Len(t, emailService.updates, 2) {
+		assert.True(t, strings.HasPrefix(emailService.updates[0], "test@example.com:London:hourly:"))
+		claims := verifyTestURL(t, signer, strings.TrimPrefix(emailService.updates[1], "test@example.com:Berlin:daily:"), "http://localhost:8080/manage?token=")
+		assert.Equal(t, tokens.PurposeManage, claims.Purpose)
+		assert.Equal(t, "test@example.com", claims.Email)
+	}
+
+	city = "Paris"
+	_, err = service.UpdateManagedSubscription(token, 1, &models.SubscriptionUpdate{City: &city})
+	assert.EqualError(t, err, "email already subscribed")
+
+	city = " "
+	_, err = service.UpdateManagedSubscription(token, 1, &models.SubscriptionUpdate{City: &city})
+	assert.EqualError(t, err, "city is required")
+
+	// Updates and alerts for the same city are separate subscriptions
+	city = "Paris"
+	alerts := models.FrequencyAlerts
+	subscription, err = service.UpdateManagedSubscription(token, 1, &models.SubscriptionUpdate{City: &city, Frequency: &alerts})
+	assert.NoError(t, err)
+	assert.Equal(t, "Paris", subscription.City)
+	assert.Equal(t, models.FrequencyAlerts, subscription.Frequency)
+	assert.Len(t, subscriptionRepo.updated, 3)
+
+	// Subscriptions of other addresses are not found
+	_, err = service.UpdateManagedSubscription(token, 3, &models.SubscriptionUpdate{Frequency: &frequency})
+	assert.EqualError(t, err, "record not found")
+	assert.EqualError(t, service.DeleteManagedSubscription(token, 3, models.RequestMeta{}), "record not found")
+	assert.Len(t, subscriptionRepo.updated, 3)
+	assert.Len(t, emailService.updates, 3)
 }
 
-func (m *mockSubscriptionRepository) GetSubscriptionsForUpdates(frequency string) ([]models.Subscription, error) {
-	return []models.Subscription{
-		{
-			ID:        1,
-			Email:     "test@example.com",
-			City:      "London",
-			Frequency: frequency,
-			Confirmed: true,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		},
-	}, nil
+// TestSubscriptionService_PauseAndResume tests pausing through the unsubscribe token of a subscription
+func TestSubscriptionService_PauseAndResume(t *testing.T) {
+	subscriptionRepo := &managedSubscriptionRepository{}
+	signer := newTestSigner(t)
+	service := &SubscriptionService{
+		subscriptionRepo: subscriptionRepo,
+		tokenRepo:        &unsubscribeTokenRepository{},
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     &mockEmailService{},
+		signer:           signer,
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
+	london, _ := subscriptionRepo.FindByID(1)
+	token := signTestToken(t, signer, tokens.PurposeUnsubscribe, *london)
+
+	until := time.Now().Add(7 * 24 * time.Hour)
+	subscription, err := service.PauseSubscription(token, &until)
+	assert.NoError(t, err)
+	assert.True(t, subscription.Paused)
+	assert.Equal(t, &until, subscription.PausedUntil)
+
+	past := time.Now().Add(-time.Hour)
+	_, err = service.PauseSubscription(token, &past)
+	assert.EqualError(t, err, "pause end must be in the future")
+
+	// Unsubscribe tokens stored in the database before links were signed still work
+	subscription, err = service.ResumeSubscription("valid-token")
+	assert.NoError(t, err)
+	assert.False(t, subscription.Paused)
+	assert.Nil(t, subscription.PausedUntil)
+	assert.Len(t, subscriptionRepo.updated, 2)
+
+	// A token signed for another address, e.g. for a deleted subscription whose ID was reused, is rejected
+	_, err = service.PauseSubscription(signTestToken(t, signer, tokens.PurposeUnsubscribe, models.Subscription{ID: 1, Email: "other@example.com"}), nil)
+	assert.EqualError(t, err, "record not found")
+
+	// Manage tokens pause through the management endpoints instead
+	manageToken := signTestToken(t, signer, tokens.PurposeManage, *london)
+	_, err = service.PauseSubscription(manageToken, nil)
+	assert.EqualError(t, err, "invalid token type")
+
+	// An end time set from the management page implies pausing
+	subscription, err = service.UpdateManagedSubscription(manageToken, 1, &models.SubscriptionUpdate{PausedUntil: &until})
+	assert.NoError(t, err)
+	assert.True(t, subscription.Paused)
+	assert.Equal(t, &until, subscription.PausedUntil)
 }
 
-// TestSubscriptionService_Subscribe tests the Subscribe method
-func TestSubscriptionService_Subscribe(t *testing.T) {
-	// Set up a proper in-memory database with migrations
+
+// dbSubscriptionRepository finds subscriptions in the test database
+type dbSubscriptionRepository struct {
+	mockSubscriptionRepository
+	db *gorm.DB
+}
+
+func (m *dbSubscriptionRepository) FindByID(id uint) (*models.Subscription, error) {
+	var subscription models.Subscription
+	if err := m.db.First(&subscription, id).Error; err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// changeTokenRepository returns valid-token as a change token with a fixed payload
+type changeTokenRepository struct {
+	mockTokenRepository
+	subscriptionID uint
+	payload        string
+}
+
+func (m *changeTokenRepository) FindByToken(tokenStr string) (*models.Token, error) {
+	token, err := m.mockTokenRepository.FindByToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	token.Type = models.TokenTypeChange
+	token.SubscriptionID = m.subscriptionID
+	token.Payload = m.payload
+	return token, nil
+}
+
+// TestSubscriptionService_ConfirmPreferenceChange tests applying a confirmed preference change
+func TestSubscriptionService_ConfirmPreferenceChange(t *testing.T) {
 	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
 	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Subscription{}, &models.Token{}, &models.NotificationRule{}, &models.ConsentEvent{}))
 
-	// Run migrations to create tables
-	err = db.AutoMigrate(&models.Subscription{}, &models.Token{})
+	subscription := models.Subscription{
+		Email: "change@example.com", City: "Oslo", Frequency: "hourly", Units: "metric", Language: "en", Confirmed: true,
+		Rules: []models.NotificationRule{{Metric: "max_wind", Operator: "gt", Threshold: 50}},
+	}
+	assert.NoError(t, db.Create(&subscription).Error)
+
+	threshold := 0.0
+	payload, err := json.Marshal(&models.PreferenceChange{
+		Frequency:  "daily",
+		Units:      "imperial",
+		Language:   "fr",
+		AirQuality: true,
+		Rules:      []models.RuleRequest{{Metric: "min_temp", Operator: "lt", Threshold: &threshold, Day: 1}},
+	})
 	assert.NoError(t, err)
 
-	// Create necessary mocks
-	mockRepo := &mockSubscriptionRepository{}
-	mockTokenRepo := &mockTokenRepository{}
-	mockEmailService := &mockEmailService{}
-	mockWeatherService := &mockWeatherService{}
+	service := &SubscriptionService{
+		db:               db,
+		subscriptionRepo: &dbSubscriptionRepository{db: db},
+		tokenRepo:        &changeTokenRepository{subscriptionID: subscription.ID, payload: string(payload)},
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     &mockEmailService{},
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
 
-	// Configure the service with a real DB connection
-	config := &config.Config{AppBaseURL: "http://localhost:8080"}
+	assert.NoError(t, service.ConfirmSubscription("valid-token", models.RequestMeta{}))
+
+	var stored models.Subscription
+	assert.NoError(t, db.Preload("Rules").First(&stored, subscription.ID).Error)
+	assert.Equal(t, "daily", stored.Frequency)
+	assert.Equal(t, "imperial", stored.Units)
+	assert.Equal(t, "fr", stored.Language)
+	assert.True(t, stored.AirQuality)
+	assert.True(t, stored.Confirmed)
+	assert.Len(t, stored.Rules, 1)
+	assert.Equal(t, "min_temp", stored.Rules[0].Metric)
+
+	// The subscription now has the preferences of the change
+	same, err := service.hasPreferences(&stored, preferenceChange(&models.SubscriptionRequest{
+		Frequency:  "daily",
+		Units:      "imperial",
+		Language:   "fr",
+		AirQuality: true,
+		Rules:      []models.RuleRequest{{Metric: "min_temp", Operator: "lt", Threshold: &threshold, Day: 1}},
+	}, models.NewWeatherOptions("imperial", "fr")))
+	assert.NoError(t, err)
+	assert.True(t, same)
+}
+
+
+// pendingSubscriptionRepository returns an unconfirmed subscription for pending@example.com
+type pendingSubscriptionRepository struct {
+	mockSubscriptionRepository
+}
+
+func (m *pendingSubscriptionRepository) FindByEmail(email, city, frequency string) (*models.Subscription, error) {
+	if email == "pending@example.com" && frequency != models.FrequencyAlerts {
+		return &models.Subscription{ID: 5, Email: email, City: city, Frequency: "daily"}, nil
+	}
+	return m.mockSubscriptionRepository.FindByEmail(email, city, frequency)
+}
+
+// resendTokenRepository remembers the latest confirmation token and deleted tokens
+type resendTokenRepository struct {
+	mockTokenRepository
+	latest  *models.Token
+	deleted int
+}
+
+func (m *resendTokenRepository) FindLatest(subscriptionID uint, tokenType string) (*models.Token, error) {
+	return m.latest, nil
+}
+
+func (m *resendTokenRepository) DeleteBySubscription(subscriptionID uint, tokenType string) error {
+	m.deleted++
+	return nil
+}
+
+// TestSubscriptionService_ResendConfirmation tests resending confirmation links and their cooldown
+func TestSubscriptionService_ResendConfirmation(t *testing.T) {
+	tokenRepo := &resendTokenRepository{latest: &models.Token{CreatedAt: time.Now().Add(-time.Minute)}}
+	service := &SubscriptionService{
+		subscriptionRepo: &pendingSubscriptionRepository{},
+		tokenRepo:        tokenRepo,
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     &mockEmailService{},
+		config:           &config.Config{AppBaseURL: "http://localhost:8080", ResendCooldown: 5},
+	}
+
+	err := service.ResendConfirmation("pending@example.com", "London", "")
+	assert.EqualError(t, err, "confirmation resent too recently")
+	assert.Equal(t, 0, tokenRepo.deleted)
+
+	tokenRepo.latest.CreatedAt = time.Now().Add(-10 * time.Minute)
+	assert.NoError(t, service.ResendConfirmation("pending@example.com", "London", ""))
+	assert.Equal(t, 1, tokenRepo.deleted)
+
+	err = service.ResendConfirmation("existing@example.com", "London", "")
+	assert.EqualError(t, err, "email already subscribed")
+
+	err = service.ResendConfirmation("unknown@example.com", "London", "")
+	assert.EqualError(t, err, "record not found")
+
+	// Alerts are a separate subscription from weather updates
+	err = service.ResendConfirmation("pending@example.com", "London", models.FrequencyAlerts)
+	assert.EqualError(t, err, "record not found")
+}
+
+// TestSubscriptionService_SubscribeAgainCooldown tests that subscribing again before confirming
+// shares the resend cooldown and replaces the earlier confirmation links
+func TestSubscriptionService_SubscribeAgainCooldown(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:subscribe_again_cooldown?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Subscription{}, &models.Token{}, &models.NotificationRule{}, &models.ConsentEvent{}))
+
+	tokenRepo := &resendTokenRepository{latest: &models.Token{CreatedAt: time.Now().Add(-time.Minute)}}
 	service := &SubscriptionService{
 		db:               db,
-		subscriptionRepo: mockRepo,
-		tokenRepo:        mockTokenRepo,
-		emailService:     mockEmailService,
-		weatherService:   mockWeatherService,
-		config:           config,
+		subscriptionRepo: &pendingSubscriptionRepository{},
+		tokenRepo:        tokenRepo,
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     &mockEmailService{},
+		config:           &config.Config{AppBaseURL: "http://localhost:8080", ResendCooldown: 5},
 	}
+	req := &models.SubscriptionRequest{Email: "pending@example.com", City: "London", Frequency: "hourly"}
 
-	// Test case: New subscription
-	req := &models.SubscriptionRequest{
-		Email:     "new@example.com",
-		City:      "Paris",
-		Frequency: "daily",
+	err = service.Subscribe(req, models.RequestMeta{})
+	assert.EqualError(t, err, "confirmation resent too recently")
+	assert.Equal(t, 0, tokenRepo.deleted)
+	var count int64
+	db.Model(&models.Subscription{}).Where("email = ?", "pending@example.com").Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	tokenRepo.latest.CreatedAt = time.Now().Add(-10 * time.Minute)
+	assert.NoError(t, service.Subscribe(req, models.RequestMeta{}))
+	assert.Equal(t, 1, tokenRepo.deleted)
+}
+
+// TestSubscriptionService_PreferenceChangeCooldown tests that change links share the resend cooldown
+func TestSubscriptionService_PreferenceChangeCooldown(t *testing.T) {
+	tokenRepo := &resendTokenRepository{latest: &models.Token{Type: models.TokenTypeChange, CreatedAt: time.Now().Add(-time.Minute)}}
+	emailService := &recordingEmailService{}
+	service := &SubscriptionService{
+		subscriptionRepo: &mockSubscriptionRepository{},
+		tokenRepo:        tokenRepo,
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     emailService,
+		config:           &config.Config{AppBaseURL: "http://localhost:8080", ResendCooldown: 5},
 	}
+	req := &models.SubscriptionRequest{Email: "existing@example.com", City: "London", Frequency: "hourly"}
 
-	err = service.Subscribe(req)
+	err := service.Subscribe(req, models.RequestMeta{})
+	assert.EqualError(t, err, "confirmation resent too recently")
+	assert.Empty(t, emailService.changes)
+
+	tokenRepo.latest.CreatedAt = time.Now().Add(-10 * time.Minute)
+	assert.NoError(t, service.Subscribe(req, models.RequestMeta{}))
+	assert.Len(t, emailService.changes, 1)
+}
+
+// TestSubscriptionService_ConsentEvents tests the consent audit trail of confirming and unsubscribing
+func TestSubscriptionService_ConsentEvents(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:consent_events?mode=memory&cache=shared"), &gorm.Config{})
 	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&models.Subscription{}, &models.Token{}, &models.ConsentEvent{}))
 
-	// Test case: Already confirmed subscription
-	req = &models.SubscriptionRequest{
-		Email:     "existing@example.com",
-		City:      "London",
-		Frequency: "hourly",
+	signer := newTestSigner(t)
+	subscriptionRepo := &mockSubscriptionRepository{}
+	service := &SubscriptionService{
+		db:               db,
+		subscriptionRepo: subscriptionRepo,
+		tokenRepo:        &mockTokenRepository{},
+		suppressionRepo:  &mockSuppressionRepository{suppressions: map[string]models.Suppression{}},
+		emailService:     &mockEmailService{},
+		signer:           signer,
+		config:           &config.Config{AppBaseURL: "http://localhost:8080"},
 	}
 
-	err = service.Subscribe(req)
-	assert.Error(t, err)
-	assert.Equal(t, "email already subscribed", err.Error())
+	assert.NoError(t, service.ConfirmSubscription("valid-token", models.RequestMeta{IP: "192.0.2.1", UserAgent: "browser"}))
+
+	subscription, err := subscriptionRepo.FindByID(1)
+	assert.NoError(t, err)
+	token := signTestToken(t, signer, tokens.PurposeUnsubscribe, *subscription)
+	assert.NoError(t, service.Unsubscribe(token, models.RequestMeta{IP: "198.51.100.7", UserAgent: "mail provider"}))
+
+	// Deleting a subscription from the management page withdraws consent as well
+	manageToken := signTestToken(t, signer, tokens.PurposeManage, *subscription)
+	assert.NoError(t, service.DeleteManagedSubscription(manageToken, 1, models.RequestMeta{IP: "203.0.113.9", UserAgent: "manage page"}))
+
+	var events []models.ConsentEvent
+	assert.NoError(t, db.Order("id").Find(&events).Error)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, models.ConsentEventConfirm, events[0].Event)
+		assert.Equal(t, "test@example.com", events[0].Email)
+		assert.Equal(t, uint(1), events[0].SubscriptionID)
+		assert.Equal(t, "192.0.2.1", events[0].IP)
+		assert.Equal(t, "browser", events[0].UserAgent)
+		assert.Equal(t, models.ConsentEventUnsubscribe, events[1].Event)
+		assert.Equal(t, "198.51.100.7", events[1].IP)
+		assert.Equal(t, models.ConsentEventUnsubscribe, events[2].Event)
+		assert.Equal(t, "203.0.113.9", events[2].IP)
+		assert.Equal(t, "manage page", events[2].UserAgent)
+
+		// Recorded events cannot be changed or deleted
+		assert.ErrorIs(t, db.Model(&events[0]).Update("ip", "203.0.113.1").Error, models.ErrConsentAppendOnly)
+		assert.ErrorIs(t, db.Delete(&events[0]).Error, models.ErrConsentAppendOnly)
+	}
+}
+
+// TestSubscriptionService_PrivacyLinks tests emailing data export and erasure links and using them
+func TestSubscriptionService_PrivacyLinks(t *testing.T) {
+	personalData := func(email string, tokenVersion int) *models.PersonalData {
+		subscription := models.Subscription{ID: 1, Email: email, City: "London", TokenVersion: tokenVersion}
+		return &models.PersonalData{
+			Email:         email,
+			Subscriptions: []models.PersonalSubscription{{Subscription: subscription}},
+			ConsentEvents: []models.ConsentEvent{{Email: email, Event: models.ConsentEventConfirm}},
+		}
+	}
+	suppressed := personalData("suppressed@example.com", 0)
+	suppressed.Suppression = &models.Suppression{Email: "suppressed@example.com", Source: models.SuppressionSourceAdmin}
+	complained := personalData("complained@example.com", 0)
+	complained.Suppression = &models.Suppression{Email: "complained@example.com", Source: models.BounceTypeComplaint}
+	privacyRepo := &mockPrivacyRepository{data: map[string]*models.PersonalData{
+		"test@example.com":       personalData("test@example.com", 2),
+		"suppressed@example.com": suppressed,
+		"complained@example.com": complained,
+	}}
+	emailService := &recordingEmailService{}
+	signer := newTestSigner(t)
+	service := &SubscriptionService{
+		privacyRepo: privacyRepo,
+		linkRepo:    &mockLinkRepository{},
+		suppressionRepo: &mockSuppressionRepository{suppressions: map[string]models.Suppression{
+			"suppressed@example.com": *suppressed.Suppression,
+			"complained@example.com": *complained.Suppression,
+		}},
+		emailService: emailService,
+		signer:       signer,
+		config:       &config.Config{AppBaseURL: "http://localhost:8080"},
+	}
+
+	// Unknown addresses are not emailed, and the caller cannot tell
+	assert.NoError(t, service.RequestPrivacyLink("unknown@example.com", models.PrivacyActionExport))
+	assert.Empty(t, emailService.privacyLinks)
+
+	// Addresses suppressed by an administrator can still export or erase their data, but
+	// addresses that filed a spam complaint are never emailed again
+	assert.NoError(t, service.RequestPrivacyLink("complained@example.com", models.PrivacyActionErase))
+	assert.Empty(t, emailService.privacyLinks)
+	assert.NoError(t, service.RequestPrivacyLink("suppressed@example.com", models.PrivacyActionErase))
+	if assert.Len(t, emailService.privacyLinks, 1) {
+		suppressedClaims := verifyTestURL(t, signer, emailService.privacyLinks[0], "suppressed@example.com:http://localhost:8080/privacy?action=erase&token=")
+		assert.Equal(t, "suppressed@example.com", suppressedClaims.Email)
+	}
+
+	// Asking again for the same action within the cooldown sends nothing
+	assert.NoError(t, service.RequestPrivacyLink("suppressed@example.com", models.PrivacyActionErase))
+	assert.Len(t, emailService.privacyLinks, 1)
+	emailService.privacyLinks = nil
+	assert.EqualError(t, service.RequestPrivacyLink("test@example.com", "delete"), "invalid privacy action")
+
+	assert.NoError(t, service.RequestPrivacyLink("Test@example.com", models.PrivacyActionExport))
+	assert.NoError(t, service.RequestPrivacyLink("test@example.com", models.PrivacyActionErase))
+	if !assert.Len(t, emailService.privacyLinks, 2) {
+		return
+	}
+	exportClaims := verifyTestURL(t, signer, emailService.privacyLinks[0], "test@example.com:http://localhost:8080/privacy?action=export&token=")
+	assert.Equal(t, tokens.PurposeExport, exportClaims.Purpose)
+	assert.Equal(t, "test@example.com", exportClaims.Email)
+	assert.Equal(t, 2, exportClaims.Version)
+	eraseClaims := verifyTestURL(t, signer, emailService.privacyLinks[1], "test@example.com:http://localhost:8080/privacy?action=erase&token=")
+	assert.Equal(t, tokens.PurposeErase, eraseClaims.Purpose)
+
+	owner := models.Subscription{Email: "test@example.com", TokenVersion: 2}
+	exportToken := signTestToken(t, signer, tokens.PurposeExport, owner)
+	eraseToken := signTestToken(t, signer, tokens.PurposeErase, owner)
+
+	data, err := service.ExportPersonalData(exportToken)
+	assert.NoError(t, err)
+	assert.Len(t, data.Subscriptions, 1)
+
+	// Each token only works for its own action
+	_, err = service.ExportPersonalData(eraseToken)
+	assert.EqualError(t, err, "invalid token type")
+	_, err = service.ErasePersonalData(exportToken)
+	assert.EqualError(t, err, "invalid token type")
+	_, err = service.ExportPersonalData("not-a-token")
+	assert.EqualError(t, err, "record not found")
+
+	// Revoking the tokens of a subscription invalidates the links of its address
+	stale := signTestToken(t, signer, tokens.PurposeErase, models.Subscription{Email: "test@example.com", TokenVersion: 1})
+	_, err = service.ErasePersonalData(stale)
+	assert.EqualError(t, err, "record not found")
+	assert.Empty(t, privacyRepo.erased)
+
+	result, err := service.ErasePersonalData(eraseToken)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.Subscriptions)
+	assert.Equal(t, int64(1), result.ConsentEvents)
+	assert.Equal(t, []string{"test@example.com"}, privacyRepo.erased)
+
+	// Nothing is left to export once the address is erased
+	data, err = service.ExportPersonalData(exportToken)
+	assert.NoError(t, err)
+	assert.False(t, data.HasData())
 }
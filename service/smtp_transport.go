@@ -0,0 +1,307 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"weatherapi.app/config"
+)
+
+// smtpTransport sends messages through an SMTP server. It keeps one authenticated
+// connection open between messages and redials when it has been idle too long or broke.
+type smtpTransport struct {
+	host           string
+	port           int
+	username       string
+	password       string
+	tlsMode        string
+	authMechanism  string
+	dialTimeout    time.Duration
+	commandTimeout time.Duration
+	idleTimeout    time.Duration
+	tlsConfig      *tls.Config
+	helo           string // name the client introduces itself with in EHLO
+
+	mu       sync.Mutex
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func newSMTPTransport(cfg config.EmailConfig) *smtpTransport {
+	return &smtpTransport{
+		host:           cfg.SMTPHost,
+		port:           cfg.SMTPPort,
+		username:       cfg.SMTPUsername,
+		password:       cfg.SMTPPassword,
+		tlsMode:        cfg.SMTPTLSMode,
+		authMechanism:  cfg.SMTPAuth,
+		dialTimeout:    time.Duration(cfg.SMTPDialTimeout) * time.Second,
+		commandTimeout: time.Duration(cfg.SMTPCommandTimeout) * time.Second,
+		idleTimeout:    time.Duration(cfg.SMTPIdleTimeout) * time.Second,
+		tlsConfig:      &tls.Config{ServerName: cfg.SMTPHost},
+		helo:           heloName(cfg.SMTPHelo),
+	}
+}
+
+// heloName returns the configured EHLO name, or the host name of the machine; some servers
+// reject or score as spam clients that introduce themselves as localhost
+func heloName(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "localhost"
+}
+
+func (t *smtpTransport) Send(message *EmailMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	client, err := t.connection()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[DEBUG] Sending email via SMTP: server=%s:%d, from=%s, to=%s\n", t.host, t.port, message.From.Address, message.To)
+	if err := t.deliver(client, message); err != nil {
+		// The connection is in an unknown state after a failed transaction
+		t.closeConnection()
+		return err
+	}
+
+	if t.idleTimeout <= 0 {
+		t.closeConnection()
+		return nil
+	}
+	t.lastUsed = time.Now()
+	return nil
+}
+
+// Close ends the pooled connection, if any
+func (t *smtpTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closeConnection()
+	return nil
+}
+
+// connection returns the pooled client when it is still usable, and dials a new one otherwise
+func (t *smtpTransport) connection() (*smtp.Client, error) {
+	if t.client != nil {
+		if time.Since(t.lastUsed) < t.idleTimeout {
+			t.setDeadline()
+			if err := t.client.Reset(); err == nil {
+				return t.client, nil
+			}
+			fmt.Println("[DEBUG] Pooled SMTP connection is no longer usable, reconnecting")
+		}
+		t.closeConnection()
+	}
+
+	return t.dial()
+}
+
+// dial connects, negotiates TLS according to the TLS mode and authenticates
+func (t *smtpTransport) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(t.host, fmt.Sprintf("%d", t.port))
+	dialer := &net.Dialer{Timeout: t.dialTimeout}
+
+	var conn net.Conn
+	var err error
+	if t.tlsMode == config.SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, t.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server %s: %w", addr, err)
+	}
+
+	t.conn = conn
+	t.setDeadline()
+
+	client, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close()
+		t.conn = nil
+		return nil, fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	t.client = client
+
+	if err := t.negotiate(client); err != nil {
+		t.closeConnection()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// negotiate upgrades the session with STARTTLS and authenticates
+func (t *smtpTransport) negotiate(client *smtp.Client) error {
+	if err := client.Hello(t.helo); err != nil {
+		return fmt.Errorf("SMTP EHLO failed: %w", err)
+	}
+
+	switch t.tlsMode {
+	case config.SMTPTLSStartTLS, config.SMTPTLSOpportunistic, "":
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(t.tlsConfig); err != nil {
+				return fmt.Errorf("SMTP STARTTLS failed: %w", err)
+			}
+		} else if t.tlsMode == config.SMTPTLSStartTLS {
+			return fmt.Errorf("SMTP server does not support STARTTLS")
+		}
+	}
+
+	auth, err := t.auth()
+	if err != nil {
+		return err
+	}
+	if auth == nil {
+		return nil
+	}
+	if ok, _ := client.Extension("AUTH"); !ok {
+		return fmt.Errorf("SMTP server does not support authentication")
+	}
+	if err := client.Auth(auth); err != nil {
+		return fmt.Errorf("SMTP authentication failed: %w", err)
+	}
+	return nil
+}
+
+// auth returns the smtp.Auth for the configured mechanism, or nil when authentication is disabled
+func (t *smtpTransport) auth() (smtp.Auth, error) {
+	switch strings.ToLower(t.authMechanism) {
+	case config.SMTPAuthPlain, "":
+		return smtp.PlainAuth("", t.username, t.password, t.host), nil
+	case config.SMTPAuthLogin:
+		return &loginAuth{username: t.username, password: t.password, host: t.host}, nil
+	case config.SMTPAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(t.username, t.password), nil
+	case config.SMTPAuthXOAUTH2:
+		return &xoauth2Auth{username: t.username, token: t.password, host: t.host}, nil
+	case config.SMTPAuthNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported SMTP auth mechanism: %s", t.authMechanism)
+}
+
+// deliver runs one mail transaction on client
+func (t *smtpTransport) deliver(client *smtp.Client, message *EmailMessage) error {
+	t.setDeadline()
+
+	if err := client.Mail(message.From.Address); err != nil {
+		return fmt.Errorf("SMTP MAIL FROM failed: %w", err)
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return fmt.Errorf("SMTP RCPT TO failed: %w", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := writer.Write(message.Raw); err != nil {
+		writer.Close()
+		return fmt.Errorf("failed to write SMTP message: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected the message: %w", err)
+	}
+	return nil
+}
+
+// setDeadline bounds the next SMTP commands by the command timeout
+func (t *smtpTransport) setDeadline() {
+	if t.conn != nil && t.commandTimeout > 0 {
+		t.conn.SetDeadline(time.Now().Add(t.commandTimeout))
+	}
+}
+
+func (t *smtpTransport) closeConnection() {
+	if t.client != nil {
+		t.setDeadline()
+		if err := t.client.Quit(); err != nil {
+			t.client.Close()
+		}
+	} else if t.conn != nil {
+		t.conn.Close()
+	}
+	t.client = nil
+	t.conn = nil
+}
+
+// errUnencryptedAuth is returned instead of sending credentials in clear text to a remote server
+var errUnencryptedAuth = errors.New("refusing to send credentials over an unencrypted connection")
+
+// isLocalhost reports whether credentials may be sent to name without TLS, like smtp.PlainAuth does
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// loginAuth implements the LOGIN authentication mechanism
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errUnencryptedAuth
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge: %s", fromServer)
+}
+
+// xoauth2Auth implements the XOAUTH2 mechanism with an OAuth 2.0 access token
+type xoauth2Auth struct {
+	username string
+	token    string
+	host     string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errUnencryptedAuth
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// The server sends a JSON error as a challenge; an empty response makes it report the failure
+		return []byte{}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"fmt"
+
+	"weatherapi.app/models"
+)
+
+// ListSuppressions returns the suppression list, newest entries first
+func (s *SubscriptionService) ListSuppressions() ([]models.Suppression, error) {
+	fmt.Println("[DEBUG] ListSuppressions called")
+
+	return s.suppressionRepo.List()
+}
+
+// AddSuppression puts an email address on the suppression list. Its subscriptions are kept
+// but no longer receive email, and the address cannot subscribe again.
+func (s *SubscriptionService) AddSuppression(email, reason string) (*models.Suppression, error) {
+	fmt.Printf("[DEBUG] AddSuppression called for: %s\n", email)
+
+	suppression := &models.Suppression{Email: email, Reason: reason, Source: models.SuppressionSourceAdmin}
+	added, err := s.suppressionRepo.Add(suppression)
+	if err != nil {
+		fmt.Printf("[ERROR] Error adding suppression: %v\n", err)
+		return nil, err
+	}
+	if !added {
+		return nil, fmt.Errorf("email already suppressed")
+	}
+
+	return suppression, nil
+}
+
+// RemoveSuppression takes an email address off the suppression list and lifts the bounce
+// suppression of its subscriptions
+func (s *SubscriptionService) RemoveSuppression(email string) error {
+	fmt.Printf("[DEBUG] RemoveSuppression called for: %s\n", email)
+
+	if err := s.suppressionRepo.Remove(email); err != nil {
+		fmt.Printf("[ERROR] Error removing suppression: %v\n", err)
+		return err
+	}
+
+	return s.subscriptionRepo.UnsuppressByEmail(email)
+}
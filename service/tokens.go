@@ -0,0 +1,159 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"weatherapi.app/config"
+	"weatherapi.app/models"
+	"weatherapi.app/tokens"
+)
+
+// unsubscribeTokenTTL is how long the unsubscribe link of an email stays valid
+const unsubscribeTokenTTL = 365 * 24 * time.Hour
+
+// newTokenSigner returns the signer of the tokens in email links, or nil when the keys are
+// invalid. main refuses to start with invalid keys, so this only logs the error.
+func newTokenSigner(config *config.Config) *tokens.Signer {
+	signer, err := tokens.NewSigner(config.TokenSigningKeys)
+	if err != nil {
+		fmt.Printf("[ERROR] Invalid token signing keys, links in emails will not work: %v\n", err)
+		return nil
+	}
+	return signer
+}
+
+// signToken returns a signed token for a purpose, bound to the address and token version of
+// a subscription
+func (s *SubscriptionService) signToken(subscription *models.Subscription, purpose string, ttl time.Duration) (string, error) {
+	token, err := s.signer.Sign(tokens.Claims{
+		Purpose:        purpose,
+		SubscriptionID: subscription.ID,
+		Email:          subscription.Email,
+		Version:        subscription.TokenVersion,
+		Expiry:         time.Now().Add(ttl),
+	})
+	if err != nil {
+		fmt.Printf("[ERROR] Error signing %s token for subscription %d: %v\n", purpose, subscription.ID, err)
+		return "", err
+	}
+	return token, nil
+}
+
+// unsubscribeURL returns the link included in emails sent to a confirmed subscription. The
+// token is signed rather than stored, so sending an email does not add a database row.
+func (s *SubscriptionService) unsubscribeURL(subscription *models.Subscription) (string, error) {
+	token, err := s.signToken(subscription, tokens.PurposeUnsubscribe, unsubscribeTokenTTL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/api/unsubscribe/%s", s.config.AppBaseURL, token), nil
+}
+
+// verifyToken checks a signed token and that it was issued for purpose
+func (s *SubscriptionService) verifyToken(tokenStr, purpose string) (*tokens.Claims, error) {
+	claims, err := s.signer.Verify(tokenStr)
+	if err != nil {
+		fmt.Printf("[ERROR] Rejected signed token: %v\n", err)
+		return nil, fmt.Errorf("record not found")
+	}
+
+	if claims.Purpose != purpose {
+		fmt.Printf("[ERROR] Invalid token type: %s\n", claims.Purpose)
+		return nil, fmt.Errorf("invalid token type")
+	}
+
+	return claims, nil
+}
+
+// unsubscribeTokenSubscription looks up the subscription of the unsubscribe token that is
+// included in every email, which also pauses and resumes it. Tokens stored in the database
+// before links were signed are still accepted until they expire.
+func (s *SubscriptionService) unsubscribeTokenSubscription(tokenStr string) (*models.Subscription, error) {
+	if !tokens.IsSigned(tokenStr) {
+		return s.storedTokenSubscription(tokenStr, models.TokenTypeUnsubscribe)
+	}
+
+	claims, err := s.verifyToken(tokenStr, tokens.PurposeUnsubscribe)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription, err := s.subscriptionRepo.FindByID(claims.SubscriptionID)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding subscription: %v\n", err)
+		return nil, err
+	}
+
+	// The ID of a deleted subscription may be reused by another address
+	if !strings.EqualFold(subscription.Email, claims.Email) || subscription.TokenVersion != claims.Version {
+		fmt.Printf("[ERROR] Token no longer valid for subscription %d\n", subscription.ID)
+		return nil, fmt.Errorf("record not found")
+	}
+
+	return subscription, nil
+}
+
+// storedTokenSubscription looks up the subscription of a token stored in the database
+func (s *SubscriptionService) storedTokenSubscription(tokenStr, tokenType string) (*models.Subscription, error) {
+	token, err := s.tokenRepo.FindByToken(tokenStr)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding token: %v\n", err)
+		return nil, err
+	}
+
+	if token.Type != tokenType {
+		fmt.Printf("[ERROR] Invalid token type: %s\n", token.Type)
+		return nil, fmt.Errorf("invalid token type")
+	}
+
+	subscription, err := s.subscriptionRepo.FindByID(token.SubscriptionID)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding subscription: %v\n", err)
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+// manageTokenOwner returns the address a manage token was issued to. The token stays valid
+// while the address has subscriptions, even when the one it was signed for is deleted, but
+// not once the token version of any of them has been bumped.
+func (s *SubscriptionService) manageTokenOwner(tokenStr string) (string, error) {
+	claims, err := s.verifyToken(tokenStr, tokens.PurposeManage)
+	if err != nil {
+		return "", err
+	}
+
+	subscriptions, err := s.subscriptionRepo.FindAllByEmail(claims.Email)
+	if err != nil {
+		fmt.Printf("[ERROR] Error finding subscriptions of %s: %v\n", claims.Email, err)
+		return "", err
+	}
+	if len(subscriptions) == 0 {
+		return "", fmt.Errorf("record not found")
+	}
+	for _, subscription := range subscriptions {
+		if subscription.TokenVersion > claims.Version {
+			fmt.Printf("[ERROR] Token no longer valid for subscription %d\n", subscription.ID)
+			return "", fmt.Errorf("record not found")
+		}
+	}
+
+	return claims.Email, nil
+}
+
+// RevokeTokens invalidates every link sent to a subscription: its stored tokens are deleted
+// and its token version is bumped, so signed tokens issued before no longer verify
+func (s *SubscriptionService) RevokeTokens(subscriptionID uint) (int64, error) {
+	fmt.Printf("[DEBUG] RevokeTokens called for subscription: %d\n", subscriptionID)
+
+	revoked, err := s.tokenRepo.RevokeAll(subscriptionID)
+	if err != nil {
+		fmt.Printf("[ERROR] Error revoking tokens of subscription %d: %v\n", subscriptionID, err)
+		return 0, err
+	}
+
+	return revoked, nil
+}
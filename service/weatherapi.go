@@ -0,0 +1,303 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"weatherapi.app/models"
+)
+
+// WeatherAPI.com error code returned when no location matches the "q" parameter
+const weatherAPIErrorNoLocation = 1006
+
+// weatherAPIErrorResponse is the error payload returned by WeatherAPI.com
+type weatherAPIErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// checkWeatherAPIStatus converts a non-200 WeatherAPI.com response into an error.
+// Unknown locations are reported as "city not found" whichever status code the provider uses.
+func checkWeatherAPIStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("city not found")
+	}
+
+	var apiErr weatherAPIErrorResponse
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Code == weatherAPIErrorNoLocation {
+		return fmt.Errorf("city not found")
+	}
+
+	return fmt.Errorf("weather API returned status code %d", resp.StatusCode)
+}
+
+// weatherAPICurrentResponse is the payload of WeatherAPI.com's current.json endpoint
+type weatherAPICurrentResponse struct {
+	Location *weatherAPILocation `json:"location"`
+	Current  *weatherAPICurrent  `json:"current"`
+}
+
+type weatherAPILocation struct {
+	Name      string  `json:"name"`
+	Region    string  `json:"region"`
+	Country   string  `json:"country"`
+	Lat       float64 `json:"lat"`
+	Lon       float64 `json:"lon"`
+	TzID      string  `json:"tz_id"`
+	Localtime string  `json:"localtime"`
+}
+
+type weatherAPICondition struct {
+	Text string `json:"text"`
+	Icon string `json:"icon"`
+	Code int    `json:"code"`
+}
+
+type weatherAPICurrent struct {
+	LastUpdatedEpoch int64                 `json:"last_updated_epoch"`
+	TempC            float64               `json:"temp_c"`
+	TempF            float64               `json:"temp_f"`
+	IsDay            int                   `json:"is_day"`
+	Condition        weatherAPICondition   `json:"condition"`
+	WindMph          float64               `json:"wind_mph"`
+	WindKph          float64               `json:"wind_kph"`
+	WindDegree       int                   `json:"wind_degree"`
+	WindDir          string                `json:"wind_dir"`
+	PressureMb       float64               `json:"pressure_mb"`
+	PressureIn       float64               `json:"pressure_in"`
+	PrecipMm         float64               `json:"precip_mm"`
+	PrecipIn         float64               `json:"precip_in"`
+	Humidity         float64               `json:"humidity"`
+	Cloud            int                   `json:"cloud"`
+	FeelslikeC       float64               `json:"feelslike_c"`
+	FeelslikeF       float64               `json:"feelslike_f"`
+	VisKm            float64               `json:"vis_km"`
+	VisMiles         float64               `json:"vis_miles"`
+	UV               float64               `json:"uv"`
+	GustMph          float64               `json:"gust_mph"`
+	GustKph          float64               `json:"gust_kph"`
+	AirQuality       *weatherAPIAirQuality `json:"air_quality"`
+}
+
+type weatherAPIAirQuality struct {
+	CO           float64 `json:"co"`
+	NO2          float64 `json:"no2"`
+	O3           float64 `json:"o3"`
+	SO2          float64 `json:"so2"`
+	PM25         float64 `json:"pm2_5"`
+	PM10         float64 `json:"pm10"`
+	USEPAIndex   int     `json:"us-epa-index"`
+	GBDefraIndex int     `json:"gb-defra-index"`
+}
+
+// toWeatherResponse converts the provider payload into the API's weather response in the given units
+func (r *weatherAPICurrentResponse) toWeatherResponse(units string) *models.WeatherResponse {
+	current := r.Current
+
+	weather := &models.WeatherResponse{
+		Units:         models.UnitsMetric,
+		Temperature:   current.TempC,
+		FeelsLike:     current.FeelslikeC,
+		Humidity:      current.Humidity,
+		Description:   current.Condition.Text,
+		ConditionCode: current.Condition.Code,
+		ConditionIcon: current.Condition.Icon,
+		IsDay:         current.IsDay == 1,
+		WindSpeed:     current.WindKph,
+		WindDegree:    current.WindDegree,
+		WindDirection: current.WindDir,
+		WindGust:      current.GustKph,
+		Pressure:      current.PressureMb,
+		Precipitation: current.PrecipMm,
+		Visibility:    current.VisKm,
+		CloudCover:    current.Cloud,
+		UVIndex:       current.UV,
+	}
+
+	if units == models.UnitsImperial {
+		weather.Units = models.UnitsImperial
+		weather.Temperature = current.TempF
+		weather.FeelsLike = current.FeelslikeF
+		weather.WindSpeed = current.WindMph
+		weather.WindGust = current.GustMph
+		weather.Pressure = current.PressureIn
+		weather.Precipitation = current.PrecipIn
+		weather.Visibility = current.VisMiles
+	}
+
+	if current.AirQuality != nil {
+		weather.AirQuality = &models.AirQuality{
+			CO:           current.AirQuality.CO,
+			NO2:          current.AirQuality.NO2,
+			O3:           current.AirQuality.O3,
+			SO2:          current.AirQuality.SO2,
+			PM25:         current.AirQuality.PM25,
+			PM10:         current.AirQuality.PM10,
+			USEPAIndex:   current.AirQuality.USEPAIndex,
+			GBDefraIndex: current.AirQuality.GBDefraIndex,
+		}
+	}
+
+	if current.LastUpdatedEpoch > 0 {
+		observedAt := time.Unix(current.LastUpdatedEpoch, 0).UTC()
+		weather.ObservedAt = &observedAt
+	}
+
+	if r.Location != nil {
+		weather.Location = models.WeatherLocation{
+			Name:      r.Location.Name,
+			Region:    r.Location.Region,
+			Country:   r.Location.Country,
+			Latitude:  r.Location.Lat,
+			Longitude: r.Location.Lon,
+			Timezone:  r.Location.TzID,
+			LocalTime: r.Location.Localtime,
+		}
+	}
+
+	return weather
+}
+
+// weatherAPIAlertsResponse is the alerts part of WeatherAPI.com's forecast.json payload
+type weatherAPIAlertsResponse struct {
+	Alerts *struct {
+		Alert []weatherAPIAlert `json:"alert"`
+	} `json:"alerts"`
+}
+
+type weatherAPIAlert struct {
+	Headline    string `json:"headline"`
+	MsgType     string `json:"msgtype"`
+	Severity    string `json:"severity"`
+	Urgency     string `json:"urgency"`
+	Areas       string `json:"areas"`
+	Category    string `json:"category"`
+	Certainty   string `json:"certainty"`
+	Event       string `json:"event"`
+	Note        string `json:"note"`
+	Effective   string `json:"effective"`
+	Expires     string `json:"expires"`
+	Desc        string `json:"desc"`
+	Instruction string `json:"instruction"`
+}
+
+// toWeatherAlert converts the provider alert into a weather alert. The provider does not
+// assign alert IDs, so the ID is derived from the fields identifying a single warning.
+func (a weatherAPIAlert) toWeatherAlert() models.WeatherAlert {
+	sum := sha256.Sum256([]byte(strings.Join([]string{a.MsgType, a.Event, a.Headline, a.Areas, a.Effective, a.Expires}, "|")))
+
+	alert := models.WeatherAlert{
+		ID:          hex.EncodeToString(sum[:16]),
+		Headline:    a.Headline,
+		Event:       a.Event,
+		Severity:    a.Severity,
+		Urgency:     a.Urgency,
+		Certainty:   a.Certainty,
+		Category:    a.Category,
+		Areas:       a.Areas,
+		Description: a.Desc,
+		Instruction: a.Instruction,
+	}
+
+	if effective, err := time.Parse(time.RFC3339, a.Effective); err == nil {
+		alert.Effective = &effective
+	}
+	if expires, err := time.Parse(time.RFC3339, a.Expires); err == nil {
+		alert.Expires = &expires
+	}
+
+	return alert
+}
+
+// weatherAPIForecastResponse is the payload of WeatherAPI.com's forecast.json endpoint
+type weatherAPIForecastResponse struct {
+	Location *weatherAPILocation `json:"location"`
+	Forecast *struct {
+		ForecastDay []weatherAPIForecastDay `json:"forecastday"`
+	} `json:"forecast"`
+}
+
+type weatherAPIForecastDay struct {
+	Date string `json:"date"`
+	Day  struct {
+		MaxTempC          float64             `json:"maxtemp_c"`
+		MaxTempF          float64             `json:"maxtemp_f"`
+		MinTempC          float64             `json:"mintemp_c"`
+		MinTempF          float64             `json:"mintemp_f"`
+		AvgTempC          float64             `json:"avgtemp_c"`
+		AvgTempF          float64             `json:"avgtemp_f"`
+		MaxWindMph        float64             `json:"maxwind_mph"`
+		MaxWindKph        float64             `json:"maxwind_kph"`
+		TotalPrecipMm     float64             `json:"totalprecip_mm"`
+		TotalPrecipIn     float64             `json:"totalprecip_in"`
+		DailyChanceOfRain int                 `json:"daily_chance_of_rain"`
+		DailyChanceOfSnow int                 `json:"daily_chance_of_snow"`
+		UV                float64             `json:"uv"`
+		Condition         weatherAPICondition `json:"condition"`
+	} `json:"day"`
+}
+
+// toForecast converts the provider payload into a forecast in the given units
+func (r *weatherAPIForecastResponse) toForecast(units string) *models.Forecast {
+	forecast := &models.Forecast{
+		Units: models.UnitsMetric,
+		Days:  []models.ForecastDay{},
+	}
+	if units == models.UnitsImperial {
+		forecast.Units = models.UnitsImperial
+	}
+
+	if r.Location != nil {
+		forecast.Location = models.WeatherLocation{
+			Name:      r.Location.Name,
+			Region:    r.Location.Region,
+			Country:   r.Location.Country,
+			Latitude:  r.Location.Lat,
+			Longitude: r.Location.Lon,
+			Timezone:  r.Location.TzID,
+			LocalTime: r.Location.Localtime,
+		}
+	}
+
+	if r.Forecast == nil {
+		return forecast
+	}
+
+	for _, fd := range r.Forecast.ForecastDay {
+		day := models.ForecastDay{
+			Date:         fd.Date,
+			MaxTemp:      fd.Day.MaxTempC,
+			MinTemp:      fd.Day.MinTempC,
+			AvgTemp:      fd.Day.AvgTempC,
+			MaxWind:      fd.Day.MaxWindKph,
+			TotalPrecip:  fd.Day.TotalPrecipMm,
+			ChanceOfRain: fd.Day.DailyChanceOfRain,
+			ChanceOfSnow: fd.Day.DailyChanceOfSnow,
+			UVIndex:      fd.Day.UV,
+			Description:  fd.Day.Condition.Text,
+		}
+		if forecast.Units == models.UnitsImperial {
+			day.MaxTemp = fd.Day.MaxTempF
+			day.MinTemp = fd.Day.MinTempF
+			day.AvgTemp = fd.Day.AvgTempF
+			day.MaxWind = fd.Day.MaxWindMph
+			day.TotalPrecip = fd.Day.TotalPrecipIn
+		}
+		forecast.Days = append(forecast.Days, day)
+	}
+
+	return forecast
+}
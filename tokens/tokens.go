@@ -0,0 +1,176 @@
+// Package tokens creates and verifies HMAC-signed tokens for the links in emails, so a link
+// can be checked without looking it up in the database.
+//
+// A token has the form v1.<kid>.<claims>.<signature>, where kid names the signing key, claims
+// is the base64url encoded JSON of the claims and signature is the base64url encoded
+// HMAC-SHA256 of everything before it.
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Purposes of signed tokens; a token is only accepted for the purpose it was issued for
+const (
+	PurposeUnsubscribe = "unsubscribe"
+	PurposeManage      = "manage"
+	PurposeExport      = "export" // downloads all data held about an address
+	PurposeErase       = "erase"  // erases all data held about an address
+)
+
+// formatVersion prefixes every token, so the format can change without breaking old links
+const formatVersion = "v1"
+
+// minSecretLength is the shortest accepted signing secret
+const minSecretLength = 32
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrUnknownKey       = errors.New("token signed with an unknown key")
+	ErrInvalidSignature = errors.New("invalid token signature")
+	ErrExpired          = errors.New("token expired")
+	ErrNoKeys           = errors.New("no token signing keys configured")
+)
+
+// Claims are the signed contents of a token
+type Claims struct {
+	Purpose        string
+	SubscriptionID uint
+	Email          string
+	Version        int // token version of the subscription; bumping it revokes its tokens
+	Expiry         time.Time
+}
+
+// claimsPayload is the compact JSON encoding of Claims, keeping links short
+type claimsPayload struct {
+	Purpose        string `json:"p"`
+	SubscriptionID uint   `json:"s"`
+	Email          string `json:"e"`
+	Version        int    `json:"v"`
+	Expiry         int64  `json:"x"`
+}
+
+// Signer signs tokens with its current key and verifies them with any of its keys
+type Signer struct {
+	keys    map[string][]byte
+	current string
+	now     func() time.Time
+}
+
+// NewSigner parses comma separated kid:secret pairs. The first key signs new tokens and the
+// others are only used for verification, so keys can be rotated without breaking links that
+// were already sent: add the new key in front and drop the old one once its tokens expired.
+func NewSigner(keys string) (*Signer, error) {
+	signer := &Signer{keys: make(map[string][]byte), now: time.Now}
+
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kid, secret, ok := strings.Cut(pair, ":")
+		if !ok || kid == "" || strings.ContainsAny(kid, ". ") {
+			return nil, fmt.Errorf("invalid token signing key %q: expected kid:secret", kid)
+		}
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("token signing key %s is shorter than %d characters", kid, minSecretLength)
+		}
+		if _, exists := signer.keys[kid]; exists {
+			return nil, fmt.Errorf("duplicate token signing key %s", kid)
+		}
+
+		signer.keys[kid] = []byte(secret)
+		if signer.current == "" {
+			signer.current = kid
+		}
+	}
+
+	if signer.current == "" {
+		return nil, ErrNoKeys
+	}
+	return signer, nil
+}
+
+// IsSigned reports whether a token has the format of a signed token, as opposed to a random
+// token stored in the database
+func IsSigned(token string) bool {
+	return strings.HasPrefix(token, formatVersion+".")
+}
+
+// Sign returns a token for claims, signed with the current key
+func (s *Signer) Sign(claims Claims) (string, error) {
+	if s == nil {
+		return "", ErrNoKeys
+	}
+
+	payload, err := json.Marshal(claimsPayload{
+		Purpose:        claims.Purpose,
+		SubscriptionID: claims.SubscriptionID,
+		Email:          claims.Email,
+		Version:        claims.Version,
+		Expiry:         claims.Expiry.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := formatVersion + "." + s.current + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + s.signature(s.keys[s.current], unsigned), nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims
+func (s *Signer) Verify(token string) (*Claims, error) {
+	if s == nil {
+		return nil, ErrNoKeys
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 || parts[0] != formatVersion {
+		return nil, ErrMalformed
+	}
+
+	key, ok := s.keys[parts[1]]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	unsigned := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(s.signature(key, unsigned))) {
+		return nil, ErrInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var decoded claimsPayload
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return nil, ErrMalformed
+	}
+
+	claims := &Claims{
+		Purpose:        decoded.Purpose,
+		SubscriptionID: decoded.SubscriptionID,
+		Email:          decoded.Email,
+		Version:        decoded.Version,
+		Expiry:         time.Unix(decoded.Expiry, 0),
+	}
+	if !s.now().Before(claims.Expiry) {
+		return nil, ErrExpired
+	}
+	return claims, nil
+}
+
+func (s *Signer) signature(key []byte, unsigned string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
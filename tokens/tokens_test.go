@@ -0,0 +1,134 @@
+package tokens
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testKey    = "0123456789abcdef0123456789abcdef"
+	rotatedKey = "fedcba9876543210fedcba9876543210"
+)
+
+func testClaims() Claims {
+	return Claims{
+		Purpose:        PurposeUnsubscribe,
+		SubscriptionID: 42,
+		Email:          "test@example.com",
+		Version:        3,
+		Expiry:         time.Now().Add(time.Hour).Truncate(time.Second),
+	}
+}
+
+// TestSigner_RoundTrip tests that signed claims verify unchanged
+func TestSigner_RoundTrip(t *testing.T) {
+	signer, err := NewSigner("k1:" + testKey)
+	assert.NoError(t, err)
+
+	token, err := signer.Sign(testClaims())
+	assert.NoError(t, err)
+	assert.True(t, IsSigned(token))
+	assert.True(t, strings.HasPrefix(token, "v1.k1."))
+	assert.NotContains(t, token, "test@example.com")
+
+	claims, err := signer.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, PurposeUnsubscribe, claims.Purpose)
+	assert.Equal(t, uint(42), claims.SubscriptionID)
+	assert.Equal(t, "test@example.com", claims.Email)
+	assert.Equal(t, 3, claims.Version)
+	assert.True(t, testClaims().Expiry.Equal(claims.Expiry))
+
+	assert.False(t, IsSigned("2f1d7c1e-5a4b-4c3d-9e8f-0a1b2c3d4e5f"))
+}
+
+// TestSigner_Rejects tests that tampered, malformed and expired tokens are rejected
+func TestSigner_Rejects(t *testing.T) {
+	signer, err := NewSigner("k1:" + testKey)
+	assert.NoError(t, err)
+
+	token, err := signer.Sign(testClaims())
+	assert.NoError(t, err)
+	parts := strings.Split(token, ".")
+
+	// Claims of another token with the signature of this one
+	other := testClaims()
+	other.SubscriptionID = 43
+	otherToken, err := signer.Sign(other)
+	assert.NoError(t, err)
+	forged := strings.Join(append(strings.Split(otherToken, ".")[:3], parts[3]), ".")
+
+	tests := map[string]struct {
+		token string
+		err   error
+	}{
+		"forged claims":   {forged, ErrInvalidSignature},
+		"truncated":       {strings.Join(parts[:3], "."), ErrMalformed},
+		"unknown version": {"v2" + strings.TrimPrefix(token, "v1"), ErrMalformed},
+		"unknown key":     {strings.Replace(token, ".k1.", ".k2.", 1), ErrUnknownKey},
+		"empty":           {"", ErrMalformed},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := signer.Verify(tt.token)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+
+	signer.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = signer.Verify(token)
+	assert.Equal(t, ErrExpired, err)
+
+	var unconfigured *Signer
+	_, err = unconfigured.Sign(testClaims())
+	assert.Equal(t, ErrNoKeys, err)
+	_, err = unconfigured.Verify(token)
+	assert.Equal(t, ErrNoKeys, err)
+}
+
+// TestSigner_Rotation tests that tokens signed with a retired key verify while it is configured
+func TestSigner_Rotation(t *testing.T) {
+	oldSigner, err := NewSigner("k1:" + testKey)
+	assert.NoError(t, err)
+	oldToken, err := oldSigner.Sign(testClaims())
+	assert.NoError(t, err)
+
+	rotated, err := NewSigner("k2:" + rotatedKey + ", k1:" + testKey)
+	assert.NoError(t, err)
+
+	newToken, err := rotated.Sign(testClaims())
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(newToken, "v1.k2."))
+
+	_, err = rotated.Verify(oldToken)
+	assert.NoError(t, err)
+	_, err = rotated.Verify(newToken)
+	assert.NoError(t, err)
+
+	// Once the old key is dropped its tokens no longer verify
+	retired, err := NewSigner("k2:" + rotatedKey)
+	assert.NoError(t, err)
+	_, err = retired.Verify(oldToken)
+	assert.Equal(t, ErrUnknownKey, err)
+}
+
+// TestNewSigner_InvalidKeys tests the validation of configured keys
+func TestNewSigner_InvalidKeys(t *testing.T) {
+	tests := map[string]string{
+		"empty":         "",
+		"missing kid":   ":" + testKey,
+		"missing colon": testKey,
+		"dot in kid":    "k.1:" + testKey,
+		"short secret":  "k1:secret",
+		"duplicate kid": "k1:" + testKey + ",k1:" + rotatedKey,
+	}
+	for name, keys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewSigner(keys)
+			assert.Error(t, err)
+		})
+	}
+}